@@ -1,9 +1,6 @@
 package tracer
 
-import (
-	"errors"
-	"math"
-)
+import "math"
 
 type AABB struct {
 	Min, Max Point3
@@ -66,10 +63,26 @@ func (a AABB) Surrounding(b AABB) AABB {
 	return AABB{small, big}
 }
 
-func (a AABB) Compare(b AABB, axis int) bool {
-	if a.Zero() || b.Zero() {
-		panic(errors.New("HAHAHA"))
+// LongestAxis returns the axis along which the box is the largest.
+func (a AABB) LongestAxis() int {
+	extent := Vec3(a.Max).Sub(Vec3(a.Min))
+	switch {
+	case extent[0] >= extent[1] && extent[0] >= extent[2]:
+		return 0
+	case extent[1] >= extent[2]:
+		return 1
+	default:
+		return 2
 	}
+}
 
+func (a AABB) Compare(b AABB, axis int) bool {
 	return a.Min[axis] < b.Min[axis]
 }
+
+// Pad grows the box by delta on every side, so boxes of flat primitives (e.g.
+// axis aligned triangles) still have some volume to be hit.
+func (a AABB) Pad(delta float64) AABB {
+	d := Vec3{delta, delta, delta}
+	return AABB{Point3(Vec3(a.Min).Sub(d)), Point3(Vec3(a.Max).Add(d))}
+}
@@ -0,0 +1,126 @@
+package tracer
+
+import "math"
+
+// DefaultMinSamplesPerPixel is the minimum number of samples of a pixel
+// before adaptive sampling considers it converged.
+const DefaultMinSamplesPerPixel = 16
+
+// adaptiveBatchSize is how many samples adaptive sampling takes between
+// convergence checks.
+const adaptiveBatchSize = 8
+
+// pixelEstimate is the running estimate of a pixel: the sum of its samples,
+// and the sums of their luminance and squared luminance to track its variance.
+type pixelEstimate struct {
+	sum              Vec3
+	lumSum, lumSumSq float64
+	n                int
+	aux              auxEstimate
+}
+
+// add adds a sample. Like AvgSamples, transparent samples count as black.
+func (e *pixelEstimate) add(sample Color) {
+	e.n++
+	if sample.Transparent() {
+		return
+	}
+	e.sum = e.sum.Add(Vec3(sample))
+	lum := sample.Luminance()
+	e.lumSum += lum
+	e.lumSumSq += lum * lum
+}
+
+func (e *pixelEstimate) merge(o pixelEstimate) {
+	e.sum = e.sum.Add(o.sum)
+	e.lumSum += o.lumSum
+	e.lumSumSq += o.lumSumSq
+	e.n += o.n
+	e.aux.merge(o.aux)
+}
+
+func (e pixelEstimate) mean() Color {
+	if e.n == 0 {
+		return Color{}
+	}
+	return Color(e.sum.MulFloat(1.0 / float64(e.n)))
+}
+
+// relativeError is the standard error of the mean luminance relative to the
+// mean itself. Dark pixels are compared to a floor, so pure noise around
+// black doesn't need an endless amount of samples to converge.
+func (e pixelEstimate) relativeError() float64 {
+	if e.n < 2 {
+		return math.Inf(+1)
+	}
+
+	n := float64(e.n)
+	mean := e.lumSum / n
+	variance := math.Max(0, (e.lumSumSq-mean*e.lumSum)/(n-1))
+
+	return math.Sqrt(variance/n) / math.Max(mean, 1e-3)
+}
+
+// converged tells if adaptive sampling can stop sampling the pixel.
+func (e pixelEstimate) converged(settings *RenderSettings) bool {
+	return e.n >= settings.minSamplesPerPixel() && e.relativeError() < settings.AdaptiveThreshold
+}
+
+func (settings *RenderSettings) minSamplesPerPixel() int {
+	min := settings.MinSamplesPerPixel
+	if min <= 0 {
+		min = DefaultMinSamplesPerPixel
+	}
+	if min > settings.SamplesPerPixel {
+		min = settings.SamplesPerPixel
+	}
+	return min
+}
+
+// sampleAdaptive samples the pixel at row, col in batches until it converges
+// or gets settings.SamplesPerPixel samples. samples is scratch space for a
+// batch.
+func sampleAdaptive(settings *RenderSettings, row, col int, samples []Color, state *RayState) pixelEstimate {
+	var e pixelEstimate
+
+	batch := settings.minSamplesPerPixel()
+	for e.n < settings.SamplesPerPixel {
+		if left := settings.SamplesPerPixel - e.n; batch > left {
+			batch = left
+		}
+
+		samplePixel(settings, row, col, e.n, samples[:batch], &e.aux, state)
+		for _, sample := range samples[:batch] {
+			e.add(sample)
+		}
+
+		if e.converged(settings) {
+			break
+		}
+		batch = adaptiveBatchSize
+	}
+
+	return e
+}
+
+// HeatColor maps t in [0, 1] to a blue (cold) to red (hot) gradient, for debug
+// frames such as RenderSettings.SampleHeatmap.
+func HeatColor(t float64) Color {
+	t = Clamp(t, 0, 1)
+	switch {
+	case t < 0.5:
+		// blue to green
+		return Color{0, 2 * t, 1 - 2*t}
+	default:
+		// green to red
+		return Color{2*t - 1, 2 - 2*t, 0}
+	}
+}
+
+// heatmapColor is the heatmap color of a pixel that got n samples out of max.
+func heatmapColor(n, max int) Color {
+	if max <= 0 {
+		return Color{}
+	}
+	return HeatColor(float64(n) / float64(max))
+}
@@ -0,0 +1,91 @@
+package tracer
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAdaptiveSampling(t *testing.T) {
+	renderer := testRenderer(t, 2)
+	const width, height, samplesPerPixel = 6, 4, 64
+
+	random := rand.New(rand.NewSource(1))
+	var mu sync.Mutex
+
+	tests := []struct {
+		name    string
+		noisy   bool
+		samples int
+	}{
+		{"constant", false, DefaultMinSamplesPerPixel},
+		{"noisy", true, samplesPerPixel},
+	}
+
+	for _, test := range tests {
+		var samples uint64
+		settings := testFlatSettings(width, height, func(*RayState) {
+			atomic.AddUint64(&samples, 1)
+		})
+		if test.noisy {
+			settings.Integrator = testIntegrator(func(*RayState) Color {
+				atomic.AddUint64(&samples, 1)
+				mu.Lock()
+				defer mu.Unlock()
+				if random.Intn(2) == 0 {
+					return Color{}
+				}
+				return Color{1, 1, 1}
+			})
+		}
+		settings.SamplesPerPixel = samplesPerPixel
+		settings.AdaptiveThreshold = 0.01
+		settings.SampleHeatmap = NewFrame(width, height, false)
+
+		if _, err := renderer.RenderContext(context.Background(), settings); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := uint64(width * height * test.samples); samples != want {
+			t.Errorf("%s: %d samples, want %d", test.name, samples, want)
+		}
+		heat := HeatColor(float64(test.samples) / samplesPerPixel)
+		for row := 0; row < height; row++ {
+			for col := 0; col < width; col++ {
+				if got := settings.SampleHeatmap.Get(row, col); got != heat {
+					t.Fatalf("%s: (%d, %d) heat %v, want %v", test.name, row, col, got, heat)
+				}
+			}
+		}
+	}
+}
+
+func TestPixelEstimateRelativeError(t *testing.T) {
+	var e pixelEstimate
+	for i := 0; i < 100; i++ {
+		e.add(Color{0.5, 0.5, 0.5})
+	}
+	if err := e.relativeError(); err != 0 {
+		t.Errorf("relative error %v of a constant, want 0", err)
+	}
+
+	e = pixelEstimate{}
+	for i := 0; i < 100; i++ {
+		e.add(Color{float64(i % 2), float64(i % 2), float64(i % 2)})
+	}
+	// a standard error of ~0.5/sqrt(100), relative to a mean of 0.5
+	if err := e.relativeError(); !approxEqual(err, 0.1, 0.001) {
+		t.Errorf("relative error %v, want ~0.1", err)
+	}
+}
+
+func TestHeatColor(t *testing.T) {
+	tests := map[float64]Color{-1: {0, 0, 1}, 0: {0, 0, 1}, 0.5: {0, 1, 0}, 1: {1, 0, 0}, 2: {1, 0, 0}}
+	for v, want := range tests {
+		if got := HeatColor(v); got != want {
+			t.Errorf("HeatColor(%v) = %v, want %v", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tracer
+
+// auxEstimate is the running sum of the auxiliary outputs of a pixel's
+// samples, see IntegratorSample.
+type auxEstimate struct {
+	albedo, normal Vec3
+	depth          float64
+	n              int
+}
+
+func (a *auxEstimate) add(s IntegratorSample) {
+	a.albedo = a.albedo.Add(Vec3(s.Albedo))
+	a.normal = a.normal.Add(s.Normal)
+	a.depth += s.Depth
+	a.n++
+}
+
+func (a *auxEstimate) merge(o auxEstimate) {
+	a.albedo = a.albedo.Add(o.albedo)
+	a.normal = a.normal.Add(o.normal)
+	a.depth += o.depth
+	a.n += o.n
+}
+
+func (a auxEstimate) meanAlbedo() Color {
+	if a.n == 0 {
+		return Color{}
+	}
+	return Color(a.albedo.MulFloat(1 / float64(a.n)))
+}
+
+// meanNormal is the unit average normal, zero when the samples hit nothing.
+func (a auxEstimate) meanNormal() Color {
+	if a.normal.NearZero() {
+		return Color{}
+	}
+	return Color(a.normal.Unit())
+}
+
+func (a auxEstimate) meanDepth() Color {
+	if a.n == 0 {
+		return Color{}
+	}
+	depth := a.depth / float64(a.n)
+	return Color{depth, depth, depth}
+}
+
+func (settings *RenderSettings) wantsAux() bool {
+	return settings.AlbedoFrame != nil || settings.NormalFrame != nil || settings.DepthFrame != nil
+}
+
+// auxTile buffers the auxiliary outputs of the pixels of a tile, row after
+// row, for the auxiliary frames of the render.
+type auxTile struct {
+	albedo, normal, depth []Color
+}
+
+func newAuxTile(tile Tile) *auxTile {
+	n := tile.Width() * tile.Height()
+	return &auxTile{
+		albedo: make([]Color, 0, n),
+		normal: make([]Color, 0, n),
+		depth:  make([]Color, 0, n),
+	}
+}
+
+func (t *auxTile) add(a auxEstimate) {
+	t.albedo = append(t.albedo, a.meanAlbedo())
+	t.normal = append(t.normal, a.meanNormal())
+	t.depth = append(t.depth, a.meanDepth())
+}
+
+// set writes the tile into the auxiliary frames of settings.
+func (t *auxTile) set(settings *RenderSettings, tile Tile) {
+	for _, aux := range []struct {
+		frame  *Frame
+		colors []Color
+	}{
+		{settings.AlbedoFrame, t.albedo},
+		{settings.NormalFrame, t.normal},
+		{settings.DepthFrame, t.depth},
+	} {
+		if aux.frame != nil {
+			aux.frame.SetTile(tile, aux.colors)
+		}
+	}
+}
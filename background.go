@@ -0,0 +1,82 @@
+package tracer
+
+import "math"
+
+// Background is the light coming from the direction of rays escaping the
+// scene.
+type Background interface {
+	Emitted(Ray) Color
+}
+
+// DefaultBackground is a white to light blue sky.
+var DefaultBackground Background = GradientBackground{
+	Bottom: Color{1, 1, 1},
+	Top:    Color{0.5, 0.7, 1.0},
+}
+
+// SolidBackground is the same color in every direction, e.g. black for
+// interior scenes lit by emissive materials only.
+type SolidBackground struct {
+	Color Color
+}
+
+func (b SolidBackground) Emitted(Ray) Color {
+	return b.Color
+}
+
+// GradientBackground blends linearly from Bottom, straight down, to Top,
+// straight up.
+type GradientBackground struct {
+	Bottom, Top Color
+}
+
+func (b GradientBackground) Emitted(ray Ray) Color {
+	unitDirection := ray.Direction.Unit()
+	t := 0.5 * (unitDirection[1] + 1.0)
+	return Color(Vec3(b.Bottom).MulFloat(1.0 - t).Add(Vec3(b.Top).MulFloat(t)))
+}
+
+// EnvironmentMap is an equirectangular (latitude/longitude) image of the
+// light around the scene, +Y being up. The center of the image looks
+// towards -Z.
+type EnvironmentMap struct {
+	// linear radiance
+	image colorImage
+
+	// Rotation around the vertical axis, in degrees
+	Rotation float64
+	// Scale of the image's radiance, 1 when zero
+	Intensity float64
+}
+
+// NewEnvironmentMap makes an environment map out of linear radiance values,
+// given row after row starting from the top.
+func NewEnvironmentMap(width, height int, pixels []Color) (*EnvironmentMap, error) {
+	img, err := newColorImage(width, height, pixels)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvironmentMap{image: img}, nil
+}
+
+// LoadEnvironmentMap reads an environment map from an image file, see
+// loadColorImage.
+func LoadEnvironmentMap(path string) (*EnvironmentMap, error) {
+	img, err := loadColorImage(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvironmentMap{image: img}, nil
+}
+
+func (m *EnvironmentMap) Emitted(ray Ray) Color {
+	d := ray.Direction.Unit()
+
+	phi := math.Atan2(d[0], -d[2]) - DegreesToRadians(m.Rotation)
+	theta := math.Acos(Clamp(d[1], -1, 1))
+
+	u := phi/(2*math.Pi) + 0.5
+	v := theta / math.Pi
+
+	return scaledColor(m.image.bilinear(u, v, WrapRepeat, WrapClamp), m.Intensity)
+}
@@ -0,0 +1,58 @@
+package tracer
+
+import "testing"
+
+func TestGradientBackground(t *testing.T) {
+	b := GradientBackground{Bottom: Color{1, 0, 0}, Top: Color{0, 0, 1}}
+	tests := []struct {
+		direction Vec3
+		want      Color
+	}{
+		{Vec3{0, -2, 0}, Color{1, 0, 0}},
+		{Vec3{0, 2, 0}, Color{0, 0, 1}},
+		{Vec3{3, 0, 0}, Color{0.5, 0, 0.5}},
+	}
+	for _, test := range tests {
+		if got := b.Emitted(Ray{Direction: test.direction}); !approxVec3(Vec3(got), Vec3(test.want), testEpsilon) {
+			t.Errorf("%v: %v, want %v", test.direction, got, test.want)
+		}
+	}
+}
+
+func TestEnvironmentMap(t *testing.T) {
+	// a single row, each pixel a quarter of the horizon from the left
+	pixels := []Color{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 1}}
+	m, err := NewEnvironmentMap(4, 1, pixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		direction Vec3
+		rotation  float64
+		intensity float64
+		want      Color
+	}{
+		// the center of the image, between pixels 1 and 2, is towards -Z
+		{Vec3{0, 0, -1}, 0, 0, Color{0, 0.5, 0.5}},
+		{Vec3{-1, 0, -1}, 0, 0, pixels[1]},
+		{Vec3{1, 0, -1}, 0, 0, pixels[2]},
+		{Vec3{1, 0, 1}, 0, 0, pixels[3]},
+		{Vec3{-1, 0, 1}, 0, 0, pixels[0]},
+		// a single row is the same up and down
+		{Vec3{1, 5, -1}, 0, 0, pixels[2]},
+		{Vec3{1, 0, 1}, 90, 0, pixels[2]},
+		{Vec3{1, 0, 1}, 0, 2, Color{2, 2, 2}},
+	}
+
+	for _, test := range tests {
+		m.Rotation, m.Intensity = test.rotation, test.intensity
+		if got := m.Emitted(Ray{Direction: test.direction}); !approxVec3(Vec3(got), Vec3(test.want), 1e-9) {
+			t.Errorf("%v, rotated %v, intensity %v: %v, want %v", test.direction, test.rotation, test.intensity, got, test.want)
+		}
+	}
+
+	if _, err := NewEnvironmentMap(2, 2, pixels[:3]); err == nil {
+		t.Error("made an environment map with missing pixels")
+	}
+}
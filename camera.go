@@ -1,17 +1,13 @@
 package tracer
 
-import (
-	"math"
-
-	"lukechampine.com/frand"
-)
+import "math"
 
 type Camera struct {
-	AspectRatio float64
-	VFoV        float64 // vertical field-of-view in degrees
-	LookFrom    Point3
-	LookAt      Point3
-	VUp         Vec3
+	AspectRatio float64 `json:"aspect_ratio"`
+	VFoV        float64 `json:"vfov"` // vertical field-of-view in degrees
+	LookFrom    Point3  `json:"look_from"`
+	LookAt      Point3  `json:"look_at"`
+	VUp         Vec3    `json:"vup"`
 
 	lowerLeftCorner      Vec3
 	horizontal, vertical Vec3
@@ -48,8 +44,9 @@ func CameraCoordinatesFromPixel(row, col, frameWidth, frameHeight int) (float64,
 	return u, v
 }
 
-func JitteredCameraCoordinatesFromPixel(row, col, frameWidth, frameHeight int) (float64, float64) {
-	u := (float64(col) + frand.Float64()) / float64(frameWidth-1)
-	v := (float64(row) + frand.Float64()) / float64(frameHeight-1)
+func JitteredCameraCoordinatesFromPixel(row, col, frameWidth, frameHeight int, sampler Sampler) (float64, float64) {
+	du, dv := sampler.Get2D()
+	u := (float64(col) + du) / float64(frameWidth-1)
+	v := (float64(row) + dv) / float64(frameHeight-1)
 	return u, v
 }
@@ -0,0 +1,52 @@
+// gob2json converts gob scenes (as written by cmd/tracer) into JSON scenes,
+// written next to them with a .json extension.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ghostec/tracer"
+)
+
+var overwrite = flag.Bool("overwrite", false, "overwrite existing .json scenes")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] scene.gob...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, src := range flag.Args() {
+		if err := convert(src); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func convert(src string) error {
+	if !strings.HasSuffix(src, ".gob") {
+		return fmt.Errorf("%s: not a .gob scene", src)
+	}
+	dst := strings.TrimSuffix(src, ".gob") + ".json"
+
+	if _, err := os.Stat(dst); err == nil && !*overwrite {
+		return fmt.Errorf("%s already exists, use -overwrite to replace it", dst)
+	}
+
+	scene, err := tracer.LoadScene(src)
+	if err != nil {
+		return err
+	}
+
+	return tracer.SaveScene(dst, scene)
+}
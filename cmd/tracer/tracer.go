@@ -2,16 +2,18 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
 
 	"github.com/ghostec/tracer"
 )
@@ -20,15 +22,22 @@ var width = flag.Int("width", 300, "image width")
 var aspectRatio = flag.Float64("aspect-ratio", 1.0, "image width")
 var parallelism = flag.Int("parallelism", runtime.NumCPU(), "number of render routines to run")
 var cpuProfile = flag.String("cpu-profile", "", "write cpu profile to file")
+var tileOrder = flag.String("tile-order", "rows", "job scheduling: rows, scanline, spiral or hilbert")
+var tileSize = flag.Int("tile-size", tracer.DefaultTileSize, "tile size, in pixels, of the tiled orders")
+var adaptiveThreshold = flag.Float64("adaptive-threshold", 0, "sample pixels until their relative error drops below this threshold")
+var heatmap = flag.Bool("heatmap", false, "with -adaptive-threshold, also save a heatmap of the samples per pixel")
+var samplerKind = flag.String("sampler", "independent", "sampler: independent, stratified, halton or sobol")
+var seed = flag.Uint64("seed", 0, "seed of the render's random numbers")
+var samplesPerPass = flag.Int("samples-per-pass", 0, "render progressively, saving the frame after every pass of this many samples")
+var integrator = flag.String("integrator", "mis", "integrator: path, mis to also sample lights directly, or distance")
+var aux = flag.Bool("aux", false, "also save the albedo and normals of every frame")
+var environment = flag.String("environment", "", "equirectangular environment map (.hdr, .png or .jpg) lighting the scenes")
+var environmentRotation = flag.Float64("environment-rotation", 0, "rotation of the environment map around the vertical axis, in degrees")
+var environmentIntensity = flag.Float64("environment-intensity", 1, "scale of the environment map's radiance")
 
 func main() {
 	flag.Parse()
 
-	gob.Register(tracer.Sphere{})
-	gob.Register(tracer.Lambertian{})
-	gob.Register(tracer.Metal{})
-	gob.Register(tracer.Dielectric{})
-
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
@@ -38,6 +47,32 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	order, err := tracer.ParseTileOrder(*tileOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sampler, err := tracer.ParseSamplerKind(*samplerKind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	newIntegrator, ok := integrators[*integrator]
+	if !ok {
+		log.Fatalf("unknown integrator %q", *integrator)
+	}
+
+	var background tracer.Background
+	if *environment != "" {
+		envMap, err := tracer.LoadEnvironmentMap(*environment)
+		if err != nil {
+			log.Fatal(err)
+		}
+		envMap.Rotation = *environmentRotation
+		envMap.Intensity = *environmentIntensity
+		background = envMap
+	}
+
 	tracer.DefaultRenderer.Start()
 
 	// generateScenes(10, 0)
@@ -49,14 +84,22 @@ func main() {
 		panic(err)
 	}
 
+	// on interrupt, save what's been rendered of the current scene and quit
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
 	for i, scene := range scenes {
-		render(fmt.Sprintf("frames/%d.png", i), scene)
+		if err := render(ctx, fmt.Sprintf("frames/%d.png", i), scene, order, sampler, newIntegrator(), background); err != nil {
+			log.Print(err)
+			return
+		}
 	}
 }
 
-type Scene struct {
-	Camera     tracer.Camera
-	HitterList tracer.HitterList
+var integrators = map[string]func() tracer.Integrator{
+	"path":     func() tracer.Integrator { return &tracer.PathIntegrator{} },
+	"mis":      func() tracer.Integrator { return &tracer.MISIntegrator{} },
+	"distance": func() tracer.Integrator { return &tracer.DistanceIntegrator{} },
 }
 
 func randSign() float64 {
@@ -100,9 +143,7 @@ func generateScenes(amount, startIdx int) {
 
 		var buf bytes.Buffer
 
-		enc := gob.NewEncoder(&buf)
-
-		if err := enc.Encode(&Scene{
+		if err := tracer.EncodeGobScene(&buf, &tracer.Scene{
 			Camera:     cam,
 			HitterList: l,
 		}); err != nil {
@@ -115,60 +156,141 @@ func generateScenes(amount, startIdx int) {
 	}
 }
 
-func loadScenes() (scenes []Scene, err error) {
+// loadScenes loads the .gob and .json scenes in the scenes directory. A scene
+// saved in both formats (e.g. by gob2json) is loaded once, from its .json.
+func loadScenes() (scenes []*tracer.Scene, err error) {
+	var paths []string
+	jsonScenes := map[string]bool{}
 	if err := filepath.Walk("scenes", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !strings.HasSuffix(path, ".gob") {
+		switch filepath.Ext(path) {
+		case ".json":
+			jsonScenes[strings.TrimSuffix(path, ".json")] = true
+		case ".gob":
+		default:
 			return nil
 		}
 
-		b, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
+		paths = append(paths, path)
 
-		var scene Scene
+		return nil
+	}); err != nil {
+		return nil, err
+	}
 
-		dec := gob.NewDecoder(bytes.NewReader(b))
-		if err := dec.Decode(&scene); err != nil {
-			return err
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".gob") && jsonScenes[strings.TrimSuffix(path, ".gob")] {
+			continue
 		}
 
-		scenes = append(scenes, scene)
+		scene, err := tracer.LoadScene(path)
+		if err != nil {
+			return nil, err
+		}
 
-		return nil
-	}); err != nil {
-		return nil, err
+		scenes = append(scenes, scene)
 	}
 
 	return scenes, nil
 }
 
-func render(dst string, scene Scene) {
+func render(ctx context.Context, dst string, scene *tracer.Scene, order tracer.TileOrder, sampler tracer.SamplerKind, integrator tracer.Integrator, background tracer.Background) error {
 	imageWidth := *width
+	if scene.Settings.Width > 0 {
+		imageWidth = scene.Settings.Width
+	}
 	imageHeight := int(float64(imageWidth) / *aspectRatio)
 
+	samplesPerPixel := 512
+	if scene.Settings.SamplesPerPixel > 0 {
+		samplesPerPixel = scene.Settings.SamplesPerPixel
+	}
+
+	maxDepth := 20
+	if scene.Settings.MaxDepth > 0 {
+		maxDepth = scene.Settings.MaxDepth
+	}
+
 	frame := tracer.NewFrame(imageWidth, imageHeight, false)
 
-	bvh, err := tracer.NewBVHNode(scene.HitterList)
+	var heatmapFrame *tracer.Frame
+	if *heatmap {
+		heatmapFrame = tracer.NewFrame(imageWidth, imageHeight, false)
+	}
+
+	var albedoFrame, normalFrame *tracer.Frame
+	if *aux {
+		albedoFrame = tracer.NewFrame(imageWidth, imageHeight, false)
+		normalFrame = tracer.NewFrame(imageWidth, imageHeight, false)
+	}
+
+	bvh, err := tracer.NewBVHNode(scene.Hitters())
 	if err != nil {
 		panic(err)
 	}
 
-	tracer.Render(tracer.RenderSettings{
-		Frame:           frame,
-		Camera:          &scene.Camera,
-		Hitter:          bvh,
-		RayColorFunc:    tracer.RayColor,
-		AggColorFunc:    tracer.AvgSamples,
-		SamplesPerPixel: 512,
-		MaxDepth:        20,
-	}, make(chan bool, 1))
+	stats, renderErr := tracer.RenderContext(ctx, tracer.RenderSettings{
+		Frame:             frame,
+		Camera:            &scene.Camera,
+		Hitter:            bvh,
+		Lights:            scene.Lights,
+		Integrator:        integrator,
+		AggColorFunc:      tracer.AvgSamples,
+		SamplesPerPixel:   samplesPerPixel,
+		MaxDepth:          maxDepth,
+		TileOrder:         order,
+		TileSize:          *tileSize,
+		Sampler:           sampler,
+		Seed:              *seed,
+		Background:        background,
+		SamplesPerPass:    *samplesPerPass,
+		AdaptiveThreshold: *adaptiveThreshold,
+		SampleHeatmap:     heatmapFrame,
+		AlbedoFrame:       albedoFrame,
+		NormalFrame:       normalFrame,
+		OnPass: func(pass int, snapshot *tracer.Frame) {
+			if err := snapshot.Save(dst); err != nil {
+				log.Print(err)
+			}
+		},
+		Progress: func(p tracer.Progress) {
+			fmt.Fprintf(os.Stderr, "\r%s: %d/%d tiles, ETA %s   ", dst, p.Done, p.Total, p.ETA.Round(time.Second))
+		},
+	})
+	fmt.Fprintln(os.Stderr)
+
+	log.Printf("%s: %s, %d primary rays, %d rays (%.0f/s), %d BVH node visits",
+		dst, stats.Elapsed.Round(time.Millisecond), stats.PrimaryRays, stats.Rays, stats.RaysPerSecond(), stats.NodeVisits)
 
 	if err := frame.Save(dst); err != nil {
 		panic(err)
 	}
+
+	if heatmapFrame != nil {
+		if err := heatmapFrame.Save(strings.TrimSuffix(dst, ".png") + "-heatmap.png"); err != nil {
+			panic(err)
+		}
+	}
+
+	if *aux {
+		// from [-1, 1] to displayable colors
+		for row := 0; row < imageHeight; row++ {
+			for col := 0; col < imageWidth; col++ {
+				n := tracer.Vec3(normalFrame.Get(row, col))
+				normalFrame.Set(row, col, tracer.Color(n.MulFloat(0.5).Add(tracer.Vec3{0.5, 0.5, 0.5})))
+			}
+		}
+
+		if err := albedoFrame.Save(strings.TrimSuffix(dst, ".png") + "-albedo.png"); err != nil {
+			panic(err)
+		}
+		if err := normalFrame.Save(strings.TrimSuffix(dst, ".png") + "-normal.png"); err != nil {
+			panic(err)
+		}
+	}
+
+	return renderErr
 }
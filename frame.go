@@ -36,6 +36,18 @@ func (frame *Frame) Set(row, col int, color Color) {
 	frame.content[row][col] = color
 }
 
+// SetTile sets all the pixels of tile at once, from colors holding them row
+// after row.
+func (frame *Frame) SetTile(tile Tile, colors []Color) {
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+
+	for row := tile.Row0; row < tile.Row1; row++ {
+		i := (row - tile.Row0) * tile.Width()
+		copy(frame.content[row][tile.Col0:tile.Col1], colors[i:i+tile.Width()])
+	}
+}
+
 func (frame *Frame) Get(row, col int) Color {
 	frame.mu.Lock()
 	defer frame.mu.Unlock()
@@ -43,6 +55,22 @@ func (frame *Frame) Get(row, col int) Color {
 	return frame.content[row][col]
 }
 
+// Clone returns a copy of the frame.
+func (frame *Frame) Clone() *Frame {
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+
+	content := make([][]Color, len(frame.content))
+	for row := range frame.content {
+		content[row] = append([]Color(nil), frame.content[row]...)
+	}
+
+	return &Frame{
+		content: content,
+		samples: frame.samples,
+	}
+}
+
 func (frame *Frame) Width() int {
 	return len(frame.content[0])
 }
@@ -86,7 +114,7 @@ func (frame *Frame) Avg(other *Frame) error {
 }
 
 func (frame *Frame) Save(path string) error {
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0755)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
 	}
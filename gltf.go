@@ -0,0 +1,790 @@
+package tracer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultGLTFMaterial is used by primitives without a material, glTF's
+// default of a rough white metal.
+var DefaultGLTFMaterial Material = Principled{BaseColor: Color{1, 1, 1}, Metallic: 1, Roughness: 1, Specular: 0.5}
+
+// LoadGLTF reads a glTF 2.0 file, .gltf with its buffers and images or binary
+// .glb, into one TriangleMesh per mesh primitive instanced by the nodes of its
+// default scene, transformed into world space. Points and lines are skipped,
+// and cameras, lights and animations ignored.
+//
+// Metallic-roughness materials map onto Principled ones, with their
+// KHR_materials_transmission, KHR_materials_ior, KHR_materials_clearcoat and
+// KHR_materials_emissive_strength extensions, and normal textures onto
+// NormalMapped. Textures are mapped with TEXCOORD_0 and must be image files
+// next to the scene, as ImageTexture loads images by path.
+func LoadGLTF(path string) (HitterList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &gltfLoader{
+		name:      path,
+		dir:       filepath.Dir(path),
+		materials: map[int]Material{},
+		textures:  map[gltfTextureKey]*ImageTexture{},
+	}
+	if err := l.load(data); err != nil {
+		return nil, err
+	}
+	return l.list, nil
+}
+
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Textures    []gltfTexture    `json:"textures"`
+	Images      []gltfImage      `json:"images"`
+	Samplers    []gltfSampler    `json:"samplers"`
+}
+
+type gltfNode struct {
+	Children    []int        `json:"children"`
+	Mesh        *int         `json:"mesh"`
+	Matrix      *[16]float64 `json:"matrix"`
+	Translation *[3]float64  `json:"translation"`
+	Rotation    *[4]float64  `json:"rotation"`
+	Scale       *[3]float64  `json:"scale"`
+}
+
+type gltfMesh struct {
+	Primitives []struct {
+		Attributes map[string]int `json:"attributes"`
+		Indices    *int           `json:"indices"`
+		Material   *int           `json:"material"`
+		Mode       *int           `json:"mode"`
+	} `json:"primitives"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfMaterial struct {
+	PBRMetallicRoughness struct {
+		BaseColorFactor          *[4]float64      `json:"baseColorFactor"`
+		BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor           *float64         `json:"metallicFactor"`
+		RoughnessFactor          *float64         `json:"roughnessFactor"`
+		MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+	} `json:"pbrMetallicRoughness"`
+	NormalTexture   *gltfTextureInfo `json:"normalTexture"`
+	EmissiveTexture *gltfTextureInfo `json:"emissiveTexture"`
+	EmissiveFactor  Color            `json:"emissiveFactor"`
+	Extensions      struct {
+		Transmission *struct {
+			TransmissionFactor  float64          `json:"transmissionFactor"`
+			TransmissionTexture *gltfTextureInfo `json:"transmissionTexture"`
+		} `json:"KHR_materials_transmission"`
+		IOR *struct {
+			IOR *float64 `json:"ior"`
+		} `json:"KHR_materials_ior"`
+		Clearcoat *struct {
+			ClearcoatFactor          float64 `json:"clearcoatFactor"`
+			ClearcoatRoughnessFactor float64 `json:"clearcoatRoughnessFactor"`
+		} `json:"KHR_materials_clearcoat"`
+		EmissiveStrength *struct {
+			EmissiveStrength *float64 `json:"emissiveStrength"`
+		} `json:"KHR_materials_emissive_strength"`
+	} `json:"extensions"`
+}
+
+type gltfTexture struct {
+	Sampler *int `json:"sampler"`
+	Source  *int `json:"source"`
+}
+
+type gltfImage struct {
+	URI string `json:"uri"`
+}
+
+type gltfSampler struct {
+	WrapS int `json:"wrapS"`
+}
+
+// gltfTextureKey identifies the ImageTextures of a file, an image being
+// loaded once per wrap mode and color space it's used with.
+type gltfTextureKey struct {
+	image  int
+	wrap   WrapMode
+	linear bool
+}
+
+type gltfLoader struct {
+	name string
+	dir  string
+
+	doc gltfDocument
+	// the binary chunk of .glb files
+	bin     []byte
+	buffers [][]byte
+
+	// by index
+	materials map[int]Material
+	textures  map[gltfTextureKey]*ImageTexture
+
+	list HitterList
+}
+
+func (l *gltfLoader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", l.name, fmt.Sprintf(format, args...))
+}
+
+const (
+	glbMagic     = 0x46546c67 // "glTF"
+	glbChunkJSON = 0x4e4f534a // "JSON"
+	glbChunkBIN  = 0x004e4942 // "BIN\x00"
+)
+
+func (l *gltfLoader) load(data []byte) error {
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == glbMagic {
+		var err error
+		if data, err = l.splitGLB(data); err != nil {
+			return err
+		}
+	}
+
+	if err := json.Unmarshal(data, &l.doc); err != nil {
+		return l.errorf("%v", err)
+	}
+	if !strings.HasPrefix(l.doc.Asset.Version, "2.") {
+		return l.errorf("unsupported glTF version %q", l.doc.Asset.Version)
+	}
+
+	l.buffers = make([][]byte, len(l.doc.Buffers))
+	for i := range l.doc.Buffers {
+		b, err := l.buffer(i)
+		if err != nil {
+			return err
+		}
+		l.buffers[i] = b
+	}
+
+	roots, err := l.roots()
+	if err != nil {
+		return err
+	}
+	visited := make([]bool, len(l.doc.Nodes))
+	for _, root := range roots {
+		if err := l.node(root, gltfIdentity, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitGLB returns the JSON chunk of a .glb file, keeping its binary chunk.
+func (l *gltfLoader) splitGLB(data []byte) ([]byte, error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[4:]) != 2 {
+		return nil, l.errorf("unsupported binary glTF version")
+	}
+	length := int(binary.LittleEndian.Uint32(data[8:]))
+	if length > len(data) {
+		return nil, l.errorf("truncated binary glTF")
+	}
+	data = data[:length]
+
+	var jsonChunk []byte
+	for offset := 12; offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, l.errorf("truncated binary glTF chunk")
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		kind := binary.LittleEndian.Uint32(data[offset+4:])
+		offset += 8
+		if length < 0 || offset+length > len(data) {
+			return nil, l.errorf("truncated binary glTF chunk")
+		}
+		switch {
+		case kind == glbChunkJSON && jsonChunk == nil:
+			jsonChunk = data[offset : offset+length]
+		case kind == glbChunkBIN && l.bin == nil:
+			l.bin = data[offset : offset+length]
+		}
+		offset += length
+	}
+	if jsonChunk == nil {
+		return nil, l.errorf("binary glTF without a JSON chunk")
+	}
+	return jsonChunk, nil
+}
+
+// buffer reads a buffer from a data URI, a file next to the scene or, for
+// .glb files, the binary chunk.
+func (l *gltfLoader) buffer(i int) ([]byte, error) {
+	buffer := l.doc.Buffers[i]
+
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case buffer.URI == "":
+		if i != 0 || l.bin == nil {
+			return nil, l.errorf("buffer %d has no uri", i)
+		}
+		data = l.bin
+	case strings.HasPrefix(buffer.URI, "data:"):
+		comma := strings.IndexByte(buffer.URI, ',')
+		if comma < 0 || !strings.HasSuffix(buffer.URI[:comma], ";base64") {
+			return nil, l.errorf("buffer %d: unsupported data uri", i)
+		}
+		data, err = base64.StdEncoding.DecodeString(buffer.URI[comma+1:])
+	default:
+		var path string
+		if path, err = l.path(buffer.URI); err == nil {
+			data, err = os.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return nil, l.errorf("buffer %d: %v", i, err)
+	}
+
+	if len(data) < buffer.ByteLength {
+		return nil, l.errorf("buffer %d has %d bytes, want %d", i, len(data), buffer.ByteLength)
+	}
+	return data[:buffer.ByteLength], nil
+}
+
+// path is the file at uri, relative to the scene.
+func (l *gltfLoader) path(uri string) (string, error) {
+	if strings.HasPrefix(uri, "data:") {
+		return "", errors.New("embedded files aren't supported")
+	}
+	p, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(p)), nil
+}
+
+// roots are the root nodes of the default scene, or of every node when
+// there are no scenes.
+func (l *gltfLoader) roots() ([]int, error) {
+	if len(l.doc.Scenes) > 0 {
+		scene := 0
+		if l.doc.Scene != nil {
+			scene = *l.doc.Scene
+		}
+		if scene < 0 || scene >= len(l.doc.Scenes) {
+			return nil, l.errorf("scene %d out of range", scene)
+		}
+		return l.doc.Scenes[scene].Nodes, nil
+	}
+
+	child := make([]bool, len(l.doc.Nodes))
+	for _, node := range l.doc.Nodes {
+		for _, c := range node.Children {
+			if c >= 0 && c < len(child) {
+				child[c] = true
+			}
+		}
+	}
+	var roots []int
+	for i := range l.doc.Nodes {
+		if !child[i] {
+			roots = append(roots, i)
+		}
+	}
+	return roots, nil
+}
+
+func (l *gltfLoader) node(i int, parent gltfMatrix, visited []bool) error {
+	if i < 0 || i >= len(l.doc.Nodes) {
+		return l.errorf("node %d out of range", i)
+	}
+	if visited[i] {
+		return l.errorf("node %d is reached twice, nodes must form trees", i)
+	}
+	visited[i] = true
+
+	node := l.doc.Nodes[i]
+	transform := parent.mul(node.transform())
+
+	if node.Mesh != nil {
+		if err := l.mesh(*node.Mesh, transform); err != nil {
+			return l.errorf("node %d: %v", i, err)
+		}
+	}
+	for _, child := range node.Children {
+		if err := l.node(child, transform, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mesh appends the primitives of a mesh, with positions and normals
+// transformed.
+func (l *gltfLoader) mesh(i int, transform gltfMatrix) error {
+	if i < 0 || i >= len(l.doc.Meshes) {
+		return fmt.Errorf("mesh %d out of range", i)
+	}
+
+	for j, primitive := range l.doc.Meshes[i].Primitives {
+		mode := 4
+		if primitive.Mode != nil {
+			mode = *primitive.Mode
+		}
+		if mode < 4 {
+			// points and lines
+			continue
+		}
+
+		positionsAccessor, ok := primitive.Attributes["POSITION"]
+		if !ok {
+			return fmt.Errorf("mesh %d primitive %d has no positions", i, j)
+		}
+		values, err := l.accessor(positionsAccessor, "VEC3")
+		if err != nil {
+			return err
+		}
+		positions := make([]Point3, len(values)/3)
+		for k := range positions {
+			positions[k] = transform.point(Point3{values[3*k], values[3*k+1], values[3*k+2]})
+		}
+
+		var normals []Vec3
+		if accessor, ok := primitive.Attributes["NORMAL"]; ok {
+			if values, err = l.accessor(accessor, "VEC3"); err != nil {
+				return err
+			}
+			normalMatrix := transform.normalMatrix()
+			normals = make([]Vec3, len(values)/3)
+			for k := range normals {
+				normals[k] = normalMatrix.vector(Vec3{values[3*k], values[3*k+1], values[3*k+2]}).Unit()
+			}
+		}
+
+		var uvs [][2]float64
+		if accessor, ok := primitive.Attributes["TEXCOORD_0"]; ok {
+			if values, err = l.accessor(accessor, "VEC2"); err != nil {
+				return err
+			}
+			// glTF's v goes down images, ImageTexture's up
+			uvs = make([][2]float64, len(values)/2)
+			for k := range uvs {
+				uvs[k] = [2]float64{values[2*k], 1 - values[2*k+1]}
+			}
+		}
+
+		var indices []int
+		if primitive.Indices != nil {
+			if values, err = l.accessor(*primitive.Indices, "SCALAR"); err != nil {
+				return err
+			}
+			indices = make([]int, len(values))
+			for k := range values {
+				indices[k] = int(values[k])
+			}
+		} else {
+			indices = make([]int, len(positions))
+			for k := range indices {
+				indices[k] = k
+			}
+		}
+		if indices, err = gltfTriangles(indices, mode); err != nil {
+			return fmt.Errorf("mesh %d primitive %d: %v", i, j, err)
+		}
+		// mirroring transforms turn triangles inside out
+		if transform.determinant() < 0 {
+			for k := 0; k+2 < len(indices); k += 3 {
+				indices[k+1], indices[k+2] = indices[k+2], indices[k+1]
+			}
+		}
+
+		material := DefaultGLTFMaterial
+		if primitive.Material != nil {
+			if material, err = l.material(*primitive.Material); err != nil {
+				return err
+			}
+		}
+
+		mesh, err := NewTriangleMesh(positions, normals, uvs, indices, material)
+		if err != nil {
+			return fmt.Errorf("mesh %d primitive %d: %v", i, j, err)
+		}
+		l.list = append(l.list, mesh)
+	}
+	return nil
+}
+
+// gltfTriangles turns the indices of triangles (mode 4), a triangle strip
+// (5) or a triangle fan (6) into those of triangles.
+func gltfTriangles(indices []int, mode int) ([]int, error) {
+	switch mode {
+	case 4:
+		return indices, nil
+	case 5, 6:
+		var triangles []int
+		for k := 2; k < len(indices); k++ {
+			switch {
+			case mode == 6:
+				triangles = append(triangles, indices[0], indices[k-1], indices[k])
+			case k%2 == 0:
+				triangles = append(triangles, indices[k-2], indices[k-1], indices[k])
+			default:
+				triangles = append(triangles, indices[k-1], indices[k-2], indices[k])
+			}
+		}
+		return triangles, nil
+	}
+	return nil, fmt.Errorf("unknown primitive mode %d", mode)
+}
+
+var gltfComponents = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4}
+
+// accessor reads the values of an accessor of type typ, integers normalized
+// to [0, 1] or [-1, 1] if the accessor says so.
+func (l *gltfLoader) accessor(i int, typ string) ([]float64, error) {
+	if i < 0 || i >= len(l.doc.Accessors) {
+		return nil, fmt.Errorf("accessor %d out of range", i)
+	}
+	a := l.doc.Accessors[i]
+	if a.Type != typ {
+		return nil, fmt.Errorf("accessor %d is a %s, want a %s", i, a.Type, typ)
+	}
+	if len(a.Sparse) > 0 {
+		return nil, fmt.Errorf("accessor %d: sparse accessors aren't supported", i)
+	}
+	if a.Count < 0 {
+		return nil, fmt.Errorf("accessor %d has a negative count", i)
+	}
+
+	components := gltfComponents[typ]
+	values := make([]float64, a.Count*components)
+	if a.BufferView == nil {
+		// no data is zeros
+		return values, nil
+	}
+
+	// size in bytes and largest value of components
+	var (
+		size int
+		max  float64
+		read func(b []byte) float64
+	)
+	switch a.ComponentType {
+	case 5120:
+		size, max, read = 1, math.MaxInt8, func(b []byte) float64 { return float64(int8(b[0])) }
+	case 5121:
+		size, max, read = 1, math.MaxUint8, func(b []byte) float64 { return float64(b[0]) }
+	case 5122:
+		size, max, read = 2, math.MaxInt16, func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) }
+	case 5123:
+		size, max, read = 2, math.MaxUint16, func(b []byte) float64 { return float64(binary.LittleEndian.Uint16(b)) }
+	case 5125:
+		size, max, read = 4, math.MaxUint32, func(b []byte) float64 { return float64(binary.LittleEndian.Uint32(b)) }
+	case 5126:
+		size, read = 4, func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("accessor %d has unknown component type %d", i, a.ComponentType)
+	}
+	normalized := a.Normalized && max > 0
+
+	data, stride, err := l.bufferView(*a.BufferView)
+	if err != nil {
+		return nil, fmt.Errorf("accessor %d: %v", i, err)
+	}
+	if stride == 0 {
+		stride = size * components
+	}
+	if a.Count > 0 && (a.ByteOffset < 0 || a.ByteOffset+(a.Count-1)*stride+size*components > len(data)) {
+		return nil, fmt.Errorf("accessor %d overflows its buffer view", i)
+	}
+
+	for k := 0; k < a.Count; k++ {
+		element := data[a.ByteOffset+k*stride:]
+		for c := 0; c < components; c++ {
+			v := read(element[c*size:])
+			if normalized {
+				// the minimum of signed integers is -1 too
+				v = math.Max(-1, v/max)
+			}
+			values[k*components+c] = v
+		}
+	}
+	return values, nil
+}
+
+// bufferView returns the bytes of a buffer view and its stride, zero for
+// tightly packed elements.
+func (l *gltfLoader) bufferView(i int) ([]byte, int, error) {
+	if i < 0 || i >= len(l.doc.BufferViews) {
+		return nil, 0, fmt.Errorf("buffer view %d out of range", i)
+	}
+	view := l.doc.BufferViews[i]
+	if view.Buffer < 0 || view.Buffer >= len(l.buffers) {
+		return nil, 0, fmt.Errorf("buffer view %d: buffer %d out of range", i, view.Buffer)
+	}
+	buffer := l.buffers[view.Buffer]
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, 0, fmt.Errorf("buffer view %d overflows its buffer", i)
+	}
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], view.ByteStride, nil
+}
+
+// material maps a glTF material onto a Principled one. Texture values are
+// multiplied by their factors, so textures are scaled unless their factors
+// are 1, and left out when they're 0.
+func (l *gltfLoader) material(i int) (Material, error) {
+	if m, ok := l.materials[i]; ok {
+		return m, nil
+	}
+	if i < 0 || i >= len(l.doc.Materials) {
+		return nil, fmt.Errorf("material %d out of range", i)
+	}
+	m := l.doc.Materials[i]
+	pbr := m.PBRMetallicRoughness
+
+	p := Principled{
+		BaseColor: Color{1, 1, 1},
+		Metallic:  1,
+		Roughness: 1,
+		Specular:  0.5,
+		IOR:       1.5,
+	}
+	if pbr.BaseColorFactor != nil {
+		p.BaseColor = Color{pbr.BaseColorFactor[0], pbr.BaseColorFactor[1], pbr.BaseColorFactor[2]}
+	}
+	if pbr.MetallicFactor != nil {
+		p.Metallic = Clamp(*pbr.MetallicFactor, 0, 1)
+	}
+	if pbr.RoughnessFactor != nil {
+		p.Roughness = Clamp(*pbr.RoughnessFactor, 0, 1)
+	}
+	if ior := m.Extensions.IOR; ior != nil && ior.IOR != nil && *ior.IOR >= 1 {
+		p.IOR = *ior.IOR
+	}
+	p.Emission = m.EmissiveFactor
+	if strength := m.Extensions.EmissiveStrength; strength != nil && strength.EmissiveStrength != nil {
+		p.Emission = Color(Vec3(p.Emission).MulFloat(*strength.EmissiveStrength))
+	}
+	if clearcoat := m.Extensions.Clearcoat; clearcoat != nil {
+		p.Clearcoat = Clamp(clearcoat.ClearcoatFactor, 0, 1)
+		p.ClearcoatRoughness = Clamp(clearcoat.ClearcoatRoughnessFactor, 0, 1)
+	}
+
+	var err error
+	texture := func(info *gltfTextureInfo, linear bool, channel int, factor Color) Texture {
+		if info == nil || err != nil || Vec3(factor).Zero() {
+			return nil
+		}
+		var t Texture
+		if t, err = l.texture(info.Index, linear); err != nil {
+			return nil
+		}
+		if channel >= 0 {
+			t = ChannelTexture{Texture: t, Channel: channel}
+		}
+		if factor != (Color{1, 1, 1}) {
+			t = ScaledTexture{Texture: t, Scale: factor}
+		}
+		return t
+	}
+	gray := func(v float64) Color { return Color{v, v, v} }
+
+	p.BaseColorTexture = texture(pbr.BaseColorTexture, false, -1, p.BaseColor)
+	// roughness is in the green channel, metallic in the blue one
+	p.RoughnessTexture = texture(pbr.MetallicRoughnessTexture, true, 1, gray(p.Roughness))
+	p.MetallicTexture = texture(pbr.MetallicRoughnessTexture, true, 2, gray(p.Metallic))
+	p.EmissionTexture = texture(m.EmissiveTexture, false, -1, p.Emission)
+	if transmission := m.Extensions.Transmission; transmission != nil {
+		p.Transmission = Clamp(transmission.TransmissionFactor, 0, 1)
+		p.TransmissionTexture = texture(transmission.TransmissionTexture, true, 0, gray(p.Transmission))
+	}
+
+	var material Material = p
+	if normalMap := texture(m.NormalTexture, true, -1, Color{1, 1, 1}); normalMap != nil {
+		material = NormalMapped{Material: p, NormalMap: normalMap}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("material %d: %v", i, err)
+	}
+
+	l.materials[i] = material
+	return material, nil
+}
+
+// texture loads the image of a texture, sRGB unless linear.
+func (l *gltfLoader) texture(i int, linear bool) (*ImageTexture, error) {
+	if i < 0 || i >= len(l.doc.Textures) {
+		return nil, fmt.Errorf("texture %d out of range", i)
+	}
+	texture := l.doc.Textures[i]
+	if texture.Source == nil || *texture.Source < 0 || *texture.Source >= len(l.doc.Images) {
+		return nil, fmt.Errorf("texture %d has no image", i)
+	}
+
+	wrap := WrapRepeat
+	if texture.Sampler != nil {
+		if *texture.Sampler < 0 || *texture.Sampler >= len(l.doc.Samplers) {
+			return nil, fmt.Errorf("texture %d: sampler %d out of range", i, *texture.Sampler)
+		}
+		switch l.doc.Samplers[*texture.Sampler].WrapS {
+		case 33071:
+			wrap = WrapClamp
+		case 33648:
+			wrap = WrapMirror
+		}
+	}
+
+	key := gltfTextureKey{image: *texture.Source, wrap: wrap, linear: linear}
+	if t, ok := l.textures[key]; ok {
+		return t, nil
+	}
+
+	image := l.doc.Images[key.image]
+	if image.URI == "" {
+		return nil, fmt.Errorf("image %d: images in buffers aren't supported", key.image)
+	}
+	path, err := l.path(image.URI)
+	if err != nil {
+		return nil, fmt.Errorf("image %d: %v", key.image, err)
+	}
+	t, err := LoadImageTexture(path, wrap, linear)
+	if err != nil {
+		return nil, err
+	}
+	l.textures[key] = t
+	return t, nil
+}
+
+// gltfMatrix is a column major 4x4 matrix, as in glTF files.
+type gltfMatrix [16]float64
+
+var gltfIdentity = gltfMatrix{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+// transform is the matrix of a node, or the composition of its translation,
+// rotation and scale.
+func (n gltfNode) transform() gltfMatrix {
+	if n.Matrix != nil {
+		return gltfMatrix(*n.Matrix)
+	}
+
+	t, r, s := [3]float64{}, [4]float64{0, 0, 0, 1}, [3]float64{1, 1, 1}
+	if n.Translation != nil {
+		t = *n.Translation
+	}
+	if n.Rotation != nil {
+		r = *n.Rotation
+	}
+	if n.Scale != nil {
+		s = *n.Scale
+	}
+
+	// the columns of the rotation of the unit quaternion x, y, z, w
+	x, y, z, w := r[0], r[1], r[2], r[3]
+	rotation := [3]Vec3{
+		{1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w)},
+		{2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w)},
+		{2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y)},
+	}
+
+	var m gltfMatrix
+	for col := 0; col < 3; col++ {
+		for row := 0; row < 3; row++ {
+			m[col*4+row] = rotation[col][row] * s[col]
+		}
+	}
+	m[12], m[13], m[14], m[15] = t[0], t[1], t[2], 1
+	return m
+}
+
+func (m gltfMatrix) mul(o gltfMatrix) gltfMatrix {
+	var r gltfMatrix
+	for col := 0; col < 4; col++ {
+		for row := 0; row < 4; row++ {
+			for k := 0; k < 4; k++ {
+				r[col*4+row] += m[k*4+row] * o[col*4+k]
+			}
+		}
+	}
+	return r
+}
+
+func (m gltfMatrix) point(p Point3) Point3 {
+	var r Point3
+	for row := 0; row < 3; row++ {
+		r[row] = m[row]*p[0] + m[4+row]*p[1] + m[8+row]*p[2] + m[12+row]
+	}
+	return r
+}
+
+// columns are the columns of the upper 3x3 of m.
+func (m gltfMatrix) columns() (Vec3, Vec3, Vec3) {
+	return Vec3{m[0], m[1], m[2]}, Vec3{m[4], m[5], m[6]}, Vec3{m[8], m[9], m[10]}
+}
+
+func (m gltfMatrix) determinant() float64 {
+	a, b, c := m.columns()
+	return a.Dot(b.Cross(c))
+}
+
+// gltfMatrix3 is a 3x3 matrix, by columns.
+type gltfMatrix3 [3]Vec3
+
+func (m gltfMatrix3) vector(v Vec3) Vec3 {
+	return m[0].MulFloat(v[0]).Add(m[1].MulFloat(v[1])).Add(m[2].MulFloat(v[2]))
+}
+
+// normalMatrix transforms normals: it's the inverse transpose of the upper
+// 3x3 of m, up to a positive scale.
+func (m gltfMatrix) normalMatrix() gltfMatrix3 {
+	a, b, c := m.columns()
+	n := gltfMatrix3{b.Cross(c), c.Cross(a), a.Cross(b)}
+	if m.determinant() < 0 {
+		for i := range n {
+			n[i] = n[i].Neg()
+		}
+	}
+	return n
+}
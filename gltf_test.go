@@ -0,0 +1,272 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGLTFBuffer is the binary buffer of testGLTF: a quad's positions,
+// texture coordinates, normals and indices.
+func testGLTFBuffer() []byte {
+	var buf bytes.Buffer
+	for _, v := range []interface{}{
+		[]float32{-0.5, -0.5, 0, 0.5, -0.5, 0, 0.5, 0.5, 0, -0.5, 0.5, 0},
+		[]float32{0, 1, 1, 1, 1, 0, 0, 0},
+		[]float32{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1},
+		[]uint16{0, 1, 2, 0, 2, 3},
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// testGLTF is a glTF document with the quad of testGLTFBuffer: textured,
+// scaled, rotated half a turn and moved 5 away along -z, and mirrored along x
+// 5 away along z as a fan without normals nor material. buffer is the json of
+// its buffer.
+func testGLTF(buffer string) string {
+	return `{
+	"asset": {"version": "2.0"},
+	"scene": 0,
+	"scenes": [{"nodes": [0, 2]}],
+	"nodes": [
+		{"translation": [0, 0, -5], "children": [1]},
+		{"mesh": 0, "rotation": [0, 1, 0, 0], "scale": [2, 2, 2]},
+		{"mesh": 1, "matrix": [-1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 5, 1]}
+	],
+	"meshes": [
+		{"primitives": [{"attributes": {"POSITION": 0, "TEXCOORD_0": 1, "NORMAL": 2}, "indices": 3, "material": 0}]},
+		{"primitives": [{"attributes": {"POSITION": 0}, "mode": 6}, {"attributes": {"POSITION": 0}, "mode": 1}]}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"},
+		{"bufferView": 0, "byteOffset": 48, "componentType": 5126, "count": 4, "type": "VEC2"},
+		{"bufferView": 0, "byteOffset": 80, "componentType": 5126, "count": 4, "type": "VEC3"},
+		{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}
+	],
+	"bufferViews": [
+		{"buffer": 0, "byteLength": 128},
+		{"buffer": 0, "byteOffset": 128, "byteLength": 12}
+	],
+	"buffers": [` + buffer + `],
+	"materials": [{
+		"pbrMetallicRoughness": {
+			"baseColorFactor": [1, 0.5, 0.5, 1],
+			"baseColorTexture": {"index": 0},
+			"metallicFactor": 0.5,
+			"metallicRoughnessTexture": {"index": 1}
+		},
+		"normalTexture": {"index": 2},
+		"emissiveTexture": {"index": 0},
+		"emissiveFactor": [1, 1, 0],
+		"extensions": {
+			"KHR_materials_emissive_strength": {"emissiveStrength": 2},
+			"KHR_materials_transmission": {"transmissionFactor": 0.25},
+			"KHR_materials_ior": {"ior": 1.45}
+		}
+	}],
+	"textures": [{"source": 0, "sampler": 0}, {"source": 1}, {"source": 2}],
+	"images": [{"uri": "white.png"}, {"uri": "metallic%20roughness.png"}, {"uri": "white.png"}],
+	"samplers": [{"wrapS": 33071, "wrapT": 33071}]
+}`
+}
+
+// writeTestGLTFImages writes the images of testGLTF into dir.
+func writeTestGLTFImages(t *testing.T, dir string) {
+	t.Helper()
+	writeTestPNG(t, filepath.Join(dir, "white.png"), 1, 1, func(x, y int) [3]uint8 { return [3]uint8{255, 255, 255} })
+	writeTestPNG(t, filepath.Join(dir, "metallic roughness.png"), 1, 1, func(x, y int) [3]uint8 { return [3]uint8{0, 51, 255} })
+}
+
+// encodeTestGLB packs a JSON document and a binary chunk into a .glb file.
+func encodeTestGLB(document string, bin []byte) []byte {
+	pad := func(b []byte, with byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, with)
+		}
+		return b
+	}
+	doc, bin := pad([]byte(document), ' '), pad(bin, 0)
+
+	var buf bytes.Buffer
+	for _, v := range []uint32{glbMagic, 2, uint32(12 + 8 + len(doc) + 8 + len(bin)), uint32(len(doc)), glbChunkJSON} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.Write(doc)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(bin)))
+	binary.Write(&buf, binary.LittleEndian, uint32(glbChunkBIN))
+	buf.Write(bin)
+	return buf.Bytes()
+}
+
+func TestLoadGLTF(t *testing.T) {
+	bin := testGLTFBuffer()
+	dir := writeFiles(t, map[string]string{
+		"embedded.gltf": testGLTF(fmt.Sprintf(`{"byteLength": %d, "uri": "data:application/octet-stream;base64,%s"}`, len(bin), base64.StdEncoding.EncodeToString(bin))),
+		"external.gltf": testGLTF(fmt.Sprintf(`{"byteLength": %d, "uri": "quad.bin"}`, len(bin))),
+		"quad.bin":      string(bin),
+		"binary.glb":    string(encodeTestGLB(testGLTF(fmt.Sprintf(`{"byteLength": %d}`, len(bin))), bin)),
+	})
+	writeTestGLTFImages(t, dir)
+
+	for _, name := range []string{"embedded.gltf", "external.gltf", "binary.glb"} {
+		l, err := LoadGLTF(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(l) != 2 {
+			t.Errorf("%s: got %d meshes, want 2, lines skipped", name, len(l))
+			continue
+		}
+
+		quad := l[0].(*TriangleMesh)
+		if !approxVec3(Vec3(quad.Positions[0]), Vec3{1, -1, -5}, testEpsilon) || !approxVec3(Vec3(quad.Positions[2]), Vec3{-1, 1, -5}, testEpsilon) {
+			t.Errorf("%s: quad positions %v, want scaled, rotated and moved", name, quad.Positions)
+		}
+		if !approxVec3(quad.Normals[0], Vec3{0, 0, -1}, testEpsilon) {
+			t.Errorf("%s: quad normal %v, want rotated", name, quad.Normals[0])
+		}
+		if quad.UVs[0] != [2]float64{0, 0} || quad.UVs[2] != [2]float64{1, 1} {
+			t.Errorf("%s: quad uvs %v, want v flipped", name, quad.UVs)
+		}
+
+		mirrored := l[1].(*TriangleMesh)
+		if !approxVec3(Vec3(mirrored.Positions[0]), Vec3{0.5, -0.5, 5}, testEpsilon) || mirrored.Normals != nil {
+			t.Errorf("%s: mirrored positions %v and normals %v", name, mirrored.Positions, mirrored.Normals)
+		}
+		if want := []int{0, 2, 1, 0, 3, 2}; fmt.Sprint(mirrored.Indices) != fmt.Sprint(want) {
+			t.Errorf("%s: mirrored fan indices %v, want %v, the winding kept", name, mirrored.Indices, want)
+		}
+		if mirrored.Material != DefaultGLTFMaterial {
+			t.Errorf("%s: mirrored material %#v, want the default", name, mirrored.Material)
+		}
+
+		hr := l.Hit(Ray{Origin: Point3{0.5, 0.5, 0}, Direction: Vec3{0, 0, -1}})
+		if !hr.Hit || hr.T != 5 || hr.Material != quad.Material {
+			t.Errorf("%s: hit %v at %v, want the quad at 5", name, hr.Hit, hr.T)
+		}
+	}
+}
+
+func TestLoadGLTFMaterial(t *testing.T) {
+	bin := testGLTFBuffer()
+	dir := writeFiles(t, map[string]string{
+		"scene.gltf": testGLTF(fmt.Sprintf(`{"byteLength": %d, "uri": "data:application/octet-stream;base64,%s"}`, len(bin), base64.StdEncoding.EncodeToString(bin))),
+	})
+	writeTestGLTFImages(t, dir)
+
+	l, err := LoadGLTF(filepath.Join(dir, "scene.gltf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normalMapped, ok := l[0].(*TriangleMesh).Material.(NormalMapped)
+	if !ok {
+		t.Fatalf("material %#v, want NormalMapped", l[0].(*TriangleMesh).Material)
+	}
+	if normalMap, ok := normalMapped.NormalMap.(*ImageTexture); !ok || !normalMap.Linear || normalMap.Wrap != WrapRepeat {
+		t.Errorf("normal map %#v, want linear and repeated", normalMapped.NormalMap)
+	}
+
+	p := normalMapped.Material.(Principled)
+	if p.BaseColor != (Color{1, 0.5, 0.5}) || p.Metallic != 0.5 || p.Roughness != 1 || p.Transmission != 0.25 || p.IOR != 1.45 || p.Emission != (Color{2, 2, 0}) {
+		t.Errorf("material %#v", p)
+	}
+
+	base, ok := p.BaseColorTexture.(ScaledTexture)
+	if image, isImage := base.Texture.(*ImageTexture); !ok || !isImage || image.Linear || image.Wrap != WrapClamp || base.Scale != p.BaseColor {
+		t.Errorf("base color texture %#v, want an sRGB clamped image scaled by the base color", p.BaseColorTexture)
+	}
+
+	var hr HitRecord
+	for _, test := range []struct {
+		name    string
+		texture Texture
+		want    float64
+	}{
+		{"roughness", p.RoughnessTexture, 0.2},
+		{"metallic", p.MetallicTexture, 0.5},
+		{"emission", p.EmissionTexture, 2 * (0.2126 + 0.7152)},
+	} {
+		if got := textureScalar(test.texture, -1, hr); !approxEqual(got, test.want, 1e-12) {
+			t.Errorf("%s texture is %v, want %v", test.name, got, test.want)
+		}
+	}
+	if p.TransmissionTexture != nil || p.SpecularTexture != nil {
+		t.Errorf("material %#v has textures it shouldn't", p)
+	}
+}
+
+func TestLoadGLTFErrors(t *testing.T) {
+	bin := testGLTFBuffer()
+	buffer := fmt.Sprintf(`{"byteLength": %d, "uri": "quad.bin"}`, len(bin))
+	valid := testGLTF(buffer)
+
+	tests := []struct {
+		name, gltf, err string
+	}{
+		{"version", strings.Replace(valid, `"2.0"`, `"1.0"`, 1), `unsupported glTF version "1.0"`},
+		{"json", "{", "unexpected end of JSON input"},
+		{"missing buffer", testGLTF(`{"byteLength": 4, "uri": "missing.bin"}`), "buffer 0"},
+		{"short buffer", testGLTF(`{"byteLength": 400, "uri": "quad.bin"}`), "buffer 0 has 140 bytes, want 400"},
+		{"accessor overflow", strings.Replace(valid, `"count": 6`, `"count": 7`, 1), "accessor 3 overflows its buffer view"},
+		{"accessor type", strings.Replace(valid, `"type": "VEC2"`, `"type": "VEC3"`, 1), "accessor 1 is a VEC3, want a VEC2"},
+		{"component type", strings.Replace(valid, `"componentType": 5123`, `"componentType": 5130`, 1), "unknown component type 5130"},
+		{"sparse", strings.Replace(valid, `"count": 6,`, `"count": 6, "sparse": {"count": 1},`, 1), "sparse accessors aren't supported"},
+		{"index out of range", strings.Replace(valid, `"count": 6`, `"count": 5`, 1), "not a multiple of 3"},
+		{"cycle", strings.Replace(valid, `"children": [1]`, `"children": [1, 0]`, 1), "node 0 is reached twice"},
+		{"missing node", strings.Replace(valid, `"nodes": [0, 2]`, `"nodes": [0, 3]`, 1), "node 3 out of range"},
+		{"embedded image", strings.Replace(valid, `"uri": "white.png"`, `"uri": "data:image/png;base64,AAAA"`, 1), "embedded files aren't supported"},
+		{"missing image", strings.Replace(valid, `"uri": "white.png"`, `"uri": "black.png"`, 1), "black.png"},
+	}
+
+	for _, test := range tests {
+		dir := writeFiles(t, map[string]string{"scene.gltf": test.gltf, "quad.bin": string(bin)})
+		writeTestGLTFImages(t, dir)
+		_, err := LoadGLTF(filepath.Join(dir, "scene.gltf"))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	dir := writeFiles(t, map[string]string{"truncated.glb": string(encodeTestGLB(valid, bin)[:100])})
+	if _, err := LoadGLTF(filepath.Join(dir, "truncated.glb")); err == nil || !strings.Contains(err.Error(), "truncated binary glTF") {
+		t.Errorf("truncated glb: error %v", err)
+	}
+}
+
+func TestJSONScaledChannelTextures(t *testing.T) {
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+	scene := func(texture string) string {
+		return `{"version": 1, ` + camera + `, "materials": {"m": {"type": "principled", "base_color": [1, 1, 1], "roughness_texture": ` + texture + `}}, "shapes": [` +
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "m"}]}`
+	}
+
+	decoded, err := DecodeJSONScene(strings.NewReader(scene(`{"type": "scaled", "texture": {"type": "channel", "texture": [0.1, 0.2, 0.3], "channel": 1}, "scale": [2, 2, 2]}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	texture := decoded.HitterList[0].(*Sphere).Material.(Principled).RoughnessTexture
+	want := ScaledTexture{Texture: ChannelTexture{Texture: ConstantTexture{Color: Color{0.1, 0.2, 0.3}}, Channel: 1}, Scale: Color{2, 2, 2}}
+	if texture != want {
+		t.Errorf("decoded %#v, want %#v", texture, want)
+	}
+	if c := texture.Value(0, 0, Point3{}); c != (Color{0.4, 0.4, 0.4}) {
+		t.Errorf("value %v, want the green channel doubled", c)
+	}
+
+	for _, bad := range []string{
+		`{"type": "channel", "texture": [1, 1, 1], "channel": 3}`,
+		`{"type": "scaled", "scale": [1, 1, 1]}`,
+	} {
+		if _, err := DecodeJSONScene(strings.NewReader(scene(bad))); err == nil {
+			t.Errorf("decoded texture %s", bad)
+		}
+	}
+}
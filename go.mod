@@ -5,5 +5,4 @@ go 1.15
 require (
 	github.com/gotk3/gotk3 v0.5.2
 	golang.org/x/tools/gopls v0.6.5 // indirect
-	lukechampine.com/frand v1.3.0
 )
@@ -5,8 +5,6 @@ import (
 	"math"
 	"sort"
 	"sync/atomic"
-
-	"lukechampine.com/frand"
 )
 
 type Hitter interface {
@@ -19,31 +17,76 @@ type HitRecord struct {
 	FrontFace bool
 	T         float64
 	P         Point3
-	Normal    Vec3
-	Material  Material
-	BVHNode   *BVHNode
+	// Normal is the shading normal (e.g. interpolated from vertex normals),
+	// GeometricNormal the normal of the surface itself. Both face the ray.
+	Normal          Vec3
+	GeometricNormal Vec3
+	// Tangent and Bitangent complete Normal into an orthonormal shading frame.
+	// Tangent points towards increasing U, Bitangent towards increasing V.
+	Tangent, Bitangent Vec3
+	U, V               float64
+	Material           Material
+	BVHNode            *BVHNode
+	// BVH nodes visited to find the hit, whether there was one or not
+	NodeVisits int
+}
+
+// setTangents sets hr's shading frame from the derivatives of P along U and
+// V. Where dpdu is degenerate along Normal (e.g. at the poles of a sphere),
+// any tangent is used.
+func (hr *HitRecord) setTangents(dpdu, dpdv Vec3) {
+	hr.Tangent, hr.Bitangent = shadingFrame(hr.Normal, dpdu, dpdv)
+}
+
+// shadingFrame makes tangent and bitangent orthonormal with normal, keeping
+// their orientation.
+func shadingFrame(normal, tangent, bitangent Vec3) (Vec3, Vec3) {
+	t := tangent.Sub(normal.MulFloat(tangent.Dot(normal)))
+	if t.NearZero() {
+		t = planeAxis(normal)[0]
+	}
+	t = t.Unit()
+
+	b := normal.Cross(t)
+	if b.Dot(bitangent) < 0 {
+		b = b.Neg()
+	}
+	return t, b
 }
 
+// Sphere is mapped spherically: U goes around the vertical axis, from -X
+// through +Z, and V from the bottom pole to the top one.
 type Sphere struct {
-	Center   Point3
-	Radius   float64
-	Material Material
-	Box      AABB
+	Center   Point3   `json:"center"`
+	Radius   float64  `json:"radius"`
+	Material Material `json:"material"`
+	Box      AABB     `json:"-"`
 }
 
 func NewSphere(center Point3, radius float64, material Material) *Sphere {
-	box := AABB{
-		Point3(Vec3(center).Sub(Vec3{radius, radius, radius})),
-		Point3(Vec3(center).Add(Vec3{radius, radius, radius})),
-	}
 	return &Sphere{
 		Center:   center,
 		Radius:   radius,
 		Material: material,
-		Box:      box,
+		Box:      sphereBox(center, radius),
+	}
+}
+
+func sphereBox(center Point3, radius float64) AABB {
+	return AABB{
+		Point3(Vec3(center).Sub(Vec3{radius, radius, radius})),
+		Point3(Vec3(center).Add(Vec3{radius, radius, radius})),
 	}
 }
 
+func (s *Sphere) finishDecode() error {
+	if s.Radius <= 0 {
+		return errors.New("radius must be positive")
+	}
+	s.Box = sphereBox(s.Center, s.Radius)
+	return nil
+}
+
 func (s Sphere) Hit(r Ray) HitRecord {
 	oc := Vec3(r.Origin).Sub(Vec3(s.Center))
 	a := r.Direction.Dot(r.Direction)
@@ -80,6 +123,12 @@ func (s Sphere) Hit(r Ray) HitRecord {
 	if !hr.FrontFace {
 		hr.Normal = hr.Normal.Neg()
 	}
+	hr.GeometricNormal = hr.Normal
+
+	x, y, z := outwardNormal[0], outwardNormal[1], outwardNormal[2]
+	hr.U = (math.Atan2(-z, x) + math.Pi) / (2 * math.Pi)
+	hr.V = math.Acos(Clamp(-y, -1, 1)) / math.Pi
+	hr.setTangents(Vec3{z, 0, -x}, Vec3{-x * y, 1 - y*y, -z * y})
 
 	return hr
 }
@@ -92,12 +141,15 @@ type HitterList []Hitter
 
 func (h HitterList) Hit(r Ray) (hr HitRecord) {
 	hr.T = math.Inf(+1)
+	visits := 0
 	for i := range h {
 		hhr := h[i].Hit(r)
+		visits += hhr.NodeVisits
 		if hhr.Hit && hhr.T < hr.T {
 			hr = hhr
 		}
 	}
+	hr.NodeVisits = visits
 	return
 }
 
@@ -113,9 +165,10 @@ func (h HitterList) BoundingBox() AABB {
 		if bb.Zero() {
 			return AABB{}
 		}
-		outputBox = bb
-		if !firstBox {
-			outputBox.Surrounding(bb)
+		if firstBox {
+			outputBox = bb
+		} else {
+			outputBox = outputBox.Surrounding(bb)
 		}
 		firstBox = false
 	}
@@ -127,6 +180,9 @@ type BVHNode struct {
 	ID          uint64
 	Box         AABB
 	Left, Right Hitter
+	// Unbounded holds the hitters without a bounding box (e.g. planes). They
+	// can't be partitioned, so they are tested on every ray reaching the node.
+	Unbounded HitterList
 }
 
 // 0 is reserved
@@ -137,7 +193,33 @@ func NewBVHNode(l HitterList) (*BVHNode, error) {
 		return nil, errors.New("empty list")
 	}
 
-	axis := frand.Intn(3)
+	var bounded, unbounded HitterList
+	for i := range l {
+		if l[i].BoundingBox().Zero() {
+			unbounded = append(unbounded, l[i])
+			continue
+		}
+		bounded = append(bounded, l[i])
+	}
+
+	if len(bounded) == 0 {
+		return &BVHNode{
+			ID:        atomic.AddUint64(&bvhCounter, 1),
+			Unbounded: unbounded,
+		}, nil
+	}
+
+	node, err := newBVHNode(bounded)
+	if err != nil {
+		return nil, err
+	}
+	node.Unbounded = unbounded
+
+	return node, nil
+}
+
+func newBVHNode(l HitterList) (*BVHNode, error) {
+	axis := l.BoundingBox().LongestAxis()
 	node := &BVHNode{ID: atomic.AddUint64(&bvhCounter, 1)}
 
 	switch len(l) {
@@ -146,20 +228,20 @@ func NewBVHNode(l HitterList) (*BVHNode, error) {
 	case 2:
 		var err error
 		if l[0].BoundingBox().Compare(l[1].BoundingBox(), axis) {
-			node.Left, err = NewBVHNode(l[:1])
+			node.Left, err = newBVHNode(l[:1])
 			if err != nil {
 				return node, err
 			}
-			node.Right, err = NewBVHNode(l[1:])
+			node.Right, err = newBVHNode(l[1:])
 			if err != nil {
 				return node, err
 			}
 		} else {
-			node.Left, err = NewBVHNode(l[1:])
+			node.Left, err = newBVHNode(l[1:])
 			if err != nil {
 				return node, err
 			}
-			node.Right, err = NewBVHNode(l[:1])
+			node.Right, err = newBVHNode(l[:1])
 			if err != nil {
 				return node, err
 			}
@@ -171,36 +253,55 @@ func NewBVHNode(l HitterList) (*BVHNode, error) {
 
 		var err error
 		mid := len(l) / 2
-		node.Left, err = NewBVHNode(l[:mid])
+		node.Left, err = newBVHNode(l[:mid])
 		if err != nil {
 			return node, err
 		}
-		node.Right, err = NewBVHNode(l[mid:])
+		node.Right, err = newBVHNode(l[mid:])
 		if err != nil {
 			return node, err
 		}
 	}
 
-	if node.Left.BoundingBox().Zero() || node.Right.BoundingBox().Zero() {
-		panic(errors.New("hahaha"))
-	}
-
 	node.Box = node.Left.BoundingBox().Surrounding(node.Right.BoundingBox())
 
 	return node, nil
 }
 
+// BoundingBox is zero (i.e. unbounded) when the node holds unbounded hitters.
 func (n *BVHNode) BoundingBox() AABB {
+	if len(n.Unbounded) > 0 {
+		return AABB{}
+	}
 	return n.Box
 }
 
 func (n *BVHNode) Hit(ray Ray) HitRecord {
-	if !n.Box.Hit(ray) {
-		return HitRecord{}
+	hr := n.hitBounded(ray)
+
+	visits := hr.NodeVisits
+	for i := range n.Unbounded {
+		uhr := n.Unbounded[i].Hit(ray)
+		visits += uhr.NodeVisits
+		if uhr.Hit && (!hr.Hit || uhr.T < hr.T) {
+			hr = uhr
+			hr.BVHNode = n
+		}
+	}
+	hr.NodeVisits = visits
+
+	return hr
+}
+
+func (n *BVHNode) hitBounded(ray Ray) HitRecord {
+	if n.Left == nil || !n.Box.Hit(ray) {
+		return HitRecord{NodeVisits: 1}
 	}
 
 	hrLeft := n.Left.Hit(ray)
 	hrRight := n.Right.Hit(ray)
+	visits := 1 + hrLeft.NodeVisits + hrRight.NodeVisits
+	hrLeft.NodeVisits, hrRight.NodeVisits = visits, visits
 
 	if _, ok := n.Left.(*BVHNode); !ok {
 		hrLeft.BVHNode = n
@@ -221,17 +322,29 @@ func (n *BVHNode) Hit(ray Ray) HitRecord {
 	return hrRight
 }
 
+// Plane is an infinite plane. It has no bounding box, so inside a BVH it ends
+// up in BVHNode.Unbounded.
 type Plane struct {
-	Origin Point3
+	Origin Point3 `json:"origin"`
 	// Unit
-	Normal Vec3
-	// Width, Height float64
-	Axis [2]Vec3
+	Normal Vec3 `json:"normal"`
+	// Unit tangents spanning the plane, used for its UV coordinates
+	Axis     [2]Vec3  `json:"-"`
+	Material Material `json:"material"`
 }
 
-func NewPlane(origin Point3, normal Vec3) Plane {
+func NewPlane(origin Point3, normal Vec3, material Material) *Plane {
 	normal = normal.Unit()
 
+	return &Plane{
+		Origin:   origin,
+		Normal:   normal,
+		Axis:     planeAxis(normal),
+		Material: material,
+	}
+}
+
+func planeAxis(normal Vec3) [2]Vec3 {
 	var tangent0 Vec3
 	switch {
 	case normal[0] != 0:
@@ -244,10 +357,16 @@ func NewPlane(origin Point3, normal Vec3) Plane {
 	tangent0 = tangent0.Unit()
 	tangent1 := normal.Cross(tangent0).Unit()
 
-	return Plane{
-		Origin: origin,
-		Axis:   [2]Vec3{tangent0, tangent1},
+	return [2]Vec3{tangent0, tangent1}
+}
+
+func (p *Plane) finishDecode() error {
+	if p.Normal.Zero() {
+		return errors.New("normal can't be zero")
 	}
+	p.Normal = p.Normal.Unit()
+	p.Axis = planeAxis(p.Normal)
+	return nil
 }
 
 func (p Plane) BoundingBox() AABB {
@@ -255,12 +374,40 @@ func (p Plane) BoundingBox() AABB {
 }
 
 func (p Plane) Hit(ray Ray) HitRecord {
-	return HitRecord{}
+	denom := ray.Direction.Dot(p.Normal)
+	if math.Abs(denom) < 1e-8 {
+		return HitRecord{}
+	}
+
+	t := Vec3(p.Origin).Sub(Vec3(ray.Origin)).Dot(p.Normal) / denom
+	if t < 0.0001 {
+		return HitRecord{}
+	}
+
+	hr := HitRecord{
+		Hit:      true,
+		T:        t,
+		P:        ray.At(t),
+		Material: p.Material,
+	}
+
+	local := Vec3(hr.P).Sub(Vec3(p.Origin))
+	hr.U, hr.V = local.Dot(p.Axis[0]), local.Dot(p.Axis[1])
+
+	hr.FrontFace = denom < 0
+	hr.Normal = p.Normal
+	if !hr.FrontFace {
+		hr.Normal = hr.Normal.Neg()
+	}
+	hr.GeometricNormal = hr.Normal
+	hr.setTangents(p.Axis[0], p.Axis[1])
+
+	return hr
 }
 
+// Project returns the orthogonal projection of point onto the plane.
 func (p Plane) Project(point Point3) Point3 {
-	return Point3{}
-	// v := point.Vec3().Sub(p.Origin.Vec3())
-	// dist := v.Dot(p.Normal)
-	// return Point3(v.Add(p.Normal.MulFloat(dist)))
+	v := Vec3(point).Sub(Vec3(p.Origin))
+	dist := v.Dot(p.Normal)
+	return Point3(Vec3(point).Sub(p.Normal.MulFloat(dist)))
 }
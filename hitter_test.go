@@ -0,0 +1,204 @@
+package tracer
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+const testEpsilon = 1e-9
+
+func approxEqual(a, b, epsilon float64) bool {
+	return math.Abs(a-b) <= epsilon
+}
+
+func approxVec3(a, b Vec3, epsilon float64) bool {
+	return a.Sub(b).Len() <= epsilon
+}
+
+func TestPlaneHit(t *testing.T) {
+	p := NewPlane(Point3{0, 1, 0}, Vec3{0, 2, 0}, nil)
+
+	tests := []struct {
+		name      string
+		ray       Ray
+		hit       bool
+		t         float64
+		frontFace bool
+	}{
+		{"front", Ray{Origin: Point3{1, 3, 2}, Direction: Vec3{0, -1, 0}}, true, 2, true},
+		{"back", Ray{Origin: Point3{1, -1, 2}, Direction: Vec3{0, 2, 0}}, true, 1, false},
+		{"oblique", Ray{Origin: Point3{0, 2, 0}, Direction: Vec3{1, -1, 0}}, true, 1, true},
+		{"behind", Ray{Origin: Point3{1, 3, 2}, Direction: Vec3{0, 1, 0}}, false, 0, false},
+		{"parallel", Ray{Origin: Point3{1, 3, 2}, Direction: Vec3{1, 0, 1}}, false, 0, false},
+		{"in plane", Ray{Origin: Point3{1, 1, 2}, Direction: Vec3{1, 0, 0}}, false, 0, false},
+		{"below t min", Ray{Origin: Point3{1, 1.00001, 2}, Direction: Vec3{0, -1, 0}}, false, 0, false},
+	}
+
+	for _, test := range tests {
+		hr := p.Hit(test.ray)
+		if hr.Hit != test.hit {
+			t.Errorf("%s: hit %v, want %v", test.name, hr.Hit, test.hit)
+			continue
+		}
+		if !hr.Hit {
+			continue
+		}
+		if !approxEqual(hr.T, test.t, testEpsilon) {
+			t.Errorf("%s: t %v, want %v", test.name, hr.T, test.t)
+		}
+		if !approxEqual(hr.P[1], 1, testEpsilon) {
+			t.Errorf("%s: hit point %v off the plane", test.name, hr.P)
+		}
+		if hr.FrontFace != test.frontFace {
+			t.Errorf("%s: front face %v, want %v", test.name, hr.FrontFace, test.frontFace)
+		}
+		if hr.Normal.Dot(test.ray.Direction) >= 0 {
+			t.Errorf("%s: normal %v doesn't face the ray", test.name, hr.Normal)
+		}
+	}
+}
+
+func TestPlaneProject(t *testing.T) {
+	p := NewPlane(Point3{0, 1, 0}, Vec3{0, 1, 0}, nil)
+
+	if got := p.Project(Point3{2, 5, -3}); !approxVec3(Vec3(got), Vec3{2, 1, -3}, testEpsilon) {
+		t.Errorf("projection %v, want (2, 1, -3)", got)
+	}
+}
+
+// checkShadingFrame checks hr's Normal, Tangent and Bitangent are an
+// orthonormal frame. It's left-handed when V is flipped, e.g. on back faces.
+func checkShadingFrame(t *testing.T, name string, hr HitRecord) {
+	t.Helper()
+	n, tangent, bitangent := hr.Normal, hr.Tangent, hr.Bitangent
+	if !approxEqual(n.Len(), 1, 1e-9) || !approxEqual(tangent.Len(), 1, 1e-9) || !approxEqual(bitangent.Len(), 1, 1e-9) {
+		t.Errorf("%s: frame %v %v %v isn't unit", name, tangent, bitangent, n)
+	}
+	if !approxEqual(n.Dot(tangent), 0, 1e-9) || !approxEqual(math.Abs(n.Cross(tangent).Dot(bitangent)), 1, 1e-9) {
+		t.Errorf("%s: frame %v %v %v isn't orthonormal", name, tangent, bitangent, n)
+	}
+}
+
+func TestSphereUV(t *testing.T) {
+	s := NewSphere(Point3{1, 2, 3}, 2, nil)
+
+	tests := []struct {
+		name    string
+		normal  Vec3
+		u, v    float64
+		tangent Vec3
+	}{
+		{"+x", Vec3{1, 0, 0}, 0.5, 0.5, Vec3{0, 0, -1}},
+		{"-x", Vec3{-1, 0, 0}, 0, 0.5, Vec3{0, 0, 1}},
+		{"+z", Vec3{0, 0, 1}, 0.25, 0.5, Vec3{1, 0, 0}},
+		{"-z", Vec3{0, 0, -1}, 0.75, 0.5, Vec3{-1, 0, 0}},
+		{"top", Vec3{0, 1, 0}, 0, 1, Vec3{}},
+		{"bottom", Vec3{0, -1, 0}, 0, 0, Vec3{}},
+	}
+
+	for _, test := range tests {
+		// from outside, towards the center
+		hr := s.Hit(Ray{Origin: Point3(Vec3(s.Center).Add(test.normal.MulFloat(4))), Direction: test.normal.Neg()})
+		if !hr.Hit || !approxVec3(hr.GeometricNormal, test.normal, testEpsilon) {
+			t.Errorf("%s: hit %v, geometric normal %v", test.name, hr.Hit, hr.GeometricNormal)
+			continue
+		}
+		// U wraps around at the poles
+		if !approxEqual(hr.V, test.v, 1e-9) || test.tangent != (Vec3{}) && !approxEqual(hr.U, test.u, 1e-9) {
+			t.Errorf("%s: uv (%v, %v), want (%v, %v)", test.name, hr.U, hr.V, test.u, test.v)
+		}
+		checkShadingFrame(t, test.name, hr)
+		if test.tangent != (Vec3{}) && !approxVec3(hr.Tangent, test.tangent, 1e-9) {
+			t.Errorf("%s: tangent %v, want %v", test.name, hr.Tangent, test.tangent)
+		}
+		if test.tangent != (Vec3{}) && hr.Bitangent[1] <= 0 {
+			t.Errorf("%s: bitangent %v, want towards the top pole", test.name, hr.Bitangent)
+		}
+	}
+}
+
+func TestPlaneShadingFrame(t *testing.T) {
+	p := NewPlane(Point3{0, 1, 0}, Vec3{0, 1, 0}, nil)
+
+	for _, dir := range []Vec3{{0, -1, 0}, {0, 1, 0}} {
+		hr := p.Hit(Ray{Origin: Point3(Vec3{0, 1, 0}.Sub(dir)), Direction: dir})
+		if !approxVec3(hr.GeometricNormal, dir.Neg(), testEpsilon) {
+			t.Errorf("towards %v: geometric normal %v", dir, hr.GeometricNormal)
+		}
+		checkShadingFrame(t, "plane", hr)
+	}
+}
+
+func TestHitterListBoundingBox(t *testing.T) {
+	l := HitterList{
+		NewSphere(Point3{0, 0, 0}, 1, nil),
+		NewSphere(Point3{3, 0, 0}, 1, nil),
+		NewSphere(Point3{0, -2, 1}, 0.5, nil),
+	}
+
+	want := AABB{Point3{-1, -2.5, -1}, Point3{4, 1, 1.5}}
+	if got := l.BoundingBox(); got != want {
+		t.Errorf("box %v, want %v", got, want)
+	}
+
+	l = append(l, NewPlane(Point3{}, Vec3{0, 1, 0}, nil))
+	if got := l.BoundingBox(); !got.Zero() {
+		t.Errorf("box %v, want zero with a plane in the list", got)
+	}
+}
+
+func TestBVHNodeUnbounded(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	var l HitterList
+	for i := 0; i < 20; i++ {
+		center := Point3{random.Float64()*8 - 4, random.Float64()*8 - 4, random.Float64()*8 - 4}
+		l = append(l, NewSphere(center, 0.2+random.Float64(), nil))
+	}
+	planes, err := NewBVHNode(HitterList{NewPlane(Point3{0, 0, -6}, Vec3{0, 0, 1}, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = append(l, NewPlane(Point3{0, -5, 0}, Vec3{0, 1, 0}, nil), planes)
+
+	bvh, err := NewBVHNode(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bvh.Unbounded) != 2 || !bvh.BoundingBox().Zero() {
+		t.Fatalf("%d unbounded hitters and box %v, want 2 and a zero box", len(bvh.Unbounded), bvh.BoundingBox())
+	}
+	if _, err := NewBVHNode(nil); err == nil {
+		t.Error("no error for an empty list")
+	}
+
+	var planeHits int
+	for i := 0; i < 2000; i++ {
+		ray := Ray{
+			Origin:    Point3{random.Float64()*20 - 10, random.Float64()*20 - 10, 10},
+			Direction: Vec3{random.Float64()*2 - 1, random.Float64()*2 - 1, -1},
+		}
+
+		want := l.Hit(ray)
+		got := bvh.Hit(ray)
+		if got.Hit != want.Hit || got.Hit && !approxEqual(got.T, want.T, testEpsilon) {
+			t.Fatalf("ray %v: hit %v at %v, want %v at %v", ray, got.Hit, got.T, want.Hit, want.T)
+		}
+		if got.Hit && got.P[2] < -5.9 || got.Hit && got.P[1] < -4.9 {
+			planeHits++
+		}
+	}
+	if planeHits == 0 {
+		t.Error("no ray hit the unbounded planes")
+	}
+}
+
+func TestAABBCompareZero(t *testing.T) {
+	// unbounded hitters never reach the partitioning, but Compare must still
+	// be safe to call on zero boxes
+	box := AABB{Point3{1, 1, 1}, Point3{2, 2, 2}}
+	if !(AABB{}).Compare(box, 0) || box.Compare(AABB{}, 1) || (AABB{}).Compare(AABB{}, 2) {
+		t.Error("zero boxes compare by their minimum like any other box")
+	}
+}
@@ -0,0 +1,304 @@
+package tracer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// colorImage is an image of linear colors, row after row from the top.
+type colorImage struct {
+	width, height int
+	pixels        []Color
+}
+
+func newColorImage(width, height int, pixels []Color) (colorImage, error) {
+	if width <= 0 || height <= 0 || len(pixels) != width*height {
+		return colorImage{}, fmt.Errorf("invalid %dx%d image with %d pixels", width, height, len(pixels))
+	}
+	return colorImage{width: width, height: height, pixels: pixels}, nil
+}
+
+// loadColorImage reads a Radiance .hdr file, or a PNG or JPEG image whose
+// colors are taken as sRGB, unless linear.
+func loadColorImage(path string, linear bool) (colorImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return colorImage{}, err
+	}
+	defer f.Close()
+
+	var (
+		width, height int
+		pixels        []Color
+	)
+	if strings.EqualFold(filepath.Ext(path), ".hdr") {
+		width, height, pixels, err = decodeRadianceHDR(bufio.NewReader(f))
+	} else {
+		width, height, pixels, err = decodeLDRImage(f, linear)
+	}
+	if err != nil {
+		return colorImage{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return newColorImage(width, height, pixels)
+}
+
+// WrapMode is how images are sampled outside of their [0, 1] coordinates.
+type WrapMode int
+
+const (
+	WrapRepeat WrapMode = iota
+	WrapClamp
+	WrapMirror
+)
+
+func (mode WrapMode) String() string {
+	switch mode {
+	case WrapRepeat:
+		return "repeat"
+	case WrapClamp:
+		return "clamp"
+	case WrapMirror:
+		return "mirror"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseWrapMode(s string) (WrapMode, error) {
+	for mode := WrapRepeat; mode <= WrapMirror; mode++ {
+		if mode.String() == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown wrap mode %q", s)
+}
+
+func (mode WrapMode) MarshalText() ([]byte, error) {
+	if mode < WrapRepeat || mode > WrapMirror {
+		return nil, fmt.Errorf("unknown wrap mode %d", int(mode))
+	}
+	return []byte(mode.String()), nil
+}
+
+func (mode *WrapMode) UnmarshalText(text []byte) error {
+	m, err := ParseWrapMode(string(text))
+	if err != nil {
+		return err
+	}
+	*mode = m
+	return nil
+}
+
+// index maps the pixel index i to one of the n pixels of a row or column.
+func (mode WrapMode) index(i, n int) int {
+	switch mode {
+	case WrapClamp:
+		if i < 0 {
+			return 0
+		}
+		return minInt(i, n-1)
+	case WrapMirror:
+		i = wrapInt(i, 2*n)
+		if i >= n {
+			i = 2*n - 1 - i
+		}
+		return i
+	default:
+		return wrapInt(i, n)
+	}
+}
+
+func wrapInt(i, n int) int {
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// bilinear filters the image at u, v, from 0 on the left and top edges to 1
+// on the right and bottom ones.
+func (img colorImage) bilinear(u, v float64, wrapU, wrapV WrapMode) Color {
+	x := u*float64(img.width) - 0.5
+	y := v*float64(img.height) - 0.5
+
+	x0, y0 := math.Floor(x), math.Floor(y)
+	tx, ty := x-x0, y-y0
+
+	col0 := wrapU.index(int(x0), img.width)
+	col1 := wrapU.index(int(x0)+1, img.width)
+	row0 := wrapV.index(int(y0), img.height)
+	row1 := wrapV.index(int(y0)+1, img.height)
+
+	top := Vec3(img.pixels[row0*img.width+col0]).MulFloat(1 - tx).Add(Vec3(img.pixels[row0*img.width+col1]).MulFloat(tx))
+	bottom := Vec3(img.pixels[row1*img.width+col0]).MulFloat(1 - tx).Add(Vec3(img.pixels[row1*img.width+col1]).MulFloat(tx))
+
+	return Color(top.MulFloat(1 - ty).Add(bottom.MulFloat(ty)))
+}
+
+func decodeLDRImage(r io.Reader, linear bool) (int, int, []Color, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	decode := SRGBToLinear
+	if linear {
+		decode = func(v float64) float64 { return v }
+	}
+
+	bounds := img.Bounds()
+	pixels := make([]Color, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pixels = append(pixels, Color{
+				decode(float64(r) / 0xffff),
+				decode(float64(g) / 0xffff),
+				decode(float64(b) / 0xffff),
+			})
+		}
+	}
+
+	return bounds.Dx(), bounds.Dy(), pixels, nil
+}
+
+// SRGBToLinear decodes an sRGB encoded value in [0, 1].
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// decodeRadianceHDR decodes a Radiance RGBE image, flat or with run-length
+// encoded scanlines, in the standard -Y height +X width orientation.
+func decodeRadianceHDR(r *bufio.Reader) (int, int, []Color, error) {
+	magic, err := r.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if !strings.HasPrefix(magic, "#?") {
+		return 0, 0, nil, errors.New("not a Radiance HDR file")
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return 0, 0, nil, fmt.Errorf("unsupported format %q", strings.TrimPrefix(line, "FORMAT="))
+		}
+	}
+
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil {
+		return 0, 0, nil, fmt.Errorf("unsupported resolution %q", strings.TrimSpace(resolution))
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, nil, fmt.Errorf("invalid resolution %dx%d", width, height)
+	}
+
+	pixels := make([]Color, 0, width*height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readRadianceScanline(r, scanline, width); err != nil {
+			return 0, 0, nil, fmt.Errorf("scanline %d: %w", y, err)
+		}
+		for x := 0; x < width; x++ {
+			pixels = append(pixels, rgbeToColor(scanline[4*x:4*x+4]))
+		}
+	}
+
+	return width, height, pixels, nil
+}
+
+// readRadianceScanline reads width RGBE pixels into scanline, 4 bytes each.
+func readRadianceScanline(r *bufio.Reader, scanline []byte, width int) error {
+	header, err := r.Peek(4)
+	if err != nil {
+		return err
+	}
+
+	// new run-length encoding: 2, 2, then the width, then each component of
+	// the scanline one after the other
+	if width < 8 || width > 0x7fff || header[0] != 2 || header[1] != 2 || header[2]&0x80 != 0 {
+		_, err := io.ReadFull(r, scanline)
+		return err
+	}
+	if int(header[2])<<8|int(header[3]) != width {
+		return errors.New("scanline width mismatch")
+	}
+	if _, err := r.Discard(4); err != nil {
+		return err
+	}
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if count > 128 {
+				// run of the same value
+				n := int(count - 128)
+				if x+n > width {
+					return errors.New("run overflows scanline")
+				}
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					scanline[4*x+c] = value
+					x++
+				}
+				continue
+			}
+
+			// run of different values
+			n := int(count)
+			if n == 0 || x+n > width {
+				return errors.New("invalid run")
+			}
+			for ; n > 0; n-- {
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				scanline[4*x+c] = value
+				x++
+			}
+		}
+	}
+
+	return nil
+}
+
+func rgbeToColor(rgbe []byte) Color {
+	if rgbe[3] == 0 {
+		return Color{}
+	}
+	f := math.Ldexp(1, int(rgbe[3])-(128+8))
+	return Color{float64(rgbe[0]) * f, float64(rgbe[1]) * f, float64(rgbe[2]) * f}
+}
@@ -0,0 +1,166 @@
+package tracer
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const hdrHeader = "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n"
+
+func decodeTestHDR(data []byte) (int, int, []Color, error) {
+	return decodeRadianceHDR(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func TestDecodeRadianceHDRFlat(t *testing.T) {
+	// scanlines narrower than 8 pixels can't be run-length encoded
+	data := append([]byte(hdrHeader+"-Y 2 +X 2\n"),
+		128, 64, 32, 129,
+		0, 0, 0, 0,
+		1, 2, 3, 136,
+		255, 0, 0, 128,
+	)
+
+	width, height, pixels, err := decodeTestHDR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Color{{1, 0.5, 0.25}, {}, {1, 2, 3}, {255.0 / 256, 0, 0}}
+	if width != 2 || height != 2 || len(pixels) != len(want) {
+		t.Fatalf("decoded a %dx%d image with %d pixels", width, height, len(pixels))
+	}
+	for i := range want {
+		if pixels[i] != want[i] {
+			t.Errorf("pixel %d is %v, want %v", i, pixels[i], want[i])
+		}
+	}
+}
+
+func TestDecodeRadianceHDRRunLength(t *testing.T) {
+	data := []byte(hdrHeader + "-Y 1 +X 8\n")
+	data = append(data, 2, 2, 0, 8)
+	// red: a run of 5 and 3 literals
+	data = append(data, 128+5, 10, 3, 20, 30, 40)
+	// green: 8 literals
+	data = append(data, 8, 1, 2, 3, 4, 5, 6, 7, 8)
+	// blue: runs of 2, 2 and 4
+	data = append(data, 128+2, 100, 128+2, 50, 128+4, 0)
+	// exponent: a run of 8
+	data = append(data, 128+8, 128)
+
+	_, _, pixels, err := decodeTestHDR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := []float64{10, 10, 10, 10, 10, 20, 30, 40}
+	blue := []float64{100, 100, 50, 50, 0, 0, 0, 0}
+	for x, p := range pixels {
+		want := Color{red[x] / 256, float64(x+1) / 256, blue[x] / 256}
+		if p != want {
+			t.Errorf("pixel %d is %v, want %v", x, p, want)
+		}
+	}
+}
+
+func TestDecodeRadianceHDRErrors(t *testing.T) {
+	scanline := func(bytes ...byte) string {
+		return hdrHeader + "-Y 1 +X 8\n" + string(append([]byte{2, 2, 0, 8}, bytes...))
+	}
+
+	tests := []struct {
+		name, data, err string
+	}{
+		{"magic", "P6\n", "not a Radiance HDR file"},
+		{"format", "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n", "unsupported format"},
+		{"resolution", hdrHeader + "+Y 1 +X 8\n", "unsupported resolution"},
+		{"empty resolution", hdrHeader + "-Y 0 +X 8\n", "invalid resolution"},
+		{"width mismatch", hdrHeader + "-Y 1 +X 8\n" + string([]byte{2, 2, 0, 9}), "scanline width mismatch"},
+		{"run overflow", scanline(128+9, 0), "run overflows scanline"},
+		{"empty run", scanline(0), "invalid run"},
+		{"literals overflow", scanline(9), "invalid run"},
+		{"truncated", scanline(128+8, 0, 4, 1, 2), "EOF"},
+	}
+
+	for _, test := range tests {
+		_, _, _, err := decodeTestHDR([]byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDecodeLDRImageSRGB(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0, G: 255, B: 188, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	width, height, pixels, err := decodeLDRImage(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 2 || height != 1 {
+		t.Fatalf("decoded a %dx%d image", width, height)
+	}
+	if p := pixels[0]; p[0] != 0 || p[1] != 1 || !approxEqual(p[2], 0.5029, 1e-4) {
+		t.Errorf("pixel %v, want sRGB decoded to linear", p)
+	}
+	if pixels[1] != (Color{1, 1, 1}) {
+		t.Errorf("pixel %v, want white", pixels[1])
+	}
+}
+
+func TestLoadColorImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	writeTestPNG(t, path, 1, 1, func(x, y int) [3]uint8 { return [3]uint8{0, 255, 188} })
+
+	for _, test := range []struct {
+		linear bool
+		want   Color
+	}{
+		{false, Color{0, 1, 0.5029}},
+		{true, Color{0, 1, 188.0 / 255}},
+	} {
+		img, err := loadColorImage(path, test.linear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.width != 1 || img.height != 1 || !approxVec3(Vec3(img.pixels[0]), Vec3(test.want), 1e-4) {
+			t.Errorf("linear %v: loaded %v, want %v", test.linear, img.pixels, test.want)
+		}
+	}
+
+	if _, err := loadColorImage(filepath.Join(filepath.Dir(path), "missing.png"), false); err == nil {
+		t.Error("loaded a missing image")
+	}
+}
+
+// writeTestPNG writes a width by height PNG with the 8-bit colors of pixel.
+func writeTestPNG(t *testing.T, path string, width, height int, pixel func(x, y int) [3]uint8) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := pixel(x, y)
+			img.SetNRGBA(x, y, color.NRGBA{R: c[0], G: c[1], B: c[2], A: 255})
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,4 +7,5 @@ var DefaultRenderer *Renderer
 func init() {
 	DefaultRenderer = NewRenderer(runtime.NumCPU())
 	Render = DefaultRenderer.Render
+	RenderContext = DefaultRenderer.RenderContext
 }
@@ -0,0 +1,331 @@
+package tracer
+
+import "math"
+
+// Integrator computes the light arriving at the camera along camera rays,
+// i.e. how scenes are rendered. An Integrator is prepared with the scene of a
+// render before any of its samples, then called concurrently by the render's
+// workers, so it renders one scene at a time.
+type Integrator interface {
+	// Prepare readies the integrator to render scene.
+	Prepare(scene RenderScene) error
+	// Li evaluates the camera sample along ray. All the randomness of the
+	// sample must come from state.Sampler, and every ray traced be counted in
+	// state.
+	Li(ray Ray, state *RayState) IntegratorSample
+}
+
+// RenderScene is what an Integrator renders, as given by RenderSettings.
+type RenderScene struct {
+	Hitter Hitter
+	// All the lights of the render: RenderSettings.Lights and the area
+	// lights of Hitter's emissive shapes
+	Lights     []Light
+	Background Background
+	// Maximum number of rays of a path
+	MaxDepth int
+}
+
+// IntegratorSample is what an Integrator computes for a camera sample: the
+// light arriving along the camera ray, and auxiliary outputs about the first
+// surface the ray hits (zero when it hits nothing), e.g. for denoisers.
+type IntegratorSample struct {
+	Radiance Color
+	// Fraction of light the surface scatters, or its clamped emission when it
+	// doesn't scatter any
+	Albedo Color
+	// Normal facing the ray
+	Normal Vec3
+	// Distance from the ray's origin
+	Depth float64
+}
+
+// setFirstHit sets the auxiliary outputs of the first surface hit, hr, of
+// ray, the albedo aside.
+func (s *IntegratorSample) setFirstHit(ray Ray, hr HitRecord) {
+	s.Normal = hr.Normal
+	s.Depth = hr.T * ray.Direction.Len()
+}
+
+// setAlbedo sets the albedo of the first surface hit from how it scatters,
+// or from its emission when it doesn't.
+func (s *IntegratorSample) setAlbedo(sr ScatterRecord, emitted Color) {
+	if sr.Scatter {
+		s.Albedo = sr.Attenuation
+		return
+	}
+	for i := range emitted {
+		s.Albedo[i] = Clamp(emitted[i], 0, 1)
+	}
+}
+
+// PathIntegrator is a path tracer, following camera rays as they scatter
+// around the scene and adding up the light emitted towards them along the
+// way, weighted by the throughput of the path so far. Paths are absorbed,
+// adding no more light, when they stop scattering, after MaxDepth rays, or
+// when Russian roulette terminates them. Lights which aren't hitters are
+// ignored, see MISIntegrator.
+type PathIntegrator struct {
+	scene RenderScene
+}
+
+func (p *PathIntegrator) Prepare(scene RenderScene) error {
+	p.scene = scene
+	return nil
+}
+
+func (p *PathIntegrator) Li(ray Ray, state *RayState) IntegratorSample {
+	var sample IntegratorSample
+
+	radiance := Vec3{}
+	throughput := Vec3{1, 1, 1}
+
+	for bounces := 0; bounces < p.scene.MaxDepth; bounces++ {
+		hr := p.scene.Hitter.Hit(ray)
+		state.trace(hr)
+		if !hr.Hit {
+			radiance = radiance.Add(throughput.MulVec3(Vec3(p.scene.Background.Emitted(ray))))
+			break
+		}
+
+		emitted := hr.Material.Emitted(ray, hr)
+		radiance = radiance.Add(throughput.MulVec3(Vec3(emitted)))
+
+		sr := hr.Material.Scatter(ray, hr, state.Sampler)
+		if bounces == 0 {
+			sample.setFirstHit(ray, hr)
+			sample.setAlbedo(sr, emitted)
+		}
+		if !sr.Scatter {
+			break
+		}
+
+		var alive bool
+		throughput, alive = russianRoulette(throughput.MulVec3(Vec3(sr.Attenuation)), bounces, state.Sampler)
+		if !alive {
+			break
+		}
+		ray = scatteredRay(ray, sr)
+	}
+
+	sample.Radiance = Color(radiance)
+	return sample
+}
+
+// scatteredRay is the ray sr scatters ray into, which keeps the wavelength of
+// the path when sr's has none.
+func scatteredRay(ray Ray, sr ScatterRecord) Ray {
+	next := sr.Ray
+	if next.Wavelength == 0 {
+		next.Wavelength = ray.Wavelength
+	}
+	return next
+}
+
+// rouletteBounces is how many bounces paths make before Russian roulette may
+// terminate them.
+const rouletteBounces = 3
+
+// russianRoulette randomly terminates paths, with a higher probability the
+// less light they carry, and scales the throughput of the surviving ones up
+// to make up for the terminated ones. It returns the new throughput and
+// whether the path survived.
+func russianRoulette(throughput Vec3, bounces int, sampler Sampler) (Vec3, bool) {
+	if bounces+1 < rouletteBounces {
+		return throughput, !throughput.Zero()
+	}
+
+	survival := math.Min(math.Max(throughput[0], math.Max(throughput[1], throughput[2])), 0.95)
+	if survival <= 0 || sampler.Get1D() >= survival {
+		return Vec3{}, false
+	}
+	return throughput.MulFloat(1 / survival), true
+}
+
+// MISIntegrator is a path tracer estimating direct lighting at every bounce
+// twice: with a shadow ray towards a point sampled on one of the scene's
+// lights (next event estimation) and with the scattered ray, should it hit
+// an emissive surface. Both estimates are combined with multiple importance
+// sampling (the power heuristic), which keeps small lights as well as glossy
+// reflections of large ones from being noisy. Specular bounces can't sample
+// lights, the light they reach is taken from the scattered ray alone.
+type MISIntegrator struct {
+	scene RenderScene
+}
+
+func (m *MISIntegrator) Prepare(scene RenderScene) error {
+	m.scene = scene
+	return nil
+}
+
+func (m *MISIntegrator) Li(ray Ray, state *RayState) IntegratorSample {
+	var sample IntegratorSample
+
+	radiance := Vec3{}
+	throughput := Vec3{1, 1, 1}
+
+	// about the previous bounce
+	specular := true
+	scatterPDF := 0.0
+
+	for bounces := 0; bounces < m.scene.MaxDepth; bounces++ {
+		hr := m.scene.Hitter.Hit(ray)
+		state.trace(hr)
+		if !hr.Hit {
+			radiance = radiance.Add(throughput.MulVec3(Vec3(m.scene.Background.Emitted(ray))))
+			break
+		}
+
+		emitted := hr.Material.Emitted(ray, hr)
+		if !Vec3(emitted).Zero() {
+			weight := 1.0
+			if !specular && len(m.scene.Lights) > 0 {
+				weight = powerHeuristic(scatterPDF, m.lightsPDF(ray, hr))
+			}
+			radiance = radiance.Add(throughput.MulVec3(Vec3(emitted)).MulFloat(weight))
+		}
+
+		sr := hr.Material.Scatter(ray, hr, state.Sampler)
+		if bounces == 0 {
+			sample.setFirstHit(ray, hr)
+			sample.setAlbedo(sr, emitted)
+		}
+		if !sr.Scatter {
+			break
+		}
+
+		// the shadow ray is one more ray of the path
+		if !sr.Specular && len(m.scene.Lights) > 0 && bounces+1 < m.scene.MaxDepth {
+			radiance = radiance.Add(throughput.MulVec3(Vec3(m.sampleLights(ray, hr, state))))
+		}
+
+		var alive bool
+		throughput, alive = russianRoulette(throughput.MulVec3(Vec3(sr.Attenuation)), bounces, state.Sampler)
+		if !alive {
+			break
+		}
+		specular = sr.Specular
+		scatterPDF = sr.PDF
+		ray = scatteredRay(ray, sr)
+	}
+
+	sample.Radiance = Color(radiance)
+	return sample
+}
+
+// sampleLights estimates the light arriving at hr from a point sampled on one
+// of the scene's lights, picked uniformly, and scattered towards ray's
+// origin.
+func (m *MISIntegrator) sampleLights(ray Ray, hr HitRecord, state *RayState) Color {
+	n := len(m.scene.Lights)
+	light := m.scene.Lights[minInt(int(state.Sampler.Get1D()*float64(n)), n-1)]
+
+	ls := light.SampleLi(hr.P, state.Sampler.Get1D(), state.Sampler.Get1D())
+	if ls.PDF == 0 || Vec3(ls.Radiance).Zero() {
+		return Color{}
+	}
+
+	f := Vec3(hr.Material.Eval(ray, hr, ls.Direction))
+	if f.Zero() {
+		return Color{}
+	}
+
+	shadow := m.scene.Hitter.Hit(Ray{Origin: hr.P, Direction: ls.Direction})
+	state.trace(shadow)
+	if shadow.Hit && shadow.T < ls.Distance-shadowEpsilon {
+		return Color{}
+	}
+
+	lightPDF := ls.PDF / float64(n)
+	weight := 1.0
+	if !ls.Delta {
+		weight = powerHeuristic(lightPDF, hr.Material.PDF(ray, hr, ls.Direction))
+	}
+
+	return Color(f.MulVec3(Vec3(ls.Radiance)).MulFloat(weight / lightPDF))
+}
+
+// shadowEpsilon is how far short of a sampled light point an occluder must be
+// to shadow it, so the light itself doesn't.
+const shadowEpsilon = 0.001
+
+// lightsPDF is the density with which sampleLights samples the direction of
+// ray, which first hits the scene at hr.
+func (m *MISIntegrator) lightsPDF(ray Ray, hr HitRecord) float64 {
+	pdf := 0.0
+	for i := range m.scene.Lights {
+		pdf += m.scene.Lights[i].PDF(ray, hr)
+	}
+	return pdf / float64(len(m.scene.Lights))
+}
+
+// powerHeuristic is the multiple importance sampling weight of a sample with
+// density pdf, given the density otherPDF of the other sampling strategy.
+func powerHeuristic(pdf, otherPDF float64) float64 {
+	pdf, otherPDF = pdf*pdf, otherPDF*otherPDF
+	if pdf+otherPDF == 0 {
+		return 0
+	}
+	return pdf / (pdf + otherPDF)
+}
+
+// BVHIDIntegrator renders the ID of the BVH node holding the hitter camera
+// rays hit, or Transparent when they miss, see EdgeSamples. Hitters outside
+// any BVH node render as ID 0, which no node has.
+type BVHIDIntegrator struct {
+	scene RenderScene
+}
+
+func (b *BVHIDIntegrator) Prepare(scene RenderScene) error {
+	b.scene = scene
+	return nil
+}
+
+func (b *BVHIDIntegrator) Li(ray Ray, state *RayState) IntegratorSample {
+	hr := b.scene.Hitter.Hit(ray)
+	state.trace(hr)
+	if !hr.Hit {
+		return IntegratorSample{Radiance: Transparent}
+	}
+
+	var id uint64
+	if hr.BVHNode != nil {
+		id = hr.BVHNode.ID
+	}
+	sample := IntegratorSample{Radiance: Uint64ToColor(id)}
+	sample.setFirstHit(ray, hr)
+	return sample
+}
+
+// DistanceIntegrator renders how far what camera rays hit is, as 1 minus a
+// tenth of the distance, and black past a distance of 100.
+type DistanceIntegrator struct {
+	scene RenderScene
+}
+
+func (d *DistanceIntegrator) Prepare(scene RenderScene) error {
+	d.scene = scene
+	return nil
+}
+
+func (d *DistanceIntegrator) Li(ray Ray, state *RayState) IntegratorSample {
+	hr := d.scene.Hitter.Hit(ray)
+	state.trace(hr)
+	if !hr.Hit {
+		return IntegratorSample{}
+	}
+
+	sample := IntegratorSample{}
+	sample.setFirstHit(ray, hr)
+
+	distVec := Vec3(ray.Origin).Sub(Vec3(hr.P))
+	dist := distVec.Len()
+
+	if dist > 100 {
+		return sample
+	}
+
+	col := (-25.5*dist + 255.0) / 255.0
+	sample.Radiance = Color{col, col, col}
+	return sample
+}
@@ -0,0 +1,160 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+)
+
+// testRenderSettings are the settings of a small render of the Cornell box,
+// ready for samplePixel.
+func testRenderSettings(tb testing.TB, integrator Integrator) *RenderSettings {
+	tb.Helper()
+	bvh, err := NewBVHNode(testCornellBox())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	camera := testCornellCamera
+	camera.GetRay(0, 0)
+	settings := &RenderSettings{
+		Frame:           NewFrame(32, 32, false),
+		Camera:          &camera,
+		Hitter:          bvh,
+		Lights:          []Light{PointLight{Position: Point3{278, 500, 278}, Color: Color{1000, 1000, 1000}}},
+		SamplesPerPixel: 16,
+		MaxDepth:        10,
+		Integrator:      integrator,
+	}
+	if err := settings.prepareIntegrator(); err != nil {
+		tb.Fatal(err)
+	}
+	return settings
+}
+
+func TestSamplePixelAllocations(t *testing.T) {
+	for _, integrator := range []Integrator{&PathIntegrator{}, &MISIntegrator{}} {
+		settings := testRenderSettings(t, integrator)
+		state := &RayState{Sampler: NewSampler(SamplerSobol, 1, settings.SamplesPerPixel)}
+		samples := make([]Color, settings.SamplesPerPixel)
+
+		pixel := 0
+		allocs := testing.AllocsPerRun(100, func() {
+			var aux auxEstimate
+			samplePixel(settings, pixel%32, (pixel*7)%32, 0, samples, &aux, state)
+			pixel++
+		})
+		if allocs != 0 {
+			t.Errorf("%T: %v allocations per pixel, want 0", integrator, allocs)
+		}
+		if state.Rays <= state.PrimaryRays {
+			t.Errorf("%T: traced %d rays for %d camera rays, paths didn't bounce", integrator, state.Rays, state.PrimaryRays)
+		}
+	}
+}
+
+func benchmarkLi(b *testing.B, integrator Integrator) {
+	settings := testRenderSettings(b, integrator)
+	state := &RayState{Sampler: NewSampler(SamplerSobol, 1, b.N)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		row, col := (i/32)%32, i%32
+		state.Sampler.StartSample(row, col, i)
+		u, v := JitteredCameraCoordinatesFromPixel(row, col, 32, 32, state.Sampler)
+		integrator.Li(settings.Camera.GetRay(u, v), state)
+	}
+}
+
+func BenchmarkPathIntegratorLi(b *testing.B) {
+	benchmarkLi(b, &PathIntegrator{})
+}
+
+func BenchmarkMISIntegratorLi(b *testing.B) {
+	benchmarkLi(b, &MISIntegrator{})
+}
+
+func TestDistanceIntegrator(t *testing.T) {
+	var d DistanceIntegrator
+	if err := d.Prepare(RenderScene{Hitter: NewPlane(Point3{0, 0, 0}, Vec3{0, 1, 0}, Lambertian{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		height, want float64
+	}{
+		{2.5, 0.75},
+		{10, 0},
+		{150, 0},
+	} {
+		sample := d.Li(Ray{Origin: Point3{0, test.height, 0}, Direction: Vec3{0, -1, 0}}, &RayState{})
+		if !approxVec3(Vec3(sample.Radiance), Vec3{test.want, test.want, test.want}, testEpsilon) {
+			t.Errorf("at a distance of %v: %v, want %v", test.height, sample.Radiance, test.want)
+		}
+		if !approxEqual(sample.Depth, test.height, testEpsilon) {
+			t.Errorf("at a distance of %v: depth %v", test.height, sample.Depth)
+		}
+	}
+}
+
+func TestBVHIDIntegrator(t *testing.T) {
+	sphere := NewSphere(Point3{0, 0, -2}, 1, Lambertian{})
+	bvh, err := NewBVHNode(HitterList{sphere, NewPlane(Point3{0, -5, 0}, Vec3{0, 1, 0}, Lambertian{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hitter Hitter
+		ray    Ray
+		want   Color
+	}{
+		{"bvh", bvh, Ray{Origin: Point3{}, Direction: Vec3{0, 0, -1}}, Uint64ToColor(bvh.ID)},
+		{"unbounded", bvh, Ray{Origin: Point3{}, Direction: Vec3{0, -1, 0}}, Uint64ToColor(bvh.ID)},
+		{"no bvh", sphere, Ray{Origin: Point3{}, Direction: Vec3{0, 0, -1}}, Uint64ToColor(0)},
+		{"miss", bvh, Ray{Origin: Point3{}, Direction: Vec3{0, 1, 0}}, Transparent},
+	}
+
+	for _, test := range tests {
+		var b BVHIDIntegrator
+		if err := b.Prepare(RenderScene{Hitter: test.hitter}); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.Li(test.ray, &RayState{}).Radiance; got != test.want {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenderAuxFrames(t *testing.T) {
+	albedo := Color{0.2, 0.4, 0.6}
+	camera := Camera{AspectRatio: 1, VFoV: 90, LookFrom: Point3{0, 0, 0}, LookAt: Point3{0, 0, -1}, VUp: Vec3{0, 1, 0}}
+	settings := RenderSettings{
+		Frame:           NewFrame(4, 4, false),
+		Camera:          &camera,
+		Hitter:          NewPlane(Point3{0, 0, -2}, Vec3{0, 0, 1}, Lambertian{Albedo: albedo}),
+		SamplesPerPixel: 4,
+		MaxDepth:        4,
+		AggColorFunc:    AvgSamples,
+		AlbedoFrame:     NewFrame(4, 4, false),
+		NormalFrame:     NewFrame(4, 4, false),
+		DepthFrame:      NewFrame(4, 4, false),
+	}
+	if _, err := testRenderer(t, 1).RenderContext(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			if got := settings.AlbedoFrame.Get(row, col); !approxVec3(Vec3(got), Vec3(albedo), testEpsilon) {
+				t.Errorf("(%d, %d): albedo %v, want %v", row, col, got, albedo)
+			}
+			if got := settings.NormalFrame.Get(row, col); !approxVec3(Vec3(got), Vec3{0, 0, 1}, testEpsilon) {
+				t.Errorf("(%d, %d): normal %v, want (0, 0, 1)", row, col, got)
+			}
+			if depth := settings.DepthFrame.Get(row, col)[0]; depth < 2 {
+				t.Errorf("(%d, %d): depth %v, closer than the plane", row, col, depth)
+			}
+		}
+	}
+}
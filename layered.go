@@ -0,0 +1,304 @@
+package tracer
+
+import (
+	"errors"
+	"math"
+)
+
+// Mix blends materials A and B, e.g. dust over metal: surfaces scatter and
+// emit light like A where the amount of B is 0, like B where it's 1, and like
+// a weighted mix of both in between.
+type Mix struct {
+	A Material `json:"a"`
+	B Material `json:"b"`
+	// Amount of B, from 0 to 1
+	Amount float64 `json:"amount,omitempty"`
+	// Mask's luminance, when set, is the amount of B instead of Amount
+	Mask Texture `json:"mask,omitempty"`
+}
+
+func (m *Mix) finishDecode() error {
+	if m.Amount < 0 || m.Amount > 1 {
+		return errors.New("amount must be between 0 and 1")
+	}
+	return nil
+}
+
+// amount is the amount of B at hr.
+func (m Mix) amount(hr HitRecord) float64 {
+	return Clamp(textureScalar(m.Mask, m.Amount, hr), 0, 1)
+}
+
+// Scatter samples either material, with the probability of its amount. The
+// specular scattering of either is kept as is, the rest is weighted by both.
+func (m Mix) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	t := m.amount(hr)
+	switch t {
+	case 0:
+		return m.A.Scatter(ray, hr, sampler)
+	case 1:
+		return m.B.Scatter(ray, hr, sampler)
+	}
+
+	material := m.A
+	if sampler.Get1D() < t {
+		material = m.B
+	}
+	sr := material.Scatter(ray, hr, sampler)
+	if !sr.Scatter || sr.Specular {
+		return sr
+	}
+
+	wi := sr.Ray.Direction
+	pdf := m.pdf(ray, hr, wi, t)
+	if pdf == 0 {
+		return ScatterRecord{}
+	}
+	sr.Attenuation = Color(Vec3(m.eval(ray, hr, wi, t)).MulFloat(1 / pdf))
+	sr.PDF = pdf
+	return sr
+}
+
+func (m Mix) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	return m.eval(ray, hr, wi, m.amount(hr))
+}
+
+func (m Mix) eval(ray Ray, hr HitRecord, wi Vec3, t float64) Color {
+	var a, b Color
+	if t < 1 {
+		a = m.A.Eval(ray, hr, wi)
+	}
+	if t > 0 {
+		b = m.B.Eval(ray, hr, wi)
+	}
+	return Color(lerpVec3(Vec3(a), Vec3(b), t))
+}
+
+func (m Mix) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	return m.pdf(ray, hr, wi, m.amount(hr))
+}
+
+func (m Mix) pdf(ray Ray, hr HitRecord, wi Vec3, t float64) float64 {
+	var a, b float64
+	if t < 1 {
+		a = m.A.PDF(ray, hr, wi)
+	}
+	if t > 0 {
+		b = m.B.PDF(ray, hr, wi)
+	}
+	return (1-t)*a + t*b
+}
+
+func (m Mix) Emissive() bool {
+	return emissive(m.A) || emissive(m.B)
+}
+
+func (m Mix) Emitted(ray Ray, hr HitRecord) Color {
+	t := m.amount(hr)
+	return Color(lerpVec3(Vec3(m.A.Emitted(ray, hr)), Vec3(m.B.Emitted(ray, hr)), t))
+}
+
+// Coated puts a clear dielectric coat over Base, e.g. varnish over wood or
+// the clear coat of car paint. The coat reflects light with its Fresnel
+// reflectance, and the light it transmits on the way in and on the way out is
+// scattered by Base, less what the coat absorbs.
+//
+// Light isn't bent by the coat, Base scatters it as if uncoated. Back faces
+// aren't coated.
+type Coated struct {
+	Base Material `json:"base"`
+	// RefractiveIndex of the coat, 1.5 when zero
+	RefractiveIndex float64 `json:"refractive_index,omitempty"`
+	// Roughness of the coat, from 0, perfectly smooth, to 1
+	Roughness float64 `json:"roughness,omitempty"`
+	// Anisotropy stretches reflections along the tangent of hits, from 0 to 1
+	Anisotropy float64 `json:"anisotropy,omitempty"`
+	// Absorption of every channel by the coat, crossing it at normal
+	// incidence: light is tinted by exp(-Absorption) each way, more so at
+	// grazing angles.
+	Absorption Color `json:"absorption,omitempty"`
+}
+
+func (c *Coated) finishDecode() error {
+	if c.RefractiveIndex < 0 {
+		return errors.New("refractive index can't be negative")
+	}
+	for _, a := range c.Absorption {
+		if a < 0 {
+			return errors.New("absorption can't be negative")
+		}
+	}
+	return validRoughness(c.Roughness, c.Anisotropy)
+}
+
+// coatedLayer is the coat of a Coated material at a hit, for light scattered
+// towards wo.
+type coatedLayer struct {
+	frame localFrame
+	wo    Vec3
+	eta   float64
+	// smooth coats are specular, rough ones reflect off coat's microfacets
+	smooth bool
+	coat   ggx
+	// probability of Scatter sampling the coat rather than the base
+	pCoat float64
+}
+
+// layer returns the coat at hr, or false when hr isn't coated.
+func (c Coated) layer(ray Ray, hr HitRecord) (coatedLayer, bool) {
+	l := coatedLayer{frame: newLocalFrame(hr)}
+	l.wo = l.frame.toLocal(ray.Direction.Unit().Neg())
+	if !hr.FrontFace || l.wo[2] <= 0 {
+		return l, false
+	}
+
+	l.eta = c.RefractiveIndex
+	if l.eta == 0 {
+		l.eta = 1.5
+	}
+	l.smooth = smooth(c.Roughness)
+	l.coat = newGGX(c.Roughness, c.Anisotropy)
+
+	// the coat is sampled at least as often as to keep its highlights over
+	// dark bases from being left to the base
+	l.pCoat = math.Max(fresnelDielectric(l.wo[2], l.eta), 0.25)
+	return l, true
+}
+
+// transmittance is the fraction of light crossing the coat both ways, towards
+// wo and from wi, both local.
+func (c Coated) transmittance(l coatedLayer, wi Vec3) Color {
+	fresnel := (1 - fresnelDielectric(l.wo[2], l.eta)) * (1 - fresnelDielectric(math.Abs(wi[2]), l.eta))
+	t := Vec3{1, 1, 1}.MulFloat(fresnel)
+	if Vec3(c.Absorption).Zero() {
+		return Color(t)
+	}
+
+	// distances through the coat, refracted, per unit of thickness
+	distance := 1/l.refractedCos(l.wo[2]) + 1/l.refractedCos(wi[2])
+	for i := range t {
+		t[i] *= math.Exp(-c.Absorption[i] * distance)
+	}
+	return Color(t)
+}
+
+// refractedCos is the cosine with the normal inside the coat of light at
+// cosine cos outside.
+func (l coatedLayer) refractedCos(cos float64) float64 {
+	sin2 := (1 - cos*cos) / (l.eta * l.eta)
+	return math.Sqrt(math.Max(0, 1-sin2))
+}
+
+func (c Coated) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	l, ok := c.layer(ray, hr)
+	if !ok {
+		return c.Base.Scatter(ray, hr, sampler)
+	}
+
+	if sampler.Get1D() < l.pCoat {
+		if l.smooth {
+			wi := Vec3{-l.wo[0], -l.wo[1], l.wo[2]}
+			fresnel := fresnelDielectric(l.wo[2], l.eta)
+			return ScatterRecord{
+				Scatter:     true,
+				Ray:         Ray{Origin: hr.P, Direction: l.frame.fromLocal(wi)},
+				Attenuation: Color(Vec3{1, 1, 1}.MulFloat(fresnel / l.pCoat)),
+				Specular:    true,
+			}
+		}
+
+		u1, u2 := sampler.Get2D()
+		wi := reflectVector(l.wo.Neg(), l.coat.sampleWm(l.wo, u1, u2))
+		if wi[2] <= 0 {
+			return ScatterRecord{}
+		}
+		direction := l.frame.fromLocal(wi)
+		return c.weighted(ray, hr, l, direction, ScatterRecord{
+			Scatter: true,
+			Ray:     Ray{Origin: hr.P, Direction: direction},
+		})
+	}
+
+	sr := c.Base.Scatter(ray, hr, sampler)
+	if !sr.Scatter {
+		return sr
+	}
+	wi := sr.Ray.Direction
+	t := c.transmittance(l, l.frame.toLocal(wi.Unit()))
+	if sr.Specular || l.smooth {
+		sr.Attenuation = Color(Vec3(sr.Attenuation).MulVec3(Vec3(t)).MulFloat(1 / (1 - l.pCoat)))
+		sr.PDF *= 1 - l.pCoat
+		return sr
+	}
+	return c.weighted(ray, hr, l, wi, sr)
+}
+
+// weighted sets the attenuation and density of sr, scattered towards wi, to
+// those of the coat and the base together.
+func (c Coated) weighted(ray Ray, hr HitRecord, l coatedLayer, wi Vec3, sr ScatterRecord) ScatterRecord {
+	pdf := c.pdf(ray, hr, l, wi)
+	if pdf == 0 {
+		return ScatterRecord{}
+	}
+	sr.Attenuation = Color(Vec3(c.eval(ray, hr, l, wi)).MulFloat(1 / pdf))
+	sr.PDF = pdf
+	return sr
+}
+
+func (c Coated) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	l, ok := c.layer(ray, hr)
+	if !ok {
+		return c.Base.Eval(ray, hr, wi)
+	}
+	return c.eval(ray, hr, l, wi)
+}
+
+func (c Coated) eval(ray Ray, hr HitRecord, l coatedLayer, wi Vec3) Color {
+	local := l.frame.toLocal(wi.Unit())
+	f := Vec3(c.Base.Eval(ray, hr, wi)).MulVec3(Vec3(c.transmittance(l, local)))
+
+	if !l.smooth && local[2] > 0 {
+		wm := l.wo.Add(local).Unit()
+		d := l.coat.d(wm) * l.coat.g(l.wo, local) / (4 * l.wo[2])
+		f = f.Add(Vec3{1, 1, 1}.MulFloat(fresnelDielectric(l.wo.Dot(wm), l.eta) * d))
+	}
+	return Color(f)
+}
+
+func (c Coated) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	l, ok := c.layer(ray, hr)
+	if !ok {
+		return c.Base.PDF(ray, hr, wi)
+	}
+	return c.pdf(ray, hr, l, wi)
+}
+
+func (c Coated) pdf(ray Ray, hr HitRecord, l coatedLayer, wi Vec3) float64 {
+	pdf := (1 - l.pCoat) * c.Base.PDF(ray, hr, wi)
+
+	local := l.frame.toLocal(wi.Unit())
+	if !l.smooth && local[2] > 0 {
+		wm := l.wo.Add(local).Unit()
+		pdf += l.pCoat * l.coat.visibleD(l.wo, wm) / (4 * l.wo.Dot(wm))
+	}
+	return pdf
+}
+
+func (c Coated) Emissive() bool {
+	return emissive(c.Base)
+}
+
+// Emitted is the light emitted by Base through the coat.
+func (c Coated) Emitted(ray Ray, hr HitRecord) Color {
+	emitted := c.Base.Emitted(ray, hr)
+	l, ok := c.layer(ray, hr)
+	if !ok || Vec3(emitted).Zero() {
+		return emitted
+	}
+
+	t := Vec3{1, 1, 1}.MulFloat(1 - fresnelDielectric(l.wo[2], l.eta))
+	for i := range t {
+		t[i] *= math.Exp(-c.Absorption[i] / l.refractedCos(l.wo[2]))
+	}
+	return Color(Vec3(emitted).MulVec3(t))
+}
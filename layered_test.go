@@ -0,0 +1,96 @@
+package tracer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLayeredScatter(t *testing.T) {
+	white := Lambertian{Albedo: Color{1, 1, 1}}
+	red := Lambertian{Albedo: Color{0.8, 0.1, 0.1}}
+	whiteMetal := Conductor{IOR: ComplexIOR{Eta: Color{1e-4, 1e-4, 1e-4}, K: Color{1e4, 1e4, 1e4}}, Roughness: 0.2}
+	gold := Conductor{IOR: IORGold, Roughness: 0.3}
+	oblique := Vec3{-3, -1.2, -0.7}
+
+	tests := []struct {
+		scatterCase
+		// bounds of the albedo's luminance
+		min, max float64
+	}{
+		{testSphereCase("white mix", Mix{A: white, B: whiteMetal, Amount: 0.5}, oblique, false), 0.97, 1},
+		{testSphereCase("masked white mix", Mix{A: white, B: whiteMetal, Mask: NoiseTexture{Color: Color{1, 1, 1}}}, oblique, false), 0.97, 1},
+		{testSphereCase("diffuse and gold", Mix{A: red, B: gold, Amount: 0.3}, oblique, false), 0.3, 0.5},
+		{testSphereCase("diffuse and mirror", Mix{A: red, B: Conductor{IOR: IORSilver}, Amount: 0.5}, oblique, false), 0.5, 0.7},
+		{testSphereCase("all diffuse", Mix{A: red, B: gold}, oblique, false), red.Albedo.Luminance() - 1e-9, red.Albedo.Luminance() + 1e-9},
+		// the coat loses what it reflects back to the base
+		{testSphereCase("coated white", Coated{Base: white}, oblique, false), 0.85, 1},
+		{testSphereCase("rough coated white", Coated{Base: white, Roughness: 0.3}, oblique, false), 0.85, 1},
+		{testSphereCase("coated paint", Coated{Base: Principled{BaseColor: Color{0.6, 0.05, 0.05}, Metallic: 0.5, Roughness: 0.4}, Roughness: 0.1}, oblique, false), 0.1, 0.3},
+		{testSphereCase("tinted coat", Coated{Base: gold, Roughness: 0.2, Absorption: Color{0.1, 0.3, 0.6}}, oblique, false), 0.3, 0.6},
+		{testSphereCase("coated mirror", Coated{Base: Conductor{IOR: IORSilver}}, oblique, false), 0.85, 1},
+		{testSphereCase("coated mix", Coated{Base: Mix{A: red, B: gold, Amount: 0.5}, Roughness: 0.5}, oblique, false), 0.4, 0.6},
+	}
+
+	for _, test := range tests {
+		albedo := checkScatter(t, test.scatterCase, 20000)
+		if l := albedo.Luminance(); l < test.min || l > test.max {
+			t.Errorf("%s: albedo %v, want a luminance in [%v, %v]", test.name, albedo, test.min, test.max)
+		}
+	}
+}
+
+func TestCoatedBackFace(t *testing.T) {
+	base := Conductor{IOR: IORGold, Roughness: 0.4}
+	coated := Coated{Base: base, Roughness: 0.1, Absorption: Color{1, 1, 1}}
+	c := testSphereCase("inside", coated, Vec3{0.7, 0.3, -0.2}, true)
+
+	s := NewSobolSampler(1)
+	for i := 0; i < 16; i++ {
+		s.StartSample(0, 0, i)
+		wi := UniformSampleSphere(s.Get2D())
+		if got, want := coated.Eval(c.ray, c.hr, wi), base.Eval(c.ray, c.hr, wi); got != want {
+			t.Errorf("back face Eval %v, want the base's %v", got, want)
+		}
+		if got, want := coated.PDF(c.ray, c.hr, wi), base.PDF(c.ray, c.hr, wi); got != want {
+			t.Errorf("back face PDF %v, want the base's %v", got, want)
+		}
+	}
+}
+
+func TestMixEmission(t *testing.T) {
+	m := Mix{A: Lambertian{Albedo: Color{1, 1, 1}}, B: DiffuseLight{Emit: Color{4, 2, 0}}, Amount: 0.25}
+	c := testSphereCase("emission", m, Vec3{0, 0, -1}, false)
+
+	if !m.Emissive() || m.Emitted(c.ray, c.hr) != (Color{1, 0.5, 0}) {
+		t.Errorf("mix emits %v, want a quarter of B's", m.Emitted(c.ray, c.hr))
+	}
+	if (Mix{A: Lambertian{}, B: Lambertian{}}).Emissive() {
+		t.Error("mix of materials that don't emit is emissive")
+	}
+}
+
+func TestLayeredJSONErrors(t *testing.T) {
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+	const white = `"white": {"type": "lambertian", "albedo": [1, 1, 1]}`
+
+	tests := []struct {
+		material, path, err string
+	}{
+		{`{"type": "mix", "a": "white", "b": "white", "amount": 2}`, "materials.m", "amount must be between 0 and 1"},
+		{`{"type": "mix", "a": "white", "amount": 0.5}`, "materials.m.b", "missing material"},
+		{`{"type": "coated", "base": "white", "roughness": -1}`, "materials.m", "roughness must be between 0 and 1"},
+		{`{"type": "coated", "base": "white", "absorption": [0, -1, 0]}`, "materials.m", "absorption can't be negative"},
+		{`{"type": "coated", "base": "white", "refractive_index": -1}`, "materials.m", "refractive index can't be negative"},
+		{`{"type": "coated", "base": "m"}`, "materials.m.base", "references itself"},
+	}
+
+	for _, test := range tests {
+		json := `{"version": 1, ` + camera + `, "materials": {` + white + `, "m": ` + test.material + `}, "shapes": []}`
+		_, err := DecodeJSONScene(strings.NewReader(json))
+		var sceneErr *SceneError
+		if !errors.As(err, &sceneErr) || sceneErr.Path != test.path || !strings.Contains(sceneErr.Err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q at %q", test.material, err, test.err, test.path)
+		}
+	}
+}
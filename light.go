@@ -0,0 +1,612 @@
+package tracer
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Light is a light source that integrators can sample directly, with shadow
+// rays, rather than waiting for paths to hit it.
+type Light interface {
+	// SampleLi samples the light arriving at p from the light.
+	SampleLi(p Point3, u1, u2 float64) LightSample
+	// PDF is the density, per solid angle, with which SampleLi samples the
+	// direction of ray from its origin, given that ray first hits the scene at
+	// hr. It's zero when the light isn't what ray hits.
+	PDF(ray Ray, hr HitRecord) float64
+}
+
+// LightSample is a sample of the light arriving at a point.
+type LightSample struct {
+	// Unit direction from the point towards the light
+	Direction Vec3
+	// Distance from the point to the sampled point of the light
+	Distance float64
+	// Radiance arriving at the point, unoccluded
+	Radiance Color
+	// Density of Direction, per solid angle, zero for samples to ignore. It's
+	// 1 for Delta samples.
+	PDF float64
+	// Delta samples come from lights rays can't hit, e.g. point lights, which
+	// are only found by sampling them.
+	Delta bool
+}
+
+// Emitter is implemented by materials emitting light. Shapes made of emissive
+// materials are area lights, see CollectLights.
+type Emitter interface {
+	Emissive() bool
+}
+
+func emissive(material Material) bool {
+	e, ok := material.(Emitter)
+	return ok && e.Emissive()
+}
+
+// CollectLights returns the area lights of the emissive spheres, triangles and
+// triangle meshes of h, looking into BVH nodes and hitter lists. Planes are
+// infinite and can't be sampled, so they are never lights.
+func CollectLights(h Hitter) []Light {
+	var lights []Light
+
+	var collect func(h Hitter)
+	collect = func(h Hitter) {
+		switch h := h.(type) {
+		case *BVHNode:
+			if h.Left != nil {
+				collect(h.Left)
+			}
+			// leaves hold their hitter in both Left and Right
+			if _, ok := h.Right.(*BVHNode); ok {
+				collect(h.Right)
+			}
+			collect(h.Unbounded)
+		case HitterList:
+			for i := range h {
+				collect(h[i])
+			}
+		case *Sphere:
+			if emissive(h.Material) {
+				lights = append(lights, sphereLight{sphere: *h})
+			}
+		case Sphere:
+			if emissive(h.Material) {
+				lights = append(lights, sphereLight{sphere: h})
+			}
+		case *Triangle:
+			if emissive(h.Material) {
+				lights = append(lights, newTriangleLight(h))
+			}
+		case Triangle:
+			if emissive(h.Material) {
+				lights = append(lights, newTriangleLight(&h))
+			}
+		case *TriangleMesh:
+			if emissive(h.Material) && len(h.Indices) > 0 {
+				lights = append(lights, newMeshLight(h))
+			}
+		}
+	}
+	collect(h)
+
+	return lights
+}
+
+// areaLightRay is the ray, of unit direction, from p towards a point sampled
+// on an area light.
+func areaLightRay(p, point Point3) (Ray, bool) {
+	toLight := Vec3(point).Sub(Vec3(p))
+	distance := toLight.Len()
+	if distance == 0 {
+		return Ray{}, false
+	}
+	return Ray{Origin: p, Direction: toLight.MulFloat(1 / distance)}, true
+}
+
+// areaLightSample is the sample of an area light whose shape, of the given
+// geometric normal at the sampled point, is hit by the areaLightRay towards
+// that point at hr. pdfArea is the density of the point per unit area.
+// Shapes are hit by the caller, as Hitter values would escape to the heap.
+func areaLightSample(ray Ray, hr HitRecord, normal Vec3, pdfArea float64) LightSample {
+	if !hr.Hit {
+		return LightSample{}
+	}
+
+	cosine := math.Abs(normal.Dot(ray.Direction))
+	if cosine == 0 {
+		return LightSample{}
+	}
+
+	return LightSample{
+		Direction: ray.Direction,
+		Distance:  hr.T,
+		Radiance:  hr.Material.Emitted(ray, hr),
+		PDF:       pdfArea * hr.T * hr.T / cosine,
+	}
+}
+
+// sameHit is whether hit, of a light's shape, is the hit hr of the scene.
+func sameHit(hit, hr HitRecord) bool {
+	return hit.Hit && math.Abs(hit.T-hr.T) <= 1e-6*math.Max(1, hr.T)
+}
+
+type sphereLight struct {
+	sphere Sphere
+}
+
+// SampleLi samples the cone of directions towards the sphere when p is
+// outside of it, and the sphere's area otherwise.
+func (l sphereLight) SampleLi(p Point3, u1, u2 float64) LightSample {
+	s := l.sphere
+
+	toCenter := Vec3(s.Center).Sub(Vec3(p))
+	distanceSq := toCenter.LenSq()
+	if distanceSq <= s.Radius*s.Radius {
+		point := Point3(Vec3(s.Center).Add(UniformSampleSphere(u1, u2).MulFloat(s.Radius)))
+		normal := Vec3(point).Sub(Vec3(s.Center)).MulFloat(1 / s.Radius)
+		ray, ok := areaLightRay(p, point)
+		if !ok {
+			return LightSample{}
+		}
+		return areaLightSample(ray, s.Hit(ray), normal, 1/(4*math.Pi*s.Radius*s.Radius))
+	}
+
+	sinThetaMaxSq := s.Radius * s.Radius / distanceSq
+	cosThetaMax := math.Sqrt(math.Max(0, 1-sinThetaMaxSq))
+	oneMinusCosThetaMax := sinThetaMaxSq / (1 + cosThetaMax)
+
+	cosTheta := 1 - u1*oneMinusCosThetaMax
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * u2
+
+	w := toCenter.Unit()
+	axis := planeAxis(w)
+	direction := axis[0].MulFloat(sinTheta * math.Cos(phi)).Add(axis[1].MulFloat(sinTheta * math.Sin(phi))).Add(w.MulFloat(cosTheta))
+
+	ray := Ray{Origin: p, Direction: direction}
+	hr := s.Hit(ray)
+	if !hr.Hit {
+		// grazing the sphere
+		return LightSample{}
+	}
+
+	return LightSample{
+		Direction: direction,
+		Distance:  hr.T,
+		Radiance:  hr.Material.Emitted(ray, hr),
+		PDF:       1 / (2 * math.Pi * oneMinusCosThetaMax),
+	}
+}
+
+func (l sphereLight) PDF(ray Ray, hr HitRecord) float64 {
+	s := l.sphere
+
+	hit := s.Hit(ray)
+	if !sameHit(hit, hr) {
+		return 0
+	}
+
+	distanceSq := Vec3(s.Center).Sub(Vec3(ray.Origin)).LenSq()
+	if distanceSq <= s.Radius*s.Radius {
+		return areaLightPDF(ray, hit, hit.GeometricNormal, 4*math.Pi*s.Radius*s.Radius)
+	}
+
+	sinThetaMaxSq := s.Radius * s.Radius / distanceSq
+	cosThetaMax := math.Sqrt(math.Max(0, 1-sinThetaMaxSq))
+	return 1 / (2 * math.Pi * sinThetaMaxSq / (1 + cosThetaMax))
+}
+
+// areaLightPDF converts the density of uniformly sampling a point of the given
+// area, hit by ray, to a density per solid angle at ray's origin.
+func areaLightPDF(ray Ray, hit HitRecord, normal Vec3, area float64) float64 {
+	direction := ray.Direction.Unit()
+	cosine := math.Abs(normal.Dot(direction))
+	if cosine == 0 {
+		return 0
+	}
+	distance := hit.T * ray.Direction.Len()
+	return distance * distance / (cosine * area)
+}
+
+type triangleLight struct {
+	triangle *Triangle
+	normal   Vec3
+	area     float64
+}
+
+func newTriangleLight(tr *Triangle) triangleLight {
+	normal, area := triangleNormalArea(tr.Vertices)
+	return triangleLight{triangle: tr, normal: normal, area: area}
+}
+
+// triangleNormalArea returns the unit geometric normal and the area of a
+// triangle.
+func triangleNormalArea(vertices [3]Point3) (Vec3, float64) {
+	cross := Vec3(vertices[1]).Sub(Vec3(vertices[0])).Cross(Vec3(vertices[2]).Sub(Vec3(vertices[0])))
+	length := cross.Len()
+	if length == 0 {
+		return Vec3{}, 0
+	}
+	return cross.MulFloat(1 / length), length / 2
+}
+
+// uniformSampleTriangle returns a uniformly distributed point of a triangle.
+func uniformSampleTriangle(vertices [3]Point3, u1, u2 float64) Point3 {
+	su1 := math.Sqrt(u1)
+	b1, b2 := 1-su1, u2*su1
+	b0 := 1 - b1 - b2
+	return Point3(Vec3(vertices[0]).MulFloat(b0).Add(Vec3(vertices[1]).MulFloat(b1)).Add(Vec3(vertices[2]).MulFloat(b2)))
+}
+
+func (l triangleLight) SampleLi(p Point3, u1, u2 float64) LightSample {
+	if l.area == 0 {
+		return LightSample{}
+	}
+	point := uniformSampleTriangle(l.triangle.Vertices, u1, u2)
+	ray, ok := areaLightRay(p, point)
+	if !ok {
+		return LightSample{}
+	}
+	return areaLightSample(ray, l.triangle.Hit(ray), l.normal, 1/l.area)
+}
+
+func (l triangleLight) PDF(ray Ray, hr HitRecord) float64 {
+	hit := l.triangle.Hit(ray)
+	if l.area == 0 || !sameHit(hit, hr) {
+		return 0
+	}
+	return areaLightPDF(ray, hit, l.normal, l.area)
+}
+
+// meshLight samples the triangles of a mesh in proportion to their area.
+type meshLight struct {
+	mesh *TriangleMesh
+	// cumulative area of the triangles, triangle after triangle
+	cdf  []float64
+	area float64
+}
+
+func newMeshLight(mesh *TriangleMesh) meshLight {
+	l := meshLight{mesh: mesh, cdf: make([]float64, 0, len(mesh.Indices)/3)}
+	for i := 0; i < len(mesh.Indices); i += 3 {
+		_, area := triangleNormalArea(meshTriangle{mesh: mesh, index: i}.vertices())
+		l.area += area
+		l.cdf = append(l.cdf, l.area)
+	}
+	return l
+}
+
+func (l meshLight) SampleLi(p Point3, u1, u2 float64) LightSample {
+	if l.area == 0 {
+		return LightSample{}
+	}
+
+	// pick a triangle with u1, then reuse what's left of it
+	target := u1 * l.area
+	i := sort.SearchFloat64s(l.cdf, target)
+	if i == len(l.cdf) {
+		i--
+	}
+	low := 0.0
+	if i > 0 {
+		low = l.cdf[i-1]
+	}
+	u1 = Clamp((target-low)/(l.cdf[i]-low), 0, oneMinusEpsilon)
+
+	triangle := meshTriangle{mesh: l.mesh, index: 3 * i}
+	vertices := triangle.vertices()
+	normal, _ := triangleNormalArea(vertices)
+	point := uniformSampleTriangle(vertices, u1, u2)
+
+	ray, ok := areaLightRay(p, point)
+	if !ok {
+		return LightSample{}
+	}
+	return areaLightSample(ray, triangle.Hit(ray), normal, 1/l.area)
+}
+
+func (l meshLight) PDF(ray Ray, hr HitRecord) float64 {
+	if l.area == 0 {
+		return 0
+	}
+	hit := l.mesh.Hit(ray)
+	if !sameHit(hit, hr) {
+		return 0
+	}
+	return areaLightPDF(ray, hit, hit.GeometricNormal, l.area)
+}
+
+// scaledColor is color times intensity, 1 when zero.
+func scaledColor(color Color, intensity float64) Color {
+	if intensity == 0 {
+		return color
+	}
+	return Color(Vec3(color).MulFloat(intensity))
+}
+
+// samplePointLight samples the light arriving at p from a light of the given
+// intensity at position.
+func samplePointLight(p, position Point3, intensity Color) LightSample {
+	toLight := Vec3(position).Sub(Vec3(p))
+	distanceSq := toLight.LenSq()
+	if distanceSq == 0 {
+		return LightSample{}
+	}
+	distance := math.Sqrt(distanceSq)
+
+	return LightSample{
+		Direction: toLight.MulFloat(1 / distance),
+		Distance:  distance,
+		Radiance:  Color(Vec3(intensity).MulFloat(1 / distanceSq)),
+		PDF:       1,
+		Delta:     true,
+	}
+}
+
+// PointLight emits light evenly in every direction from a point.
+type PointLight struct {
+	Position Point3 `json:"position"`
+	Color    Color  `json:"color"`
+	// Scale of Color, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+}
+
+func (l PointLight) SampleLi(p Point3, _, _ float64) LightSample {
+	return samplePointLight(p, l.Position, scaledColor(l.Color, l.Intensity))
+}
+
+func (l PointLight) PDF(Ray, HitRecord) float64 {
+	return 0
+}
+
+// SpotLight is a point light shining in a cone around Direction. Its light
+// falls off smoothly from FalloffAngle to the edge of the cone, at ConeAngle.
+type SpotLight struct {
+	Position  Point3 `json:"position"`
+	Direction Vec3   `json:"direction"`
+	Color     Color  `json:"color"`
+	// Scale of Color, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+	// Angles from Direction, in degrees. A FalloffAngle of ConeAngle or more
+	// makes a hard edged spot, as does a zero one.
+	ConeAngle    float64 `json:"cone_angle"`
+	FalloffAngle float64 `json:"falloff_angle,omitempty"`
+}
+
+func (l *SpotLight) finishDecode() error {
+	if l.Direction.Zero() {
+		return errors.New("direction can't be zero")
+	}
+	if l.ConeAngle <= 0 || l.ConeAngle > 180 {
+		return errors.New("cone angle must be in (0, 180]")
+	}
+	if l.FalloffAngle < 0 {
+		return errors.New("falloff angle can't be negative")
+	}
+	return nil
+}
+
+func (l SpotLight) SampleLi(p Point3, _, _ float64) LightSample {
+	ls := samplePointLight(p, l.Position, scaledColor(l.Color, l.Intensity))
+	if ls.PDF == 0 {
+		return ls
+	}
+
+	falloff := l.falloff(ls.Direction.Neg().Dot(l.Direction.Unit()))
+	if falloff == 0 {
+		return LightSample{}
+	}
+	ls.Radiance = Color(Vec3(ls.Radiance).MulFloat(falloff))
+
+	return ls
+}
+
+// falloff is the fraction of the spot's light emitted at an angle of the
+// given cosine from its direction.
+func (l SpotLight) falloff(cosine float64) float64 {
+	cosCone := math.Cos(DegreesToRadians(l.ConeAngle))
+	cosFalloff := math.Cos(DegreesToRadians(l.FalloffAngle))
+
+	if cosine < cosCone {
+		return 0
+	}
+	if l.FalloffAngle == 0 || cosFalloff <= cosCone || cosine >= cosFalloff {
+		return 1
+	}
+
+	// smoothstep
+	t := (cosine - cosCone) / (cosFalloff - cosCone)
+	return t * t * (3 - 2*t)
+}
+
+func (l SpotLight) PDF(Ray, HitRecord) float64 {
+	return 0
+}
+
+// DirectionalLight is a light infinitely far away, e.g. the sun, lighting the
+// scene along Direction.
+type DirectionalLight struct {
+	Direction Vec3  `json:"direction"`
+	Color     Color `json:"color"`
+	// Scale of Color, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+}
+
+func (l *DirectionalLight) finishDecode() error {
+	if l.Direction.Zero() {
+		return errors.New("direction can't be zero")
+	}
+	return nil
+}
+
+func (l DirectionalLight) SampleLi(Point3, float64, float64) LightSample {
+	return LightSample{
+		Direction: l.Direction.Unit().Neg(),
+		Distance:  math.Inf(+1),
+		Radiance:  scaledColor(l.Color, l.Intensity),
+		PDF:       1,
+		Delta:     true,
+	}
+}
+
+func (l DirectionalLight) PDF(Ray, HitRecord) float64 {
+	return 0
+}
+
+// SphereLight is a sphere emitting light from its surface. It's a Hitter as
+// well, so rays see it like any other shape.
+type SphereLight struct {
+	Center Point3  `json:"center"`
+	Radius float64 `json:"radius"`
+	Color  Color   `json:"color"`
+	// Scale of Color, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+
+	sphere Sphere
+}
+
+func NewSphereLight(center Point3, radius float64, color Color, intensity float64) *SphereLight {
+	l := &SphereLight{Center: center, Radius: radius, Color: color, Intensity: intensity}
+	l.sphere = *NewSphere(center, radius, DiffuseLight{Emit: color, Intensity: intensity})
+	return l
+}
+
+func (l *SphereLight) finishDecode() error {
+	if l.Radius <= 0 {
+		return errors.New("radius must be positive")
+	}
+	*l = *NewSphereLight(l.Center, l.Radius, l.Color, l.Intensity)
+	return nil
+}
+
+func (l *SphereLight) Hit(r Ray) HitRecord {
+	return l.sphere.Hit(r)
+}
+
+func (l *SphereLight) BoundingBox() AABB {
+	return l.sphere.BoundingBox()
+}
+
+func (l *SphereLight) SampleLi(p Point3, u1, u2 float64) LightSample {
+	return sphereLight{sphere: l.sphere}.SampleLi(p, u1, u2)
+}
+
+func (l *SphereLight) PDF(ray Ray, hr HitRecord) float64 {
+	return sphereLight{sphere: l.sphere}.PDF(ray, hr)
+}
+
+// RectLight is a parallelogram, with a corner at Corner and the adjacent ones
+// at Corner + Edge1 and Corner + Edge2, emitting light from its front face:
+// the side Edge1 × Edge2 points to. It's a Hitter as well, so rays see it
+// like any other shape.
+type RectLight struct {
+	Corner Point3 `json:"corner"`
+	Edge1  Vec3   `json:"edge1"`
+	Edge2  Vec3   `json:"edge2"`
+	Color  Color  `json:"color"`
+	// Scale of Color, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+	// Emit from the back face as well as the front one
+	TwoSided bool `json:"two_sided,omitempty"`
+
+	// unit
+	normal Vec3
+	// Edge1 × Edge2 over its squared length, giving the coordinates of points
+	// along the edges
+	w        Vec3
+	area     float64
+	material Material
+	box      AABB
+}
+
+func NewRectLight(corner Point3, edge1, edge2 Vec3, color Color, intensity float64, twoSided bool) *RectLight {
+	l := &RectLight{Corner: corner, Edge1: edge1, Edge2: edge2, Color: color, Intensity: intensity, TwoSided: twoSided}
+
+	n := edge1.Cross(edge2)
+	l.area = n.Len()
+	if l.area > 0 {
+		l.normal = n.MulFloat(1 / l.area)
+		l.w = n.MulFloat(1 / n.LenSq())
+	}
+	l.material = DiffuseLight{Emit: color, Intensity: intensity, TwoSided: twoSided}
+
+	c := Vec3(corner)
+	l.box = AABB{corner, corner}.
+		Surrounding(AABB{Point3(c.Add(edge1)), Point3(c.Add(edge1))}).
+		Surrounding(AABB{Point3(c.Add(edge2)), Point3(c.Add(edge2))}).
+		Surrounding(AABB{Point3(c.Add(edge1).Add(edge2)), Point3(c.Add(edge1).Add(edge2))}).
+		Pad(0.0001)
+
+	return l
+}
+
+func (l *RectLight) finishDecode() error {
+	if l.Edge1.Cross(l.Edge2).Zero() {
+		return errors.New("edges must not be parallel or zero")
+	}
+	*l = *NewRectLight(l.Corner, l.Edge1, l.Edge2, l.Color, l.Intensity, l.TwoSided)
+	return nil
+}
+
+func (l *RectLight) Hit(ray Ray) HitRecord {
+	denom := ray.Direction.Dot(l.normal)
+	if math.Abs(denom) < 1e-12 {
+		return HitRecord{}
+	}
+
+	t := Vec3(l.Corner).Sub(Vec3(ray.Origin)).Dot(l.normal) / denom
+	if t < 0.0001 {
+		return HitRecord{}
+	}
+
+	p := ray.At(t)
+	q := Vec3(p).Sub(Vec3(l.Corner))
+	alpha := l.w.Dot(q.Cross(l.Edge2))
+	beta := l.w.Dot(l.Edge1.Cross(q))
+	if alpha < 0 || alpha > 1 || beta < 0 || beta > 1 {
+		return HitRecord{}
+	}
+
+	hr := HitRecord{
+		Hit:       true,
+		FrontFace: denom < 0,
+		T:         t,
+		P:         p,
+		Normal:    l.normal,
+		U:         alpha,
+		V:         beta,
+		Material:  l.material,
+	}
+	if !hr.FrontFace {
+		hr.Normal = hr.Normal.Neg()
+	}
+	hr.GeometricNormal = hr.Normal
+	hr.setTangents(l.Edge1, l.Edge2)
+
+	return hr
+}
+
+func (l *RectLight) BoundingBox() AABB {
+	return l.box
+}
+
+func (l *RectLight) SampleLi(p Point3, u1, u2 float64) LightSample {
+	if l.area == 0 {
+		return LightSample{}
+	}
+	point := Point3(Vec3(l.Corner).Add(l.Edge1.MulFloat(u1)).Add(l.Edge2.MulFloat(u2)))
+	ray, ok := areaLightRay(p, point)
+	if !ok {
+		return LightSample{}
+	}
+	return areaLightSample(ray, l.Hit(ray), l.normal, 1/l.area)
+}
+
+func (l *RectLight) PDF(ray Ray, hr HitRecord) float64 {
+	hit := l.Hit(ray)
+	if l.area == 0 || !sameHit(hit, hr) {
+		return 0
+	}
+	return areaLightPDF(ray, hit, l.normal, l.area)
+}
@@ -0,0 +1,201 @@
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+)
+
+func testQuad(a, b, c, d Point3, m Material) Hitter {
+	mesh, err := NewTriangleMesh([]Point3{a, b, c, d}, nil, nil, []int{0, 1, 2, 0, 2, 3}, m)
+	if err != nil {
+		panic(err)
+	}
+	return mesh
+}
+
+var testCornellCamera = Camera{AspectRatio: 1, VFoV: 40, LookFrom: Point3{278, 278, -800}, LookAt: Point3{278, 278, 0}, VUp: Vec3{0, 1, 0}}
+
+// testCornellBox is a Cornell box lit by an area light in its ceiling, with
+// a glass and a metal sphere.
+func testCornellBox() HitterList {
+	red := Lambertian{Albedo: Color{.65, .05, .05}}
+	white := Lambertian{Albedo: Color{.73, .73, .73}}
+	green := Lambertian{Albedo: Color{.12, .45, .15}}
+	return HitterList{
+		testQuad(Point3{555, 0, 0}, Point3{555, 555, 0}, Point3{555, 555, 555}, Point3{555, 0, 555}, green),
+		testQuad(Point3{0, 0, 0}, Point3{0, 555, 0}, Point3{0, 555, 555}, Point3{0, 0, 555}, red),
+		testQuad(Point3{213, 554, 227}, Point3{343, 554, 227}, Point3{343, 554, 332}, Point3{213, 554, 332}, DiffuseLight{Emit: Color{15, 15, 15}}),
+		testQuad(Point3{0, 0, 0}, Point3{555, 0, 0}, Point3{555, 0, 555}, Point3{0, 0, 555}, white),
+		testQuad(Point3{0, 555, 0}, Point3{555, 555, 0}, Point3{555, 555, 555}, Point3{0, 555, 555}, white),
+		testQuad(Point3{0, 0, 555}, Point3{555, 0, 555}, Point3{555, 555, 555}, Point3{0, 555, 555}, white),
+		NewSphere(Point3{190, 90, 190}, 90, Dielectric{RefractiveIndex: 1.5}),
+		NewSphere(Point3{380, 120, 380}, 120, Metal{Albedo: Color{.8, .85, .88}, Fuzz: 0.05}),
+	}
+}
+
+// meanLuminance is the mean luminance of a render of the Cornell box.
+func meanLuminance(t *testing.T, integrator Integrator, spp int) float64 {
+	t.Helper()
+	bvh, err := NewBVHNode(testCornellBox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera := testCornellCamera
+	settings := RenderSettings{
+		Frame:           NewFrame(32, 32, false),
+		Camera:          &camera,
+		Hitter:          bvh,
+		SamplesPerPixel: spp,
+		MaxDepth:        10,
+		Integrator:      integrator,
+		AggColorFunc:    AvgSamples,
+		Sampler:         SamplerSobol,
+		Seed:            1,
+	}
+	if _, err := testRenderer(t, 1).RenderContext(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := 0.0
+	for row := 0; row < 32; row++ {
+		for col := 0; col < 32; col++ {
+			sum += settings.Frame.Get(row, col).Luminance()
+		}
+	}
+	return sum / (32 * 32)
+}
+
+func TestMISIntegratorMatchesPath(t *testing.T) {
+	if testing.Short() {
+		t.Skip("renders the Cornell box twice")
+	}
+
+	mis := meanLuminance(t, &MISIntegrator{}, 16)
+	path := meanLuminance(t, &PathIntegrator{}, 64)
+	if !approxEqual(mis, path, 0.02*path) {
+		t.Errorf("mean luminance %v with MIS, %v with path tracing", mis, path)
+	}
+}
+
+func TestCollectLights(t *testing.T) {
+	l := testCornellBox()
+	l = append(l, NewSphere(Point3{0, 0, 0}, 1, DiffuseLight{Emit: Color{1, 1, 1}}), NewPlane(Point3{}, Vec3{0, 1, 0}, DiffuseLight{Emit: Color{1, 1, 1}}))
+	bvh, err := NewBVHNode(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the ceiling light and the sphere, the plane can't be sampled
+	if lights := CollectLights(bvh); len(lights) != 2 {
+		t.Errorf("collected %d lights, want 2", len(lights))
+	}
+}
+
+func TestSpotLightFalloff(t *testing.T) {
+	// halfway from 20 to 30 degrees in cosine, where smoothstep is 0.5
+	halfway := math.Acos((math.Cos(DegreesToRadians(20))+math.Cos(DegreesToRadians(30)))/2) * 180 / math.Pi
+
+	tests := []struct {
+		name          string
+		cone, falloff float64
+		angle         float64
+		want          float64
+	}{
+		{"hard edge by default, inside", 30, 0, 29, 1},
+		{"hard edge by default, outside", 30, 0, 31, 0},
+		{"inside the falloff angle", 30, 20, 10, 1},
+		{"halfway through the falloff", 30, 20, halfway, 0.5},
+		{"outside the cone", 30, 20, 35, 0},
+		{"falloff past the cone", 30, 40, 29, 1},
+	}
+
+	for _, test := range tests {
+		l := SpotLight{Direction: Vec3{0, -1, 0}, ConeAngle: test.cone, FalloffAngle: test.falloff}
+		got := l.falloff(math.Cos(DegreesToRadians(test.angle)))
+		if !approxEqual(got, test.want, 1e-9) {
+			t.Errorf("%s: falloff %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPointLightSampleLi(t *testing.T) {
+	l := PointLight{Position: Point3{0, 5, 0}, Color: Color{1, 0.5, 1}, Intensity: 10}
+
+	ls := l.SampleLi(Point3{}, 0, 0)
+	if !approxVec3(ls.Direction, Vec3{0, 1, 0}, testEpsilon) || ls.Distance != 5 || !ls.Delta || ls.PDF != 1 {
+		t.Errorf("sample %+v, want a delta sample straight up at 5", ls)
+	}
+	// inverse square falloff
+	if !approxVec3(Vec3(ls.Radiance), Vec3{0.4, 0.2, 0.4}, testEpsilon) {
+		t.Errorf("radiance %v, want 10/25 of the color", ls.Radiance)
+	}
+}
+
+func TestAreaLightPDF(t *testing.T) {
+	lights := []interface {
+		Light
+		Hitter
+	}{
+		NewRectLight(Point3{-1, 2, -1}, Vec3{0, 0, 2}, Vec3{2, 0, 0}, Color{1, 1, 1}, 1, false),
+		NewSphereLight(Point3{1, 3, 0}, 0.5, Color{1, 1, 1}, 1),
+	}
+
+	sampler := NewSobolSampler(1)
+	for _, l := range lights {
+		for i := 0; i < 64; i++ {
+			sampler.StartSample(0, 0, i)
+			u1, u2 := sampler.Get2D()
+			ls := l.SampleLi(Point3{}, u1, u2)
+			if ls.PDF == 0 {
+				t.Fatalf("%T: sample %d has no density", l, i)
+			}
+
+			ray := Ray{Origin: Point3{}, Direction: ls.Direction}
+			hr := l.Hit(ray)
+			if !hr.Hit || !approxEqual(hr.T, ls.Distance, 1e-6) {
+				t.Fatalf("%T: sample %d at %v isn't on the light", l, i, ls.Distance)
+			}
+			if pdf := l.PDF(ray, hr); !approxEqual(pdf, ls.PDF, 1e-6*ls.PDF) {
+				t.Errorf("%T: sample %d has density %v, PDF says %v", l, i, ls.PDF, pdf)
+			}
+		}
+	}
+}
+
+func TestSceneLightsRoundTrip(t *testing.T) {
+	scene := &Scene{
+		Camera: Camera{AspectRatio: 1, VFoV: 90, LookFrom: Point3{0, 0, 1}, VUp: Vec3{0, 1, 0}},
+		HitterList: HitterList{
+			NewSphere(Point3{0, 0, -1}, 0.5, Lambertian{Albedo: Color{0.5, 0.5, 0.5}}),
+		},
+		Lights: []Light{
+			PointLight{Position: Point3{0, 5, 0}, Color: Color{1, 1, 1}, Intensity: 10},
+			SpotLight{Position: Point3{0, 5, 0}, Direction: Vec3{0, -1, 0}, Color: Color{1, 1, 1}, ConeAngle: 30, FalloffAngle: 20},
+			DirectionalLight{Direction: Vec3{0, -1, 0}, Color: Color{1, 1, 1}},
+			NewRectLight(Point3{-1, 2, -1}, Vec3{0, 0, 2}, Vec3{2, 0, 0}, Color{4, 4, 4}, 1, false),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJSONScene(&buf, scene); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeJSONScene(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Lights) != 4 || decoded.Lights[1] != scene.Lights[1] {
+		t.Fatalf("decoded lights %#v", decoded.Lights)
+	}
+	// the rect light is part of the scene's geometry
+	if l := decoded.Hitters(); len(l) != 2 || !l.Hit(Ray{Origin: Point3{0, 0, 0}, Direction: Vec3{0, 1, 0}}).Hit {
+		t.Errorf("decoded scene has %d hitters, want the sphere and the rect light", len(l))
+	}
+
+	if _, err := DecodeJSONScene(bytes.NewReader([]byte(`{"version": 1, "camera": {"vfov": 90}, "lights": [{"type": "spot", "direction": [0, -1, 0], "cone_angle": 30, "falloff_angle": -1}]}`))); err == nil {
+		t.Error("no error for a negative falloff angle")
+	}
+}
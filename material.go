@@ -1,27 +1,47 @@
 package tracer
 
 import (
+	"errors"
 	"math"
-
-	"lukechampine.com/frand"
 )
 
+// Material is how a surface scatters and emits light. Scatter samples the
+// direction light scattered towards the ray's origin comes from, Eval and PDF
+// evaluate any given direction wi for integrators sampling directions
+// themselves, e.g. towards lights.
 type Material interface {
-	Scatter(Ray, HitRecord) ScatterRecord
+	Scatter(Ray, HitRecord, Sampler) ScatterRecord
+	// Eval is the BSDF for light coming from wi and scattered towards the
+	// ray's origin, times the cosine of wi with the normal. It's zero for
+	// specular materials.
+	Eval(ray Ray, hr HitRecord, wi Vec3) Color
+	// PDF is the density, per solid angle, with which Scatter samples wi. It's
+	// zero for specular materials.
+	PDF(ray Ray, hr HitRecord, wi Vec3) float64
+	// Emitted is the light emitted by the material towards the ray's origin.
+	Emitted(Ray, HitRecord) Color
 }
 
 type ScatterRecord struct {
-	Scatter     bool
-	Ray         Ray
+	Scatter bool
+	Ray     Ray
+	// Attenuation is Eval over PDF of the scattered direction, or the
+	// reflected or transmitted fraction of light for specular scattering.
 	Attenuation Color
+	PDF         float64
+	// Specular scattering picks directions from a distribution that Eval and
+	// PDF can't express, e.g. perfect mirrors.
+	Specular bool
 }
 
 type Lambertian struct {
-	Albedo Color
+	Albedo Color `json:"albedo"`
+	// AlbedoTexture, when set, is the albedo instead of Albedo
+	AlbedoTexture Texture `json:"albedo_texture,omitempty"`
 }
 
-func (l Lambertian) Scatter(ray Ray, hr HitRecord) ScatterRecord {
-	scatterDirection := hr.Normal.Add(RandomUnitVector())
+func (l Lambertian) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	scatterDirection := hr.Normal.Add(UniformSampleSphere(sampler.Get2D()))
 
 	// Catch degenerate scatter direction
 	if scatterDirection.NearZero() {
@@ -31,34 +51,124 @@ func (l Lambertian) Scatter(ray Ray, hr HitRecord) ScatterRecord {
 	return ScatterRecord{
 		Scatter:     true,
 		Ray:         Ray{Origin: hr.P, Direction: scatterDirection},
-		Attenuation: l.Albedo,
+		Attenuation: textureColor(l.AlbedoTexture, l.Albedo, hr),
+		PDF:         l.PDF(ray, hr, scatterDirection),
 	}
 }
 
+func (l Lambertian) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	return Color(Vec3(textureColor(l.AlbedoTexture, l.Albedo, hr)).MulFloat(l.PDF(ray, hr, wi)))
+}
+
+// PDF is the cosine distribution of directions around the normal.
+func (l Lambertian) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	cosine := wi.Unit().Dot(hr.Normal)
+	if cosine <= 0 {
+		return 0
+	}
+	return cosine / math.Pi
+}
+
+func (l Lambertian) Emitted(Ray, HitRecord) Color {
+	return Color{}
+}
+
+// Metal reflects light around the mirror direction, fuzzed by a random point
+// in a sphere. See Conductor for physically based metals.
 type Metal struct {
-	Albedo Color
-	Fuzz   float64
+	Albedo Color `json:"albedo"`
+	// AlbedoTexture, when set, is the albedo instead of Albedo
+	AlbedoTexture Texture `json:"albedo_texture,omitempty"`
+	Fuzz          float64 `json:"fuzz"`
 }
 
-func (m Metal) Scatter(ray Ray, hr HitRecord) ScatterRecord {
-	scatterDirection := reflect(ray.Direction.Unit(), hr.Normal).Add(RandomInUnitSphere().MulFloat(m.Fuzz))
+func (m Metal) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	u1, u2 := sampler.Get2D()
+	fuzz := UniformSampleBall(u1, u2, sampler.Get1D()).MulFloat(m.Fuzz)
+	scatterDirection := reflectVector(ray.Direction.Unit(), hr.Normal).Add(fuzz)
 
 	return ScatterRecord{
 		Scatter:     true,
 		Ray:         Ray{Origin: hr.P, Direction: scatterDirection},
-		Attenuation: m.Albedo,
+		Attenuation: textureColor(m.AlbedoTexture, m.Albedo, hr),
+		Specular:    true,
 	}
 }
 
-func reflect(vector, normal Vec3) Vec3 {
+// Eval is zero, fuzzy reflections are taken as specular too.
+func (m Metal) Eval(Ray, HitRecord, Vec3) Color {
+	return Color{}
+}
+
+func (m Metal) PDF(Ray, HitRecord, Vec3) float64 {
+	return 0
+}
+
+func (m Metal) Emitted(Ray, HitRecord) Color {
+	return Color{}
+}
+
+func reflectVector(vector, normal Vec3) Vec3 {
 	return vector.Sub(normal.MulFloat(2 * vector.Dot(normal)))
 }
 
+// Dielectric reflects and refracts light, e.g. glass or water. Rough
+// dielectrics do so off GGX microfacets, see Conductor.
+//
+// Dispersive dielectrics refract every wavelength differently: paths reaching
+// them pick a wavelength (see Ray), weighted by its color. They're sampled
+// like specular materials, their Eval and PDF are zero.
 type Dielectric struct {
-	RefractiveIndex float64
+	// RefractiveIndex is, for dispersive dielectrics, the index at the
+	// Fraunhofer d line (587.6 nm)
+	RefractiveIndex float64 `json:"refractive_index"`
+	// Roughness from 0, perfectly smooth, to 1
+	Roughness float64 `json:"roughness,omitempty"`
+	// Anisotropy stretches reflections and refractions along the tangent of
+	// hits, from 0 to 1
+	Anisotropy float64 `json:"anisotropy,omitempty"`
+	// Absorption coefficient of every channel, per unit of distance travelled
+	// inside (the Beer–Lambert law). It's applied to rays hitting back faces
+	// of the dielectric, the medium isn't tracked: surfaces must be closed,
+	// with their normals facing out and nothing inside.
+	Absorption Color `json:"absorption,omitempty"`
+	// Dispersion follows Cauchy's equation, n = A + B/λ², given either the
+	// Abbe number of the dielectric (e.g. 64 for BK7 glass) or its B
+	// coefficient, in μm² (e.g. 0.0042 for BK7). Neither means no dispersion.
+	AbbeNumber float64 `json:"abbe_number,omitempty"`
+	CauchyB    float64 `json:"cauchy_b,omitempty"`
+}
+
+func (d Dielectric) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	glass, weight := d, Color{1, 1, 1}
+	wavelength := ray.Wavelength
+	if d.dispersive() {
+		if wavelength == 0 {
+			wavelength, weight = sampleWavelength(sampler.Get1D())
+		}
+		glass.RefractiveIndex = d.indexAt(wavelength)
+	}
+
+	var sr ScatterRecord
+	if smooth(d.Roughness) {
+		sr = glass.scatterSmooth(ray, hr, sampler)
+	} else {
+		sr = glass.scatterRough(ray, hr, sampler)
+	}
+	if !sr.Scatter {
+		return sr
+	}
+
+	sr.Attenuation = Color(Vec3(sr.Attenuation).MulVec3(Vec3(weight)).MulVec3(Vec3(d.transmittance(ray, hr))))
+	if d.dispersive() {
+		sr.Ray.Wavelength = wavelength
+		sr.PDF = 0
+		sr.Specular = true
+	}
+	return sr
 }
 
-func (d Dielectric) Scatter(ray Ray, hr HitRecord) ScatterRecord {
+func (d Dielectric) scatterSmooth(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
 	refractionRatio := d.RefractiveIndex
 	if hr.FrontFace {
 		refractionRatio = 1.0 / refractionRatio
@@ -72,8 +182,8 @@ func (d Dielectric) Scatter(ray Ray, hr HitRecord) ScatterRecord {
 
 	var scatterDirection Vec3
 	switch {
-	case cannotRefract || d.reflectance(cosTheta, refractionRatio) > frand.Float64():
-		scatterDirection = reflect(unitDirection, hr.Normal)
+	case cannotRefract || d.reflectance(cosTheta, refractionRatio) > sampler.Get1D():
+		scatterDirection = reflectVector(unitDirection, hr.Normal)
 	default:
 		scatterDirection = refract(ray.Direction.Unit(), hr.Normal, refractionRatio)
 	}
@@ -82,9 +192,98 @@ func (d Dielectric) Scatter(ray Ray, hr HitRecord) ScatterRecord {
 		Scatter:     true,
 		Ray:         Ray{Origin: hr.P, Direction: scatterDirection},
 		Attenuation: Color{1, 1, 1},
+		Specular:    true,
 	}
 }
 
+func (d Dielectric) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	if smooth(d.Roughness) || d.dispersive() {
+		return Color{}
+	}
+	f, _ := d.evalRough(ray, hr, wi)
+	return Color(Vec3(d.transmittance(ray, hr)).MulFloat(f))
+}
+
+func (d Dielectric) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	if smooth(d.Roughness) || d.dispersive() {
+		return 0
+	}
+	_, pdf := d.evalRough(ray, hr, wi)
+	return pdf
+}
+
+func (d Dielectric) Emitted(Ray, HitRecord) Color {
+	return Color{}
+}
+
+func (d *Dielectric) finishDecode() error {
+	if d.RefractiveIndex <= 0 {
+		return errors.New("refractive index must be positive")
+	}
+	for _, a := range d.Absorption {
+		if a < 0 {
+			return errors.New("absorption can't be negative")
+		}
+	}
+	switch {
+	case d.AbbeNumber < 0 || d.CauchyB < 0:
+		return errors.New("abbe number and cauchy b can't be negative")
+	case d.AbbeNumber > 0 && d.CauchyB > 0:
+		return errors.New("dispersion takes either an abbe number or cauchy b")
+	}
+	return validRoughness(d.Roughness, d.Anisotropy)
+}
+
+// transmittance is the fraction of light not absorbed along ray, taken to
+// travel inside the dielectric when hr is a back face.
+func (d Dielectric) transmittance(ray Ray, hr HitRecord) Color {
+	if hr.FrontFace || Vec3(d.Absorption).Zero() {
+		return Color{1, 1, 1}
+	}
+
+	distance := Vec3(hr.P).Sub(Vec3(ray.Origin)).Len()
+	var c Color
+	for i := range c {
+		c[i] = math.Exp(-d.Absorption[i] * distance)
+	}
+	return c
+}
+
+func (d Dielectric) dispersive() bool {
+	return d.AbbeNumber > 0 || d.CauchyB > 0
+}
+
+// Fraunhofer lines the Abbe number is defined with, in μm
+const (
+	fraunhoferD = 0.5876
+	fraunhoferF = 0.4861
+	fraunhoferC = 0.6563
+)
+
+// indexAt is the refractive index at wavelength, in nanometers.
+func (d Dielectric) indexAt(wavelength float64) float64 {
+	b := d.CauchyB
+	if d.AbbeNumber > 0 {
+		// n_F - n_C = (n_d - 1) / V
+		b = (d.RefractiveIndex - 1) / d.AbbeNumber /
+			(1/(fraunhoferF*fraunhoferF) - 1/(fraunhoferC*fraunhoferC))
+	}
+
+	// A keeps RefractiveIndex at the d line
+	a := d.RefractiveIndex - b/(fraunhoferD*fraunhoferD)
+	micrometers := wavelength / 1000
+	return a + b/(micrometers*micrometers)
+}
+
+// relativeIndex is the refractive index of the side of the surface opposite
+// to the ray, relative to the ray's side.
+func (d Dielectric) relativeIndex(hr HitRecord) float64 {
+	if hr.FrontFace {
+		return d.RefractiveIndex
+	}
+	return 1 / d.RefractiveIndex
+}
+
 func (d Dielectric) reflectance(cosine, refIdx float64) float64 {
 	r0 := (1.0 - refIdx) / (1.0 + refIdx)
 	r0 = r0 * r0
@@ -97,3 +296,38 @@ func refract(vector, normal Vec3, refractionRatio float64) Vec3 {
 	rOutParallel := normal.MulFloat(-math.Sqrt(math.Abs(1.0 - rOutPerp.LenSq())))
 	return rOutPerp.Add(rOutParallel)
 }
+
+// DiffuseLight emits light evenly in every direction and doesn't scatter any.
+type DiffuseLight struct {
+	Emit Color `json:"emit"`
+	// EmitTexture, when set, is emitted instead of Emit
+	EmitTexture Texture `json:"emit_texture,omitempty"`
+	// Scale of Emit, 1 when zero
+	Intensity float64 `json:"intensity,omitempty"`
+	// Emit from back faces as well as front ones
+	TwoSided bool `json:"two_sided,omitempty"`
+}
+
+func (l DiffuseLight) Scatter(Ray, HitRecord, Sampler) ScatterRecord {
+	return ScatterRecord{}
+}
+
+func (l DiffuseLight) Eval(Ray, HitRecord, Vec3) Color {
+	return Color{}
+}
+
+func (l DiffuseLight) PDF(Ray, HitRecord, Vec3) float64 {
+	return 0
+}
+
+func (l DiffuseLight) Emissive() bool {
+	return l.EmitTexture != nil || !Vec3(l.Emit).Zero()
+}
+
+func (l DiffuseLight) Emitted(ray Ray, hr HitRecord) Color {
+	if !hr.FrontFace && !l.TwoSided {
+		return Color{}
+	}
+
+	return scaledColor(textureColor(l.EmitTexture, l.Emit, hr), l.Intensity)
+}
@@ -0,0 +1,135 @@
+package tracer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDiffuseLightEmitted(t *testing.T) {
+	front := HitRecord{Hit: true, FrontFace: true}
+	back := HitRecord{Hit: true}
+
+	tests := []struct {
+		name  string
+		light DiffuseLight
+		hr    HitRecord
+		want  Color
+	}{
+		{"front", DiffuseLight{Emit: Color{1, 2, 3}}, front, Color{1, 2, 3}},
+		{"intensity", DiffuseLight{Emit: Color{1, 2, 3}, Intensity: 0.5}, front, Color{0.5, 1, 1.5}},
+		{"back", DiffuseLight{Emit: Color{1, 2, 3}}, back, Color{}},
+		{"two sided back", DiffuseLight{Emit: Color{1, 2, 3}, TwoSided: true}, back, Color{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		if got := test.light.Emitted(Ray{}, test.hr); got != test.want {
+			t.Errorf("%s: emitted %v, want %v", test.name, got, test.want)
+		}
+		if sr := test.light.Scatter(Ray{}, test.hr, nil); sr.Scatter {
+			t.Errorf("%s: light scattered", test.name)
+		}
+	}
+
+	for _, m := range []Material{Lambertian{Albedo: Color{1, 1, 1}}, Metal{Albedo: Color{1, 1, 1}}, Dielectric{RefractiveIndex: 1.5}} {
+		if got := m.Emitted(Ray{}, front); got != (Color{}) {
+			t.Errorf("%T emitted %v", m, got)
+		}
+	}
+}
+
+func TestPathIntegratorEmission(t *testing.T) {
+	scene := HitterList{
+		NewSphere(Point3{0, 0, -2}, 1, DiffuseLight{Emit: Color{4, 2, 1}}),
+		NewSphere(Point3{0, 0, 2}, 1, DiffuseLight{Emit: Color{4, 2, 1}}),
+	}
+	var p PathIntegrator
+	if err := p.Prepare(RenderScene{Hitter: scene, Background: DefaultBackground, MaxDepth: 4}); err != nil {
+		t.Fatal(err)
+	}
+	state := &RayState{Sampler: NewIndependentSampler(1)}
+	state.Sampler.StartSample(0, 0, 0)
+
+	// a light seen from outside and, from inside, a one-sided light's back
+	if got := p.Li(Ray{Origin: Point3{}, Direction: Vec3{0, 0, -1}}, state).Radiance; got != (Color{4, 2, 1}) {
+		t.Errorf("light seen as %v, want its emission", got)
+	}
+	if got := p.Li(Ray{Origin: Point3{0, 0, 2}, Direction: Vec3{0, 0, 1}}, state).Radiance; got != (Color{}) {
+		t.Errorf("light's back seen as %v, want black", got)
+	}
+}
+
+func TestDielectricAbsorption(t *testing.T) {
+	d := Dielectric{RefractiveIndex: 1, Absorption: Color{0.5, 1, 0}}
+	sphere := NewSphere(Point3{0, 0, 0}, 1, d)
+	s := NewIndependentSampler(1)
+	s.StartSample(0, 0, 0)
+
+	tests := []struct {
+		name     string
+		ray      Ray
+		distance float64
+	}{
+		// the path length, whatever the length of the direction
+		{"from the center", Ray{Origin: Point3{0, 0, 0}, Direction: Vec3{0, 0, 2}}, 1},
+		{"across", Ray{Origin: Point3{0, 0, -0.5}, Direction: Vec3{0, 0, 0.5}}, 1.5},
+		{"oblique", Ray{Origin: Point3{0, 0.6, 0}, Direction: Vec3{0, 0, 1}}, 0.8},
+		{"from outside", Ray{Origin: Point3{0, 0, 3}, Direction: Vec3{0, 0, -1}}, 0},
+	}
+
+	for _, test := range tests {
+		hr := sphere.Hit(test.ray)
+		sr := d.Scatter(test.ray, hr, s)
+		if !sr.Scatter {
+			t.Errorf("%s: didn't scatter", test.name)
+			continue
+		}
+		want := Color{math.Exp(-0.5 * test.distance), math.Exp(-test.distance), 1}
+		if !approxVec3(Vec3(sr.Attenuation), Vec3(want), 1e-9) {
+			t.Errorf("%s: attenuation %v, want %v", test.name, sr.Attenuation, want)
+		}
+	}
+}
+
+func TestDielectricDispersion(t *testing.T) {
+	for _, d := range []Dielectric{
+		{RefractiveIndex: 1.5168, CauchyB: 0.0042},
+		{RefractiveIndex: 1.5168, AbbeNumber: 64.17},
+	} {
+		if n := d.indexAt(587.6); !approxEqual(n, d.RefractiveIndex, 1e-9) {
+			t.Errorf("%+v: index %v at the d line, want %v", d, n, d.RefractiveIndex)
+		}
+
+		// n = A + B/λ², λ in μm
+		a := d.indexAt(1e9)
+		b := (d.indexAt(500) - a) * 0.5 * 0.5
+		for _, wavelength := range []float64{400, 450, 656.3, 700} {
+			micrometers := wavelength / 1000
+			if n := d.indexAt(wavelength); !approxEqual(n, a+b/(micrometers*micrometers), 1e-9) {
+				t.Errorf("%+v: index %v at %v nm, want %v", d, n, wavelength, a+b/(micrometers*micrometers))
+			}
+		}
+		if d.CauchyB > 0 && !approxEqual(b, d.CauchyB, 1e-9) {
+			t.Errorf("%+v: B %v", d, b)
+		}
+		if d.AbbeNumber > 0 {
+			// V = (n_d - 1) / (n_F - n_C)
+			if v := (d.RefractiveIndex - 1) / (d.indexAt(486.1) - d.indexAt(656.3)); !approxEqual(v, d.AbbeNumber, 1e-6) {
+				t.Errorf("%+v: Abbe number %v", d, v)
+			}
+		}
+	}
+
+	// paths pick a wavelength at dispersive dielectrics, and keep it
+	d := Dielectric{RefractiveIndex: 1.5, CauchyB: 0.01}
+	ray := Ray{Origin: Point3{0, 0, 3}, Direction: Vec3{0, 0, -1}}
+	hr := NewSphere(Point3{0, 0, 0}, 1, d).Hit(ray)
+	s := NewIndependentSampler(1)
+	s.StartSample(0, 0, 0)
+	if sr := d.Scatter(ray, hr, s); !sr.Specular || sr.Ray.Wavelength < minWavelength || sr.Ray.Wavelength > maxWavelength {
+		t.Errorf("scattered %+v, want a specular ray of a visible wavelength", sr)
+	}
+	ray.Wavelength = 450
+	if sr := d.Scatter(ray, hr, s); sr.Ray.Wavelength != 450 {
+		t.Errorf("scattered at %v nm, want the ray's 450", sr.Ray.Wavelength)
+	}
+}
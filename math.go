@@ -2,8 +2,8 @@ package tracer
 
 import (
 	"math"
-
-	"lukechampine.com/frand"
+	"sync"
+	"time"
 )
 
 type Vec3 [3]float64
@@ -112,14 +112,24 @@ func Clamp(x, min, max float64) float64 {
 	}
 }
 
+var (
+	defaultRandMu sync.Mutex
+	defaultRand   = NewRand(uint64(time.Now().UnixNano()))
+)
+
+// Deprecated: RandomVec3 isn't reproducible, use a Rand.
 func RandomVec3(min, max float64) Vec3 {
 	return Vec3{Random(min, max), Random(min, max), Random(min, max)}
 }
 
+// Deprecated: Random isn't reproducible, use a Rand.
 func Random(min, max float64) float64 {
-	return frand.Float64()*(max-min) + min
+	defaultRandMu.Lock()
+	defer defaultRandMu.Unlock()
+	return defaultRand.Range(min, max)
 }
 
+// Deprecated: RandomInUnitSphere isn't reproducible, use a Rand.
 func RandomInUnitSphere() Vec3 {
 	for {
 		p := RandomVec3(-1, 1)
@@ -130,10 +140,12 @@ func RandomInUnitSphere() Vec3 {
 	}
 }
 
+// Deprecated: RandomUnitVector isn't reproducible, use a Rand.
 func RandomUnitVector() Vec3 {
 	return RandomInUnitSphere().Unit()
 }
 
+// Deprecated: RandomInHemisphere isn't reproducible, use a Rand.
 func RandomInHemisphere(normal Vec3) Vec3 {
 	inUnitSphere := RandomInUnitSphere()
 	if inUnitSphere.Dot(normal) > 0.0 {
@@ -167,3 +179,8 @@ func ClosestVertex(box AABB, target Point3) Point3 {
 	}
 	return closest
 }
+
+// Luminance is the relative luminance of a linear RGB color.
+func (c Color) Luminance() float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
@@ -0,0 +1,468 @@
+package tracer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// ggx is the GGX (Trowbridge-Reitz) distribution of microfacet normals, with
+// roughness alphaX along the tangent and alphaY along the bitangent. Vectors
+// are in the shading frame of hits (see localFrame), z along the normal.
+type ggx struct {
+	alphaX, alphaY float64
+}
+
+// minAlpha is the roughness below which surfaces are taken as smooth, GGX
+// getting numerically unstable as its lobes get sharper.
+const minAlpha = 1e-3
+
+// newGGX maps roughness and anisotropy, both from 0 to 1, to the alphas of
+// the distribution: alpha is roughness squared, which looks perceptually
+// linear, and anisotropy stretches it along the tangent.
+func newGGX(roughness, anisotropy float64) ggx {
+	alpha := roughness * roughness
+	aspect := math.Sqrt(1 - 0.9*anisotropy)
+	return ggx{
+		alphaX: math.Max(minAlpha, alpha/aspect),
+		alphaY: math.Max(minAlpha, alpha*aspect),
+	}
+}
+
+// smooth tells whether roughness makes a perfectly smooth surface.
+func smooth(roughness float64) bool {
+	return roughness*roughness < minAlpha
+}
+
+// validRoughness checks roughness and anisotropy are within [0, 1].
+func validRoughness(roughness, anisotropy float64) error {
+	if roughness < 0 || roughness > 1 {
+		return errors.New("roughness must be between 0 and 1")
+	}
+	if anisotropy < 0 || anisotropy > 1 {
+		return errors.New("anisotropy must be between 0 and 1")
+	}
+	return nil
+}
+
+// d is the density of microfacet normals wm.
+func (g ggx) d(wm Vec3) float64 {
+	cos2 := wm[2] * wm[2]
+	if cos2 == 0 {
+		return 0
+	}
+	e := (wm[0]*wm[0]/(g.alphaX*g.alphaX) + wm[1]*wm[1]/(g.alphaY*g.alphaY)) / cos2
+	return 1 / (math.Pi * g.alphaX * g.alphaY * cos2 * cos2 * (1 + e) * (1 + e))
+}
+
+// lambda is the Smith auxiliary function: the area of microfacets hidden
+// from w per visible one.
+func (g ggx) lambda(w Vec3) float64 {
+	cos2 := w[2] * w[2]
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+	alpha2Tan2 := (w[0]*w[0]*g.alphaX*g.alphaX + w[1]*w[1]*g.alphaY*g.alphaY) / cos2
+	return (math.Sqrt(1+alpha2Tan2) - 1) / 2
+}
+
+// g1 is the fraction of microfacets visible from w.
+func (g ggx) g1(w Vec3) float64 {
+	return 1 / (1 + g.lambda(w))
+}
+
+// g is the fraction of microfacets visible from both wo and wi, height
+// correlated.
+func (g ggx) g(wo, wi Vec3) float64 {
+	return 1 / (1 + g.lambda(wo) + g.lambda(wi))
+}
+
+// visibleD is the density of microfacet normals wm visible from wo.
+func (g ggx) visibleD(wo, wm Vec3) float64 {
+	if wo[2] == 0 {
+		return 0
+	}
+	return g.g1(wo) / math.Abs(wo[2]) * g.d(wm) * math.Abs(wo.Dot(wm))
+}
+
+// sampleWm samples a microfacet normal visible from wo, with density
+// visibleD, following Heitz's "Sampling the GGX Distribution of Visible
+// Normals".
+func (g ggx) sampleWm(wo Vec3, u1, u2 float64) Vec3 {
+	// stretch wo to the hemisphere configuration
+	wh := Vec3{g.alphaX * wo[0], g.alphaY * wo[1], wo[2]}.Unit()
+	if wh[2] < 0 {
+		wh = wh.Neg()
+	}
+
+	t1 := Vec3{1, 0, 0}
+	if lenSq := wh[0]*wh[0] + wh[1]*wh[1]; lenSq > 0 {
+		t1 = Vec3{-wh[1], wh[0], 0}.MulFloat(1 / math.Sqrt(lenSq))
+	}
+	t2 := wh.Cross(t1)
+
+	// uniform point on the disk, warped to the projection of the visible
+	// hemisphere
+	r, phi := math.Sqrt(u1), 2*math.Pi*u2
+	p1, p2 := r*math.Cos(phi), r*math.Sin(phi)
+	s := (1 + wh[2]) / 2
+	p2 = (1-s)*math.Sqrt(1-p1*p1) + s*p2
+
+	nh := t1.MulFloat(p1).Add(t2.MulFloat(p2)).Add(wh.MulFloat(math.Sqrt(math.Max(0, 1-p1*p1-p2*p2))))
+
+	// and back to the ellipsoid configuration
+	return Vec3{g.alphaX * nh[0], g.alphaY * nh[1], math.Max(1e-6, nh[2])}.Unit()
+}
+
+// localFrame is the shading frame of a hit: its tangent, bitangent and
+// normal.
+type localFrame struct {
+	t, b, n Vec3
+}
+
+func newLocalFrame(hr HitRecord) localFrame {
+	if hr.Tangent.Zero() {
+		axis := planeAxis(hr.Normal)
+		t, b := shadingFrame(hr.Normal, axis[0], axis[1])
+		return localFrame{t: t, b: b, n: hr.Normal}
+	}
+	return localFrame{t: hr.Tangent, b: hr.Bitangent, n: hr.Normal}
+}
+
+func (f localFrame) toLocal(v Vec3) Vec3 {
+	return Vec3{v.Dot(f.t), v.Dot(f.b), v.Dot(f.n)}
+}
+
+func (f localFrame) fromLocal(v Vec3) Vec3 {
+	return f.t.MulFloat(v[0]).Add(f.b.MulFloat(v[1])).Add(f.n.MulFloat(v[2]))
+}
+
+// fresnelDielectric is the fraction of light reflected by a dielectric
+// interface, eta being the relative refractive index of its other side, for
+// light at cosine cosI with the normal of the side it comes from.
+func fresnelDielectric(cosI, eta float64) float64 {
+	cosI = Clamp(cosI, -1, 1)
+	if cosI < 0 {
+		eta, cosI = 1/eta, -cosI
+	}
+
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return 1
+	}
+	cosT := math.Sqrt(1 - sin2T)
+
+	rParallel := (eta*cosI - cosT) / (eta*cosI + cosT)
+	rPerpendicular := (cosI - eta*cosT) / (cosI + eta*cosT)
+	return (rParallel*rParallel + rPerpendicular*rPerpendicular) / 2
+}
+
+// fresnelConductor is the fraction of light at cosine cosI with the normal
+// reflected by a conductor of complex refractive index eta + ik.
+func fresnelConductor(cosI, eta, k float64) float64 {
+	cosI = Clamp(cosI, 0, 1)
+	n := complex(eta, k)
+
+	sin2T := complex(1-cosI*cosI, 0) / (n * n)
+	cosT := cmplx.Sqrt(1 - sin2T)
+	ci := complex(cosI, 0)
+
+	rParallel := (n*ci - cosT) / (n*ci + cosT)
+	rPerpendicular := (ci - n*cosT) / (ci + n*cosT)
+	return (normSq(rParallel) + normSq(rPerpendicular)) / 2
+}
+
+func normSq(c complex128) float64 {
+	return real(c)*real(c) + imag(c)*imag(c)
+}
+
+// refractLocal refracts w, pointing away from the surface on the side of the
+// normal n, into the other side of relative refractive index eta. It fails on
+// total internal reflection.
+func refractLocal(w, n Vec3, eta float64) (Vec3, bool) {
+	cosI := w.Dot(n)
+	if cosI < 0 {
+		eta, cosI, n = 1/eta, -cosI, n.Neg()
+	}
+
+	sin2T := math.Max(0, 1-cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return Vec3{}, false
+	}
+	cosT := math.Sqrt(1 - sin2T)
+
+	return w.Neg().MulFloat(1 / eta).Add(n.MulFloat(cosI/eta - cosT)), true
+}
+
+// ComplexIOR is the complex refractive index Eta + iK of a conductor, per
+// RGB channel. In JSON scenes it's either an object or the name of a preset,
+// see ComplexIORPresets.
+type ComplexIOR struct {
+	Eta Color `json:"eta"`
+	K   Color `json:"k"`
+}
+
+// Complex refractive indices of common metals, fitted to RGB.
+var (
+	IORAluminium = ComplexIOR{Eta: Color{1.65746, 0.880369, 0.521229}, K: Color{9.22387, 6.26952, 4.837}}
+	IORCopper    = ComplexIOR{Eta: Color{0.200438, 0.924033, 1.10221}, K: Color{3.91295, 2.45285, 2.14219}}
+	IORGold      = ComplexIOR{Eta: Color{0.143119, 0.374957, 1.44248}, K: Color{3.98316, 2.38572, 1.60322}}
+	IORSilver    = ComplexIOR{Eta: Color{0.155265, 0.116723, 0.138342}, K: Color{4.82835, 3.12225, 2.14696}}
+)
+
+// ComplexIORPresets are the presets by name.
+var ComplexIORPresets = map[string]ComplexIOR{
+	"aluminium": IORAluminium,
+	"copper":    IORCopper,
+	"gold":      IORGold,
+	"silver":    IORSilver,
+}
+
+// MarshalJSON writes presets by name.
+func (ior ComplexIOR) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(ComplexIORPresets))
+	for name := range ComplexIORPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ComplexIORPresets[name] == ior {
+			return json.Marshal(name)
+		}
+	}
+
+	type plain ComplexIOR
+	return json.Marshal(plain(ior))
+}
+
+func (ior *ComplexIOR) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		preset, ok := ComplexIORPresets[name]
+		if !ok {
+			return fmt.Errorf("unknown complex IOR preset %q", name)
+		}
+		*ior = preset
+		return nil
+	}
+
+	type plain ComplexIOR
+	return json.Unmarshal(b, (*plain)(ior))
+}
+
+// fresnel is the reflectance of the conductor at cosine cosI with the
+// normal.
+func (ior ComplexIOR) fresnel(cosI float64) Color {
+	var c Color
+	for i := range c {
+		c[i] = fresnelConductor(cosI, ior.Eta[i], ior.K[i])
+	}
+	return c
+}
+
+// Conductor is a metal, reflecting light off GGX microfacets with the
+// Fresnel reflectance of its complex refractive index.
+type Conductor struct {
+	IOR ComplexIOR `json:"ior"`
+	// Roughness from 0, a perfect mirror, to 1
+	Roughness float64 `json:"roughness"`
+	// Anisotropy stretches reflections along the tangent of hits (see
+	// HitRecord), from 0 to 1
+	Anisotropy float64 `json:"anisotropy,omitempty"`
+}
+
+func (c *Conductor) finishDecode() error {
+	if Vec3(c.IOR.Eta).Zero() && Vec3(c.IOR.K).Zero() {
+		return errors.New("ior must be set")
+	}
+	return validRoughness(c.Roughness, c.Anisotropy)
+}
+
+func (c Conductor) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	frame := newLocalFrame(hr)
+	wo := frame.toLocal(ray.Direction.Unit().Neg())
+	if wo[2] <= 0 {
+		return ScatterRecord{}
+	}
+
+	if smooth(c.Roughness) {
+		wi := Vec3{-wo[0], -wo[1], wo[2]}
+		return ScatterRecord{
+			Scatter:     true,
+			Ray:         Ray{Origin: hr.P, Direction: frame.fromLocal(wi)},
+			Attenuation: c.IOR.fresnel(wo[2]),
+			Specular:    true,
+		}
+	}
+
+	g := newGGX(c.Roughness, c.Anisotropy)
+	u1, u2 := sampler.Get2D()
+	wm := g.sampleWm(wo, u1, u2)
+	wi := reflectVector(wo.Neg(), wm)
+	if wi[2] <= 0 {
+		return ScatterRecord{}
+	}
+
+	// Eval over PDF, simplified
+	attenuation := Vec3(c.IOR.fresnel(wo.Dot(wm))).MulFloat(g.g(wo, wi) / g.g1(wo))
+
+	return ScatterRecord{
+		Scatter:     true,
+		Ray:         Ray{Origin: hr.P, Direction: frame.fromLocal(wi)},
+		Attenuation: Color(attenuation),
+		PDF:         g.visibleD(wo, wm) / (4 * wo.Dot(wm)),
+	}
+}
+
+func (c Conductor) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	if smooth(c.Roughness) {
+		return Color{}
+	}
+
+	wo, wi, wm, ok := reflectionLocal(ray, hr, wi)
+	if !ok {
+		return Color{}
+	}
+
+	g := newGGX(c.Roughness, c.Anisotropy)
+	return Color(Vec3(c.IOR.fresnel(wo.Dot(wm))).MulFloat(g.d(wm) * g.g(wo, wi) / (4 * wo[2])))
+}
+
+func (c Conductor) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	if smooth(c.Roughness) {
+		return 0
+	}
+
+	wo, _, wm, ok := reflectionLocal(ray, hr, wi)
+	if !ok {
+		return 0
+	}
+
+	g := newGGX(c.Roughness, c.Anisotropy)
+	return g.visibleD(wo, wm) / (4 * wo.Dot(wm))
+}
+
+func (c Conductor) Emitted(Ray, HitRecord) Color {
+	return Color{}
+}
+
+// reflectionLocal returns, in the shading frame of hr, the directions ray
+// comes from and wi, both unit, and the microfacet normal reflecting one into
+// the other. It fails when they aren't both above the surface.
+func reflectionLocal(ray Ray, hr HitRecord, wi Vec3) (Vec3, Vec3, Vec3, bool) {
+	frame := newLocalFrame(hr)
+	wo := frame.toLocal(ray.Direction.Unit().Neg())
+	wi = frame.toLocal(wi.Unit())
+	if wo[2] <= 0 || wi[2] <= 0 {
+		return Vec3{}, Vec3{}, Vec3{}, false
+	}
+
+	wm := wo.Add(wi)
+	if wm.NearZero() {
+		return Vec3{}, Vec3{}, Vec3{}, false
+	}
+	return wo, wi, wm.Unit(), true
+}
+
+// scatterRough samples the GGX microfacets of a rough dielectric, reflecting
+// or refracting off the sampled one according to its Fresnel reflectance.
+func (d Dielectric) scatterRough(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	frame := newLocalFrame(hr)
+	wo := frame.toLocal(ray.Direction.Unit().Neg())
+	if wo[2] <= 0 {
+		return ScatterRecord{}
+	}
+
+	eta := d.relativeIndex(hr)
+	g := newGGX(d.Roughness, d.Anisotropy)
+	u1, u2 := sampler.Get2D()
+	wm := g.sampleWm(wo, u1, u2)
+	cosO := wo.Dot(wm)
+	reflectance := fresnelDielectric(cosO, eta)
+
+	var wi Vec3
+	var pdf float64
+	if sampler.Get1D() < reflectance {
+		wi = reflectVector(wo.Neg(), wm)
+		if wi[2] <= 0 {
+			return ScatterRecord{}
+		}
+		pdf = g.visibleD(wo, wm) / (4 * cosO) * reflectance
+	} else {
+		var ok bool
+		wi, ok = refractLocal(wo, wm, eta)
+		if !ok || wi[2] >= 0 {
+			return ScatterRecord{}
+		}
+		pdf = g.visibleD(wo, wm) * transmissionJacobian(wo, wi, wm, eta) * (1 - reflectance)
+	}
+
+	// Eval over PDF, the Fresnel terms cancel out
+	weight := g.g(wo, wi) / g.g1(wo)
+
+	return ScatterRecord{
+		Scatter:     true,
+		Ray:         Ray{Origin: hr.P, Direction: frame.fromLocal(wi)},
+		Attenuation: Color{weight, weight, weight},
+		PDF:         pdf,
+	}
+}
+
+// evalRough returns the BSDF of a rough dielectric times the cosine of wi,
+// and the density with which scatterRough samples wi.
+func (d Dielectric) evalRough(ray Ray, hr HitRecord, wi Vec3) (float64, float64) {
+	frame := newLocalFrame(hr)
+	wo := frame.toLocal(ray.Direction.Unit().Neg())
+	wi = frame.toLocal(wi.Unit())
+	if wo[2] <= 0 || wi[2] == 0 {
+		return 0, 0
+	}
+
+	eta := d.relativeIndex(hr)
+	reflection := wi[2] > 0
+
+	// the generalized half vector
+	wm := wo.Add(wi)
+	if !reflection {
+		wm = wo.Add(wi.MulFloat(eta))
+	}
+	if wm.NearZero() {
+		return 0, 0
+	}
+	wm = wm.Unit()
+	if wm[2] < 0 {
+		wm = wm.Neg()
+	}
+
+	// discard microfacets seen from behind
+	cosO, cosI := wo.Dot(wm), wi.Dot(wm)
+	if cosO <= 0 || cosI*wi[2] <= 0 {
+		return 0, 0
+	}
+
+	g := newGGX(d.Roughness, d.Anisotropy)
+	reflectance := fresnelDielectric(cosO, eta)
+
+	if reflection {
+		f := g.d(wm) * g.g(wo, wi) * reflectance / (4 * wo[2])
+		pdf := g.visibleD(wo, wm) / (4 * cosO) * reflectance
+		return f, pdf
+	}
+
+	jacobian := transmissionJacobian(wo, wi, wm, eta)
+	// radiance isn't scaled by the change of solid angle across the
+	// interface, as with smooth dielectrics
+	f := g.d(wm) * g.g(wo, wi) * (1 - reflectance) * cosO * jacobian / wo[2]
+	pdf := g.visibleD(wo, wm) * jacobian * (1 - reflectance)
+	return f, pdf
+}
+
+// transmissionJacobian is the derivative of the microfacet normal wm with
+// respect to the direction wi refracted from wo.
+func transmissionJacobian(wo, wi, wm Vec3, eta float64) float64 {
+	denom := wi.Dot(wm) + wo.Dot(wm)/eta
+	return math.Abs(wi.Dot(wm)) / (denom * denom)
+}
@@ -0,0 +1,169 @@
+package tracer
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGGXNormalized(t *testing.T) {
+	const n = 1 << 16
+	s := NewSobolSampler(1)
+	wo := Vec3{0.6, 0.2, 0.5}.Unit()
+
+	for _, g := range []ggx{newGGX(0.3, 0), newGGX(0.7, 0), newGGX(0.5, 0.8)} {
+		// projected areas of microfacets over the hemisphere, total and seen
+		// from wo, are 1
+		var projected, visible float64
+		for i := 0; i < n; i++ {
+			s.StartSample(0, 0, i)
+			wm := UniformSampleSphere(s.Get2D())
+			wm[2] = math.Abs(wm[2])
+			projected += g.d(wm) * wm[2] * 2 * math.Pi / n
+			if wm.Dot(wo) > 0 {
+				visible += g.visibleD(wo, wm) * 2 * math.Pi / n
+			}
+		}
+		if !approxEqual(projected, 1, 0.02) || !approxEqual(visible, 1, 0.02) {
+			t.Errorf("%+v: projected area %v and visible one %v, want 1", g, projected, visible)
+		}
+
+		// sampled normals face wo and the surface
+		for i := 0; i < 64; i++ {
+			s.StartSample(1, 0, i)
+			u1, u2 := s.Get2D()
+			if wm := g.sampleWm(wo, u1, u2); wm[2] <= 0 || wm.Dot(wo) <= 0 || !approxEqual(wm.Len(), 1, 1e-9) {
+				t.Fatalf("%+v: sampled normal %v", g, wm)
+			}
+		}
+	}
+}
+
+// scatterCase is a material hit by a ray.
+type scatterCase struct {
+	name string
+	m    Material
+	ray  Ray
+	hr   HitRecord
+}
+
+// testSphereCase is m hit on the outside of a unit sphere from direction, or
+// on the inside when inside is set.
+func testSphereCase(name string, m Material, direction Vec3, inside bool) scatterCase {
+	origin := Point3(direction.Unit().MulFloat(-3))
+	if inside {
+		origin = Point3{0.1, 0.2, 0}
+	}
+	ray := Ray{Origin: origin, Direction: direction}
+	hr := NewSphere(Point3{0, 0, 0}, 1, m).Hit(ray)
+	if !hr.Hit {
+		panic("the test ray misses the sphere")
+	}
+	return scatterCase{name: name, m: m, ray: ray, hr: hr}
+}
+
+// checkScatter checks that m's sampled scattering agrees with Eval and PDF:
+// attenuations are Eval over PDF, sampled PDFs are PDF, and PDF integrates to
+// the fraction of samples that scatter, specular ones aside. It returns the
+// albedo, the mean attenuation.
+func checkScatter(t *testing.T, c scatterCase, n int) Color {
+	t.Helper()
+	s := NewSampler(SamplerIndependent, 7, n)
+
+	var (
+		albedo              Vec3
+		scattered, pdfTotal float64
+	)
+	for i := 0; i < n; i++ {
+		s.StartSample(0, 0, i)
+		sr := c.m.Scatter(c.ray, c.hr, s)
+		if !sr.Scatter {
+			continue
+		}
+		albedo = albedo.Add(Vec3(sr.Attenuation).MulFloat(1 / float64(n)))
+		if sr.Specular {
+			continue
+		}
+		scattered++
+
+		pdf := c.m.PDF(c.ray, c.hr, sr.Ray.Direction)
+		if !approxEqual(pdf, sr.PDF, 1e-9*pdf) {
+			t.Fatalf("%s: PDF %v of a sample of PDF %v", c.name, pdf, sr.PDF)
+		}
+		eval := Vec3(c.m.Eval(c.ray, c.hr, sr.Ray.Direction)).MulFloat(1 / pdf)
+		if !approxVec3(eval, Vec3(sr.Attenuation), 1e-9*math.Max(1, sr.Attenuation.Luminance())) {
+			t.Fatalf("%s: Eval over PDF %v of a sample of attenuation %v", c.name, eval, sr.Attenuation)
+		}
+	}
+
+	// the PDF integrates to what it doesn't sample below the surface, i.e.
+	// rejected samples; lobes are sharp, so it takes many directions
+	const directions = 1 << 18
+	uniform := NewSobolSampler(7)
+	for i := 0; i < directions; i++ {
+		uniform.StartSample(0, 0, i)
+		pdfTotal += c.m.PDF(c.ray, c.hr, UniformSampleSphere(uniform.Get2D())) * 4 * math.Pi / directions
+	}
+	if !approxEqual(pdfTotal, scattered/float64(n), 0.02) {
+		t.Errorf("%s: PDF integrates to %v, %v of samples scattered", c.name, pdfTotal, scattered/float64(n))
+	}
+
+	return Color(albedo)
+}
+
+func TestMicrofacetScatter(t *testing.T) {
+	white := ComplexIOR{Eta: Color{1e-4, 1e-4, 1e-4}, K: Color{1e4, 1e4, 1e4}}
+	grazing := Vec3{-1, -0.1, 0}
+	oblique := Vec3{-3, -1.2, -0.7}
+
+	tests := []struct {
+		scatterCase
+		// bounds of the albedo's luminance
+		min, max float64
+	}{
+		// a perfect reflector only loses light to multiple scattering
+		{testSphereCase("white conductor", Conductor{IOR: white, Roughness: 0.3}, oblique, false), 0.95, 1},
+		{testSphereCase("white conductor, grazing", Conductor{IOR: white, Roughness: 0.3}, grazing, false), 0.8, 1},
+		{testSphereCase("rough white conductor", Conductor{IOR: white, Roughness: 0.8}, oblique, false), 0.5, 0.6},
+		{testSphereCase("gold", Conductor{IOR: IORGold, Roughness: 0.5}, oblique, false), 0.3, 0.9},
+		{testSphereCase("anisotropic copper", Conductor{IOR: IORCopper, Roughness: 0.4, Anisotropy: 0.8}, oblique, false), 0.3, 0.9},
+		{testSphereCase("rough glass", Dielectric{RefractiveIndex: 1.5, Roughness: 0.3}, oblique, false), 0.9, 1},
+		{testSphereCase("rough glass, grazing", Dielectric{RefractiveIndex: 1.5, Roughness: 0.3}, grazing, false), 0.8, 1},
+		{testSphereCase("anisotropic glass", Dielectric{RefractiveIndex: 1.5, Roughness: 0.5, Anisotropy: 0.6}, oblique, false), 0.85, 1},
+		{testSphereCase("rough glass, inside", Dielectric{RefractiveIndex: 1.5, Roughness: 0.3}, Vec3{0.7, 0.3, -0.2}, true), 0.9, 1},
+		{testSphereCase("smooth gold", Conductor{IOR: IORGold}, oblique, false), 0.3, 0.9},
+	}
+
+	for _, test := range tests {
+		albedo := checkScatter(t, test.scatterCase, 20000)
+		if l := albedo.Luminance(); l < test.min || l > test.max {
+			t.Errorf("%s: albedo %v, want a luminance in [%v, %v]", test.name, albedo, test.min, test.max)
+		}
+	}
+}
+
+func TestComplexIORJSON(t *testing.T) {
+	for name, preset := range ComplexIORPresets {
+		b, err := json.Marshal(preset)
+		if err != nil || string(b) != `"`+name+`"` {
+			t.Errorf("%s: marshaled as %s, %v", name, b, err)
+		}
+	}
+
+	custom := ComplexIOR{Eta: Color{1, 2, 3}, K: Color{4, 5, 6}}
+	b, err := json.Marshal(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ComplexIOR
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded != custom {
+		t.Errorf("%s decoded as %v, %v", b, decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`"silver"`), &decoded); err != nil || decoded != IORSilver {
+		t.Errorf("silver decoded as %v, %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`"brass"`), &decoded); err == nil || !strings.Contains(err.Error(), "brass") {
+		t.Errorf("unknown preset decoded with error %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package tracer
+
+import "errors"
+
+// NormalMapped adds surface detail to Material by perturbing the shading
+// normal it sees, with a tangent-space normal map and/or a grayscale bump map
+// (bump first when both are set).
+//
+// Perturbed normals can't let light through the geometric surface: directions
+// on one side of it and the other side of the shading normal, which would
+// leak light, are absorbed.
+type NormalMapped struct {
+	Material Material `json:"material"`
+	// NormalMap holds unit normals remapped from [-1, 1] to [0, 1], in the
+	// shading frame of hits: red along the tangent, green along the bitangent
+	// (i.e. towards increasing V) and blue along the normal. Images of normals
+	// aren't colors, see ImageTexture.Linear.
+	NormalMap Texture `json:"normal_map,omitempty"`
+	// BumpMap's luminance is a height over the surface, differentiated along
+	// the shading frame both in texture coordinates and in space, so textures
+	// mapped either way work. Like normal maps, bump maps are linear.
+	// Differences in texture coordinates span a texel of image textures.
+	BumpMap Texture `json:"bump_map,omitempty"`
+	// Scale of BumpMap's heights, 1 when zero
+	BumpScale float64 `json:"bump_scale,omitempty"`
+}
+
+const (
+	// bumpUVStep is the step of the finite differences of bump maps in
+	// texture coordinates, when they aren't images.
+	bumpUVStep = 1.0 / 1024
+	// bumpSpaceStep is the step of the finite differences of bump maps in
+	// space, relative to the distance of the hit.
+	bumpSpaceStep = 0.0005
+)
+
+func (m *NormalMapped) finishDecode() error {
+	if m.NormalMap == nil && m.BumpMap == nil {
+		return errors.New("normal mapped material needs a normal or bump map")
+	}
+	return nil
+}
+
+func (m NormalMapped) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	shading := m.shade(ray, hr)
+	sr := m.Material.Scatter(ray, shading, sampler)
+	if sr.Scatter && leaks(sr.Ray.Direction, hr, shading) {
+		return ScatterRecord{}
+	}
+	return sr
+}
+
+func (m NormalMapped) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	shading := m.shade(ray, hr)
+	if leaks(wi, hr, shading) {
+		return Color{}
+	}
+	return m.Material.Eval(ray, shading, wi)
+}
+
+func (m NormalMapped) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	shading := m.shade(ray, hr)
+	if leaks(wi, hr, shading) {
+		return 0
+	}
+	return m.Material.PDF(ray, shading, wi)
+}
+
+func (m NormalMapped) Emitted(ray Ray, hr HitRecord) Color {
+	return m.Material.Emitted(ray, m.shade(ray, hr))
+}
+
+func (m NormalMapped) Emissive() bool {
+	return emissive(m.Material)
+}
+
+// shade returns hr with its shading frame perturbed by the maps.
+func (m NormalMapped) shade(ray Ray, hr HitRecord) HitRecord {
+	normal := hr.Normal
+
+	if m.BumpMap != nil {
+		scale := m.BumpScale
+		if scale == 0 {
+			scale = 1
+		}
+
+		du, dv, dp := m.bumpSteps(ray, hr)
+		height := func(u, v float64, p Vec3) float64 {
+			return m.BumpMap.Value(u, v, Point3(p)).Luminance()
+		}
+		h := height(hr.U, hr.V, Vec3(hr.P))
+		dhdu := (height(hr.U+du, hr.V, Vec3(hr.P))-h)/du +
+			(height(hr.U, hr.V, Vec3(hr.P).Add(hr.Tangent.MulFloat(dp)))-h)/dp
+		dhdv := (height(hr.U, hr.V+dv, Vec3(hr.P))-h)/dv +
+			(height(hr.U, hr.V, Vec3(hr.P).Add(hr.Bitangent.MulFloat(dp)))-h)/dp
+		dhdu, dhdv = dhdu*scale, dhdv*scale
+
+		normal = normal.Sub(hr.Tangent.MulFloat(dhdu)).Sub(hr.Bitangent.MulFloat(dhdv)).Unit()
+	}
+
+	if m.NormalMap != nil {
+		c := m.NormalMap.Value(hr.U, hr.V, hr.P)
+		tangent, bitangent := shadingFrame(normal, hr.Tangent, hr.Bitangent)
+		normal = tangent.MulFloat(2*c[0] - 1).
+			Add(bitangent.MulFloat(2*c[1] - 1)).
+			Add(normal.MulFloat(2*c[2] - 1))
+		if normal.NearZero() {
+			normal = hr.Normal
+		}
+		normal = normal.Unit()
+	}
+
+	// normals facing away from the ray would shade the surface from behind,
+	// tilt them towards it
+	wo := ray.Direction.Unit().Neg()
+	if c := wo.Dot(normal); c < 0.01 {
+		normal = normal.Add(wo.MulFloat(0.01 - c)).Unit()
+	}
+
+	shading := hr
+	shading.Normal = normal
+	shading.Tangent, shading.Bitangent = shadingFrame(normal, hr.Tangent, hr.Bitangent)
+	return shading
+}
+
+// bumpSteps are the steps of the finite differences of the bump map at hr,
+// in texture coordinates and in space.
+func (m NormalMapped) bumpSteps(ray Ray, hr HitRecord) (du, dv, dp float64) {
+	du, dv = bumpUVStep, bumpUVStep
+	if t, ok := m.BumpMap.(*ImageTexture); ok && t.image.width > 0 {
+		du, dv = 1/float64(t.image.width), 1/float64(t.image.height)
+	}
+
+	dp = bumpSpaceStep
+	if distance := hr.T * ray.Direction.Len(); distance > 0 {
+		dp *= distance
+	}
+	return du, dv, dp
+}
+
+// leaks tells whether direction is on different sides of the geometric
+// surface of hr and of its shading surface.
+func leaks(direction Vec3, hr, shading HitRecord) bool {
+	return (direction.Dot(hr.GeometricNormal) > 0) != (direction.Dot(shading.Normal) > 0)
+}
@@ -0,0 +1,131 @@
+package tracer
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNormalMappedFlatMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normal.png")
+	writeTestPNG(t, path, 2, 2, func(x, y int) [3]uint8 { return [3]uint8{128, 128, 255} })
+
+	hr := HitRecord{
+		Hit:             true,
+		Normal:          Vec3{0, 0, 1},
+		GeometricNormal: Vec3{0, 0, 1},
+		Tangent:         Vec3{1, 0, 0},
+		Bitangent:       Vec3{0, 1, 0},
+		U:               0.5,
+		V:               0.5,
+	}
+	ray := Ray{Origin: Point3{0.3, 0.2, 1}, Direction: Vec3{-0.3, -0.2, -1}}
+
+	shade := func(linear bool) Vec3 {
+		texture, err := LoadImageTexture(path, WrapRepeat, linear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NormalMapped{Material: Lambertian{Albedo: Color{1, 1, 1}}, NormalMap: texture}
+		return m.shade(ray, hr).Normal
+	}
+
+	// 128 is 1/255 off 0 in [-1, 1]
+	if n := shade(true); !approxVec3(n, hr.Normal, 0.01) {
+		t.Errorf("flat linear normal map shades with %v, want %v", n, hr.Normal)
+	}
+	// sRGB decoding, as for colors, darkens 128 to 0.22 and tilts normals
+	if n := shade(false); n.Dot(hr.Normal) > 0.9 {
+		t.Errorf("flat sRGB normal map shades with %v, want tilted", n)
+	}
+}
+
+// testTexture is a Texture computed by its func.
+type testTexture func(u, v float64, p Point3) Color
+
+func (f testTexture) Value(u, v float64, p Point3) Color {
+	return f(u, v, p)
+}
+
+func TestNormalMappedBumpMap(t *testing.T) {
+	hr := HitRecord{
+		Hit:             true,
+		T:               2,
+		P:               Point3{0.3, 0.2, 0},
+		Normal:          Vec3{0, 0, 1},
+		GeometricNormal: Vec3{0, 0, 1},
+		Tangent:         Vec3{1, 0, 0},
+		Bitangent:       Vec3{0, 1, 0},
+		U:               0.5,
+		V:               0.5,
+	}
+	ray := Ray{Origin: Point3{0.3, 0.2, 2}, Direction: Vec3{0, 0, -1}}
+
+	tests := []struct {
+		name   string
+		height func(u, v float64, p Point3) float64
+		scale  float64
+		want   Vec3
+	}{
+		{"uv", func(u, v float64, _ Point3) float64 { return 0.1 * u }, 0, Vec3{-0.1, 0, 1}},
+		{"scaled", func(u, v float64, _ Point3) float64 { return 0.1 * u }, 2, Vec3{-0.2, 0, 1}},
+		{"space", func(_, _ float64, p Point3) float64 { return 0.1 * p[1] }, 0, Vec3{0, -0.1, 1}},
+		{"both", func(u, _ float64, p Point3) float64 { return 0.1*u + 0.3*p[1] }, 0, Vec3{-0.1, -0.3, 1}},
+	}
+
+	for _, test := range tests {
+		height := test.height
+		m := NormalMapped{
+			Material: Lambertian{Albedo: Color{1, 1, 1}},
+			BumpMap: testTexture(func(u, v float64, p Point3) Color {
+				h := height(u, v, p)
+				return Color{h, h, h}
+			}),
+			BumpScale: test.scale,
+		}
+		if n := m.shade(ray, hr).Normal; !approxVec3(n, test.want.Unit(), 1e-6) {
+			t.Errorf("%s: normal %v, want %v", test.name, n, test.want.Unit())
+		}
+	}
+}
+
+func TestNormalMappedBumpSteps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "height.png")
+	writeTestPNG(t, path, 4, 2, func(x, y int) [3]uint8 { return [3]uint8{128, 128, 128} })
+	image, err := LoadImageTexture(path, WrapRepeat, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ray := Ray{Origin: Point3{0, 0, 10}, Direction: Vec3{0, 0, -2}}
+	hr := HitRecord{T: 2.5}
+
+	// a texel of images, and a step growing with the distance of the hit
+	if du, dv, dp := (NormalMapped{BumpMap: image}).bumpSteps(ray, hr); du != 0.25 || dv != 0.5 || !approxEqual(dp, 5*bumpSpaceStep, testEpsilon) {
+		t.Errorf("image: steps %v, %v and %v", du, dv, dp)
+	}
+	if du, dv, _ := (NormalMapped{BumpMap: MarbleTexture{}}).bumpSteps(ray, hr); du != bumpUVStep || dv != bumpUVStep {
+		t.Errorf("marble: steps %v and %v, want %v", du, dv, bumpUVStep)
+	}
+}
+
+func TestJSONLinearImageTexture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normal.png")
+	writeTestPNG(t, path, 1, 1, func(x, y int) [3]uint8 { return [3]uint8{128, 128, 255} })
+
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+	json := `{"version": 1, ` + camera + `, "materials": {"white": {"type": "lambertian", "albedo": [1, 1, 1]}, ` +
+		`"bumpy": {"type": "normal_mapped", "material": "white", ` +
+		`"normal_map": {"type": "image", "path": ` + strconv.Quote(path) + `, "linear": true}}}, ` +
+		`"shapes": [{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "bumpy"}]}`
+
+	scene, err := DecodeJSONScene(strings.NewReader(json))
+	if err != nil {
+		t.Fatal(err)
+	}
+	texture := scene.HitterList[0].(*Sphere).Material.(NormalMapped).NormalMap.(*ImageTexture)
+	if c := texture.Value(0.5, 0.5, Point3{}); !texture.Linear || c[0] != 128.0/255 || c[2] != 1 {
+		t.Errorf("decoded %#v with value %v, want a linear image", texture, c)
+	}
+}
@@ -0,0 +1,523 @@
+package tracer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultOBJMaterial is used by faces that don't reference an MTL material.
+var DefaultOBJMaterial Material = Principled{BaseColor: Color{0.8, 0.8, 0.8}, Roughness: 0.5, Specular: 0.5}
+
+// LoadOBJ reads a Wavefront OBJ file, and the MTL files it references, into
+// one TriangleMesh per group and material. Polygons are fan triangulated.
+func LoadOBJ(path string) (HitterList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &objParser{
+		name:      path,
+		dir:       filepath.Dir(path),
+		materials: map[string]Material{},
+		meshes:    map[objMeshKey]*objMesh{},
+	}
+	if err := p.parse(f); err != nil {
+		return nil, err
+	}
+
+	return p.hitterList()
+}
+
+type objMeshKey struct {
+	group, material string
+}
+
+// objMesh accumulates the faces of a group/material pair. OBJ indexes
+// positions, uvs and normals separately, so every distinct v/vt/vn triplet
+// becomes a vertex of the mesh.
+type objMesh struct {
+	material  Material
+	vertices  map[[3]int]int
+	positions []Point3
+	normals   []Vec3
+	uvs       [][2]float64
+	indices   []int
+	// some vertex referenced a uv, every vertex referenced a normal
+	anyUVs, allNormals bool
+}
+
+type objParser struct {
+	name string
+	dir  string
+	line int
+
+	positions []Point3
+	uvs       [][2]float64
+	normals   []Vec3
+
+	materials map[string]Material
+	group     string
+	material  string
+
+	meshes map[objMeshKey]*objMesh
+	order  []objMeshKey
+}
+
+func (p *objParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.line, fmt.Sprintf(format, args...))
+}
+
+// maxOBJLine is the longest line of OBJ and MTL files, e.g. of faces with
+// many vertices.
+const maxOBJLine = 1 << 20
+
+func (p *objParser) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxOBJLine)
+
+	for scanner.Scan() {
+		p.line++
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "v":
+			var v Vec3
+			v, err = p.parseVec3(fields[1:])
+			p.positions = append(p.positions, Point3(v))
+		case "vt":
+			var uv [2]float64
+			uv, err = p.parseUV(fields[1:])
+			p.uvs = append(p.uvs, uv)
+		case "vn":
+			var v Vec3
+			v, err = p.parseVec3(fields[1:])
+			p.normals = append(p.normals, v.Unit())
+		case "f":
+			err = p.parseFace(fields[1:])
+		case "g", "o":
+			p.group = strings.Join(fields[1:], " ")
+		case "usemtl":
+			p.material = strings.Join(fields[1:], " ")
+			if _, ok := p.materials[p.material]; !ok {
+				err = p.errorf("unknown material %q", p.material)
+			}
+		case "mtllib":
+			for _, lib := range fields[1:] {
+				if err = p.loadMTL(filepath.Join(p.dir, lib)); err != nil {
+					break
+				}
+			}
+		default:
+			// s, l, p and friends don't matter to the tracer
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (p *objParser) parseFloats(fields []string, min, max int) ([]float64, error) {
+	if len(fields) < min || len(fields) > max {
+		return nil, p.errorf("expected %d to %d values, got %d", min, max, len(fields))
+	}
+
+	values := make([]float64, len(fields))
+	for i := range fields {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", fields[i])
+		}
+		values[i] = f
+	}
+
+	return values, nil
+}
+
+func (p *objParser) parseVec3(fields []string) (Vec3, error) {
+	// a 4th (w) component is allowed but ignored
+	values, err := p.parseFloats(fields, 3, 4)
+	if err != nil {
+		return Vec3{}, err
+	}
+	return Vec3{values[0], values[1], values[2]}, nil
+}
+
+func (p *objParser) parseUV(fields []string) ([2]float64, error) {
+	values, err := p.parseFloats(fields, 1, 3)
+	if err != nil {
+		return [2]float64{}, err
+	}
+	if len(values) == 1 {
+		return [2]float64{values[0], 0}, nil
+	}
+	return [2]float64{values[0], values[1]}, nil
+}
+
+// parseIndex resolves a 1-based (or negative, relative to the end) OBJ index
+// into a 0-based one.
+func (p *objParser) parseIndex(s string, count int, kind string) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, p.errorf("invalid %s index %q", kind, s)
+	}
+
+	switch {
+	case idx > 0:
+		idx--
+	case idx < 0:
+		idx += count
+	default:
+		return 0, p.errorf("invalid %s index 0", kind)
+	}
+
+	if idx < 0 || idx >= count {
+		return 0, p.errorf("%s index %s out of range", kind, s)
+	}
+
+	return idx, nil
+}
+
+func (p *objParser) parseFace(fields []string) error {
+	if len(fields) < 3 {
+		return p.errorf("face needs at least 3 vertices, got %d", len(fields))
+	}
+
+	mesh := p.mesh()
+
+	indices := make([]int, len(fields))
+	for i, field := range fields {
+		// -1 marks a missing uv/normal
+		vertex := [3]int{-1, -1, -1}
+		parts := strings.Split(field, "/")
+		if len(parts) > 3 {
+			return p.errorf("invalid face vertex %q", field)
+		}
+
+		var err error
+		if vertex[0], err = p.parseIndex(parts[0], len(p.positions), "position"); err != nil {
+			return err
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			if vertex[1], err = p.parseIndex(parts[1], len(p.uvs), "uv"); err != nil {
+				return err
+			}
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			if vertex[2], err = p.parseIndex(parts[2], len(p.normals), "normal"); err != nil {
+				return err
+			}
+		}
+
+		indices[i] = mesh.vertex(vertex, p)
+	}
+
+	for i := 1; i+1 < len(indices); i++ {
+		mesh.indices = append(mesh.indices, indices[0], indices[i], indices[i+1])
+	}
+
+	return nil
+}
+
+func (p *objParser) mesh() *objMesh {
+	key := objMeshKey{group: p.group, material: p.material}
+	if mesh, ok := p.meshes[key]; ok {
+		return mesh
+	}
+
+	material := DefaultOBJMaterial
+	if p.material != "" {
+		material = p.materials[p.material]
+	}
+
+	mesh := &objMesh{
+		material:   material,
+		vertices:   map[[3]int]int{},
+		allNormals: true,
+	}
+	p.meshes[key] = mesh
+	p.order = append(p.order, key)
+
+	return mesh
+}
+
+func (m *objMesh) vertex(vertex [3]int, p *objParser) int {
+	if idx, ok := m.vertices[vertex]; ok {
+		return idx
+	}
+
+	var uv [2]float64
+	if vertex[1] >= 0 {
+		uv = p.uvs[vertex[1]]
+		m.anyUVs = true
+	}
+
+	var normal Vec3
+	if vertex[2] >= 0 {
+		normal = p.normals[vertex[2]]
+	} else {
+		m.allNormals = false
+	}
+
+	idx := len(m.positions)
+	m.vertices[vertex] = idx
+	m.positions = append(m.positions, p.positions[vertex[0]])
+	m.uvs = append(m.uvs, uv)
+	m.normals = append(m.normals, normal)
+
+	return idx
+}
+
+func (p *objParser) hitterList() (HitterList, error) {
+	var l HitterList
+	for _, key := range p.order {
+		m := p.meshes[key]
+		if len(m.indices) == 0 {
+			continue
+		}
+
+		// shading normals are all or nothing, missing uvs are just (0, 0)
+		normals := m.normals
+		if !m.allNormals {
+			normals = nil
+		}
+		uvs := m.uvs
+		if !m.anyUVs {
+			uvs = nil
+		}
+
+		mesh, err := NewTriangleMesh(m.positions, normals, uvs, m.indices, m.material)
+		if err != nil {
+			return nil, fmt.Errorf("%s: group %q: %w", p.name, key.group, err)
+		}
+		l = append(l, mesh)
+	}
+	return l, nil
+}
+
+// mtlMaterial holds the MTL statements the tracer understands, including the
+// PBR extension ones (Pr, Pm, Ps, Pc, Pcr, aniso, Ke and their maps).
+type mtlMaterial struct {
+	kd, ks, ke, tf Color
+	ns, ni         float64
+	d              float64
+	illum          int
+	// PBR parameters, pr and pm only apply when set
+	pr, pm, ps, pc, pcr, aniso float64
+	nsSet, prSet, pmSet, tfSet bool
+	mapKd, mapKe, mapPr, mapPm Texture
+	// bump and normal maps, bm scaling the bump map
+	bump, norm Texture
+	bm         float64
+}
+
+// material maps an MTL material onto a Principled one: Kd (or map_Kd) is its
+// base color and Ns, unless Pr is set, its roughness. Transparent
+// illumination models (4, 6, 7, 9) are fully transmitting, tinted by Tf and
+// refracting with Ni, and smooth without Ns or Pr, while d < 1 makes it
+// partly transmitting. Reflective ones (3, 5, 8) are metallic, tinted by Ks,
+// unless Pm is set. Bump and normal maps wrap the material in a NormalMapped.
+func (m mtlMaterial) material() Material {
+	material := m.principled()
+	if m.bump == nil && m.norm == nil {
+		return material
+	}
+	return NormalMapped{Material: material, NormalMap: m.norm, BumpMap: m.bump, BumpScale: m.bm}
+}
+
+func (m mtlMaterial) principled() Principled {
+	p := Principled{
+		BaseColor:          m.kd,
+		BaseColorTexture:   m.mapKd,
+		Metallic:           Clamp(m.pm, 0, 1),
+		MetallicTexture:    m.mapPm,
+		Roughness:          Clamp(m.pr, 0, 1),
+		RoughnessTexture:   m.mapPr,
+		Anisotropy:         Clamp(m.aniso, 0, 1),
+		Specular:           0.5,
+		Sheen:              Clamp(m.ps, 0, 1),
+		Clearcoat:          Clamp(m.pc, 0, 1),
+		ClearcoatRoughness: Clamp(m.pcr, 0, 1),
+		IOR:                math.Max(m.ni, 0),
+		Emission:           m.ke,
+		EmissionTexture:    m.mapKe,
+	}
+
+	transparent := m.illum == 4 || m.illum == 6 || m.illum == 7 || m.illum == 9
+
+	if !m.prSet && (m.nsSet || !transparent) {
+		// from the Phong exponent to the GGX alpha it matches, and to
+		// roughness
+		p.Roughness = math.Pow(2/(math.Max(m.ns, 0)+2), 0.25)
+	}
+
+	switch {
+	case transparent:
+		p.Transmission = 1
+		p.BaseColor = Color{1, 1, 1}
+		if m.tfSet {
+			p.BaseColor = m.tf
+		}
+	case m.d < 1:
+		p.Transmission = Clamp(1-m.d, 0, 1)
+	case (m.illum == 3 || m.illum == 5 || m.illum == 8) && !m.pmSet:
+		p.Metallic = 1
+		p.BaseColor = m.ks
+	}
+
+	return p
+}
+
+func (p *objParser) loadMTL(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	defer f.Close()
+
+	mtl := &objParser{name: path}
+
+	var (
+		name    string
+		current *mtlMaterial
+	)
+	flush := func() {
+		if current != nil {
+			p.materials[name] = current.material()
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxOBJLine)
+	for scanner.Scan() {
+		mtl.line++
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "newmtl" {
+			flush()
+			name = strings.Join(fields[1:], " ")
+			current = &mtlMaterial{d: 1, ni: 1.5}
+			continue
+		}
+
+		if current == nil {
+			return mtl.errorf("%q before newmtl", fields[0])
+		}
+
+		var err error
+		switch fields[0] {
+		case "Kd", "Ks", "Ke", "Tf":
+			var v Vec3
+			if v, err = mtl.parseVec3(fields[1:]); err == nil {
+				switch fields[0] {
+				case "Kd":
+					current.kd = Color(v)
+				case "Ks":
+					current.ks = Color(v)
+				case "Ke":
+					current.ke = Color(v)
+				case "Tf":
+					current.tf, current.tfSet = Color(v), true
+				}
+			}
+		case "Ns", "Ni", "d", "Tr", "Pr", "Pm", "Ps", "Pc", "Pcr", "aniso":
+			var values []float64
+			if values, err = mtl.parseFloats(fields[1:], 1, 1); err == nil {
+				switch fields[0] {
+				case "Ns":
+					current.ns, current.nsSet = values[0], true
+				case "Ni":
+					current.ni = values[0]
+				case "d":
+					current.d = values[0]
+				case "Tr":
+					current.d = 1 - values[0]
+				case "Pr":
+					current.pr, current.prSet = values[0], true
+				case "Pm":
+					current.pm, current.pmSet = values[0], true
+				case "Ps":
+					current.ps = values[0]
+				case "Pc":
+					current.pc = values[0]
+				case "Pcr":
+					current.pcr = values[0]
+				case "aniso":
+					current.aniso = values[0]
+				}
+			}
+		case "map_Kd", "map_Ke", "map_Pr", "map_Pm", "map_Bump", "map_bump", "bump", "norm":
+			// the file name comes last, texture options but -bm aren't
+			// supported
+			if len(fields) < 2 {
+				err = mtl.errorf("%s without a file", fields[0])
+				break
+			}
+			var t *ImageTexture
+			// only map_Kd and map_Ke are colors, the other maps hold data
+			linear := fields[0] != "map_Kd" && fields[0] != "map_Ke"
+			if t, err = LoadImageTexture(filepath.Join(filepath.Dir(path), fields[len(fields)-1]), WrapRepeat, linear); err != nil {
+				err = mtl.errorf("%v", err)
+				break
+			}
+			switch fields[0] {
+			case "map_Kd":
+				current.mapKd = t
+			case "map_Ke":
+				current.mapKe = t
+			case "map_Pr":
+				current.mapPr = t
+			case "map_Pm":
+				current.mapPm = t
+			case "norm":
+				current.norm = t
+			default:
+				current.bump = t
+				for i := 1; i+2 < len(fields); i++ {
+					if fields[i] != "-bm" {
+						continue
+					}
+					var values []float64
+					if values, err = mtl.parseFloats(fields[i+1:i+2], 1, 1); err == nil {
+						current.bm = values[0]
+					}
+				}
+			}
+		case "illum":
+			var values []float64
+			if values, err = mtl.parseFloats(fields[1:], 1, 1); err == nil {
+				current.illum = int(values[0])
+			}
+		default:
+			// the remaining statements aren't supported
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	flush()
+
+	return nil
+}
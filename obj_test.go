@@ -0,0 +1,200 @@
+package tracer
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, by name, into a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadOBJ(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"scene.obj": `# a pentagon, a quad and a triangle with negative indices
+mtllib scene.mtl
+g pentagon
+v 0 0 1
+v 1 0 1
+v 1.5 0.5 1
+v 1 1 1
+v 0 1 1
+f 1 2 3 4 5
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 2
+g quad
+usemtl red
+f 6/1/1 7/2/1 8/3/1 9/4/1
+g triangle
+f -7 -6 -5
+`,
+		"scene.mtl": `newmtl red
+Kd 0.8 0.1 0.1
+Ns 0
+`,
+	})
+
+	l, err := LoadOBJ(filepath.Join(dir, "scene.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 3 {
+		t.Fatalf("got %d meshes, want one per group and material", len(l))
+	}
+
+	pentagon := l[0].(*TriangleMesh)
+	if len(pentagon.Indices) != 9 || pentagon.UVs != nil || pentagon.Normals != nil {
+		t.Errorf("pentagon has %d indices, uvs %v and normals %v, want 3 triangles without attributes", len(pentagon.Indices), pentagon.UVs, pentagon.Normals)
+	}
+	if pentagon.Material != DefaultOBJMaterial {
+		t.Errorf("pentagon material %#v, want the default", pentagon.Material)
+	}
+
+	quad := l[1].(*TriangleMesh)
+	if len(quad.Indices) != 6 || len(quad.Positions) != 4 {
+		t.Errorf("quad has %d indices and %d positions, want 6 and 4", len(quad.Indices), len(quad.Positions))
+	}
+	if len(quad.UVs) != 4 || len(quad.Normals) != 4 || !approxVec3(quad.Normals[0], Vec3{0, 0, 1}, testEpsilon) {
+		t.Errorf("quad uvs %v normals %v, want unit normals", quad.UVs, quad.Normals)
+	}
+	red, ok := quad.Material.(Principled)
+	if !ok || red.BaseColor != (Color{0.8, 0.1, 0.1}) {
+		t.Errorf("quad material %#v, want red", quad.Material)
+	}
+
+	triangle := l[2].(*TriangleMesh)
+	if len(triangle.Indices) != 3 || triangle.Positions[0] != (Point3{1.5, 0.5, 1}) || triangle.Material != quad.Material {
+		t.Errorf("triangle positions %v, want 3 to 5 with the quad's material", triangle.Positions)
+	}
+
+	hr := l.Hit(Ray{Origin: Point3{0.25, 0.75, -1}, Direction: Vec3{0, 0, 1}})
+	if !hr.Hit || hr.Material != quad.Material || !approxEqual(hr.U, 0.25, 1e-9) || !approxEqual(hr.V, 0.75, 1e-9) {
+		t.Errorf("hit %v at uv (%v, %v), want the quad at (0.25, 0.75)", hr.Hit, hr.U, hr.V)
+	}
+}
+
+func TestLoadOBJErrors(t *testing.T) {
+	tests := []struct {
+		name, obj, err string
+	}{
+		{"bad number", "v 0 x 0\n", "scene.obj:1: invalid number"},
+		{"short vertex", "v 0 0\n", "scene.obj:1: expected 3 to 4 values"},
+		{"small face", "v 0 0 0\nv 1 0 0\nf 1 2\n", "scene.obj:3: face needs at least 3 vertices"},
+		{"index out of range", "v 0 0 0\nv 1 0 0\nf 1 2 3\n", "scene.obj:3: position index 3 out of range"},
+		{"index 0", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n", "invalid position index 0"},
+		{"missing uv", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1 2/1 3/1\n", "uv index 1 out of range"},
+		{"unknown material", "usemtl nope\n", "scene.obj:1: unknown material \"nope\""},
+		{"missing mtllib", "mtllib nope.mtl\n", "nope.mtl"},
+		{"statement before newmtl", "mtllib bad.mtl\n", "bad.mtl:1: \"Kd\" before newmtl"},
+	}
+
+	for _, test := range tests {
+		dir := writeFiles(t, map[string]string{"scene.obj": test.obj, "bad.mtl": "Kd 1 1 1\n"})
+		_, err := LoadOBJ(filepath.Join(dir, "scene.obj"))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestLoadMTL(t *testing.T) {
+	// a long line, past bufio.Scanner's default limit
+	comment := "# " + strings.Repeat("x", 100000) + "\n"
+
+	dir := writeFiles(t, map[string]string{
+		"scene.obj": "mtllib scene.mtl\n",
+		"scene.mtl": comment + `newmtl plastic
+Kd 0.5 0.5 0.5
+Ns 98
+newmtl glass
+illum 7
+Ni 1.45
+Tf 0.9 1 0.9
+newmtl mirror
+illum 3
+Ks 0.9 0.9 0.9
+Ns 250
+newmtl pbr
+Kd 0.1 0.2 0.3
+Pr 0.3
+Pm 1
+Ke 1 1 0
+newmtl veil
+Kd 1 1 1
+d 0.25
+newmtl mapped
+Kd 1 1 1
+map_Kd -clamp on image.png
+map_Pr image.png
+map_Pm image.png
+newmtl bumpy
+Kd 1 1 1
+bump -bm 0.5 image.png
+norm image.png
+`,
+	})
+	writeTestPNG(t, filepath.Join(dir, "image.png"), 1, 1, func(x, y int) [3]uint8 { return [3]uint8{255, 0, 0} })
+
+	p := &objParser{name: "scene.obj", dir: dir, materials: map[string]Material{}, meshes: map[objMeshKey]*objMesh{}}
+	if err := p.loadMTL(filepath.Join(dir, "scene.mtl")); err != nil {
+		t.Fatal(err)
+	}
+
+	principled := func(name string) Principled {
+		t.Helper()
+		m, ok := p.materials[name].(Principled)
+		if !ok {
+			t.Fatalf("%s: material %#v, want Principled", name, p.materials[name])
+		}
+		return m
+	}
+
+	if m := principled("plastic"); !approxEqual(m.Roughness, math.Pow(0.02, 0.25), 1e-12) || m.BaseColor != (Color{0.5, 0.5, 0.5}) || m.Transmission != 0 || m.Metallic != 0 {
+		t.Errorf("plastic: %#v", m)
+	}
+	if m := principled("glass"); m.Transmission != 1 || m.IOR != 1.45 || m.BaseColor != (Color{0.9, 1, 0.9}) || m.Roughness != 0 {
+		t.Errorf("glass: %#v, want smooth transmission tinted by Tf", m)
+	}
+	if m := principled("mirror"); m.Metallic != 1 || m.BaseColor != (Color{0.9, 0.9, 0.9}) {
+		t.Errorf("mirror: %#v, want metallic tinted by Ks", m)
+	}
+	if m := principled("pbr"); m.BaseColor != (Color{0.1, 0.2, 0.3}) || m.Roughness != 0.3 || m.Metallic != 1 || m.Emission != (Color{1, 1, 0}) {
+		t.Errorf("pbr: %#v", m)
+	}
+	if m := principled("veil"); m.Transmission != 0.75 {
+		t.Errorf("veil: transmission %v, want 1 - d", m.Transmission)
+	}
+
+	// the file name comes last, past the options
+	m := principled("mapped")
+	if m.BaseColorTexture == nil || m.BaseColorTexture.Value(0.5, 0.5, Point3{}) != (Color{1, 0, 0}) {
+		t.Errorf("mapped: material %#v, want map_Kd's image", m)
+	}
+	// colors are sRGB, the other maps hold data
+	for name, texture := range map[string]Texture{"map_Kd": m.BaseColorTexture, "map_Pr": m.RoughnessTexture, "map_Pm": m.MetallicTexture} {
+		if image, ok := texture.(*ImageTexture); !ok || image.Linear != (name != "map_Kd") {
+			t.Errorf("mapped: %s is %#v", name, texture)
+		}
+	}
+	if m, ok := p.materials["bumpy"].(NormalMapped); !ok || m.BumpScale != 0.5 || !m.BumpMap.(*ImageTexture).Linear || !m.NormalMap.(*ImageTexture).Linear {
+		t.Errorf("bumpy: %#v, want linear normal and bump maps, scaled by -bm", p.materials["bumpy"])
+	}
+}
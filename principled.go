@@ -0,0 +1,291 @@
+package tracer
+
+import (
+	"errors"
+	"math"
+)
+
+// Principled is a Disney-style uber-material: its parameters blend between
+// diffuse, metallic, glossy, cloth-like, coated and transparent surfaces.
+// Parameters range from 0 to 1 unless said otherwise. Every parameter with a
+// texture takes the texture's value (its luminance for scalars) instead,
+// when set.
+//
+// Light is scattered by a mixture of lobes: a diffuse one with sheen, a GGX
+// specular reflection, a GGX clearcoat and a rough dielectric transmission.
+// Scatter picks one of them, but Eval and PDF take them all into account.
+type Principled struct {
+	BaseColor        Color   `json:"base_color"`
+	BaseColorTexture Texture `json:"base_color_texture,omitempty"`
+	// Metallic blends from a dielectric to a metal, whose reflections are
+	// tinted by BaseColor
+	Metallic        float64 `json:"metallic,omitempty"`
+	MetallicTexture Texture `json:"metallic_texture,omitempty"`
+	// Roughness of specular reflections and transmission, see Conductor
+	Roughness        float64 `json:"roughness,omitempty"`
+	RoughnessTexture Texture `json:"roughness_texture,omitempty"`
+	Anisotropy       float64 `json:"anisotropy,omitempty"`
+	// Specular is the reflectance of the dielectric at normal incidence over
+	// 0.08, 0.5 being the 4% of most materials
+	Specular        float64 `json:"specular,omitempty"`
+	SpecularTexture Texture `json:"specular_texture,omitempty"`
+	// Sheen is a soft reflection at grazing angles, e.g. of cloth, tinted
+	// from white to BaseColor by SheenTint
+	Sheen        float64 `json:"sheen,omitempty"`
+	SheenTint    float64 `json:"sheen_tint,omitempty"`
+	SheenTexture Texture `json:"sheen_texture,omitempty"`
+	// Clearcoat is a white glossy layer on top of the surface
+	Clearcoat          float64 `json:"clearcoat,omitempty"`
+	ClearcoatRoughness float64 `json:"clearcoat_roughness,omitempty"`
+	ClearcoatTexture   Texture `json:"clearcoat_texture,omitempty"`
+	// Transmission blends from an opaque dielectric to a transparent one,
+	// with IOR as its refractive index (1.5 when zero). Light entering it is
+	// tinted by BaseColor, once, so closed objects are tinted the same
+	// whatever their thickness.
+	Transmission        float64 `json:"transmission,omitempty"`
+	TransmissionTexture Texture `json:"transmission_texture,omitempty"`
+	IOR                 float64 `json:"ior,omitempty"`
+	// Emission is emitted from front faces
+	Emission        Color   `json:"emission,omitempty"`
+	EmissionTexture Texture `json:"emission_texture,omitempty"`
+}
+
+func (p *Principled) finishDecode() error {
+	for _, v := range []float64{p.Metallic, p.Specular, p.Sheen, p.SheenTint, p.Clearcoat, p.Transmission} {
+		if v < 0 || v > 1 {
+			return errors.New("metallic, specular, sheen, sheen tint, clearcoat and transmission must be between 0 and 1")
+		}
+	}
+	if p.IOR < 0 {
+		return errors.New("ior can't be negative")
+	}
+	if err := validRoughness(p.ClearcoatRoughness, 0); err != nil {
+		return errors.New("clearcoat roughness must be between 0 and 1")
+	}
+	return validRoughness(p.Roughness, p.Anisotropy)
+}
+
+// principledLobes are the lobes of a Principled material at a hit, for light
+// scattered towards wo.
+type principledLobes struct {
+	frame localFrame
+	wo    Vec3
+
+	base       Color
+	roughness  float64
+	sheenColor Vec3
+	// specular reflectance at normal incidence
+	f0 Vec3
+
+	// weights of the lobes
+	diffuse, specular, sheen, clearcoat, transmission float64
+
+	spec, coat ggx
+	glass      Dielectric
+	// light refracted at the hit enters the surface
+	entering bool
+
+	// probabilities of Scatter sampling each lobe
+	pDiffuse, pSpecular, pClearcoat, pTransmission float64
+}
+
+func (p Principled) lobes(ray Ray, hr HitRecord) (principledLobes, bool) {
+	l := principledLobes{frame: newLocalFrame(hr)}
+	l.wo = l.frame.toLocal(ray.Direction.Unit().Neg())
+	if l.wo[2] <= 0 {
+		return l, false
+	}
+
+	l.base = textureColor(p.BaseColorTexture, p.BaseColor, hr)
+	metallic := Clamp(textureScalar(p.MetallicTexture, p.Metallic, hr), 0, 1)
+	l.roughness = Clamp(textureScalar(p.RoughnessTexture, p.Roughness, hr), 0, 1)
+	specular := Clamp(textureScalar(p.SpecularTexture, p.Specular, hr), 0, 1)
+	transmission := Clamp(textureScalar(p.TransmissionTexture, p.Transmission, hr), 0, 1)
+
+	l.diffuse = (1 - metallic) * (1 - transmission)
+	l.specular = l.diffuse + metallic
+	l.sheen = l.diffuse * textureScalar(p.SheenTexture, p.Sheen, hr)
+	l.clearcoat = 0.25 * Clamp(textureScalar(p.ClearcoatTexture, p.Clearcoat, hr), 0, 1)
+	l.transmission = (1 - metallic) * transmission
+
+	l.sheenColor = lerpVec3(Vec3{1, 1, 1}, Vec3(l.base), p.SheenTint)
+	l.f0 = lerpVec3(Vec3{1, 1, 1}.MulFloat(0.08*specular), Vec3(l.base), metallic)
+
+	l.spec = newGGX(l.roughness, p.Anisotropy)
+	l.coat = newGGX(p.ClearcoatRoughness, 0)
+	ior := p.IOR
+	if ior == 0 {
+		ior = 1.5
+	}
+	l.glass = Dielectric{RefractiveIndex: ior, Roughness: l.roughness, Anisotropy: p.Anisotropy}
+	l.entering = hr.FrontFace
+
+	// reflections are sampled at least as often as their Fresnel
+	// reflectance at normal incidence would have them, so glossy highlights
+	// on diffuse surfaces aren't left to the diffuse lobe
+	l.pDiffuse = l.diffuse
+	l.pSpecular = l.specular * math.Max(schlick(l.f0, l.wo[2]).Luminance(), 0.25)
+	l.pClearcoat = l.clearcoat
+	l.pTransmission = l.transmission
+	total := l.pDiffuse + l.pSpecular + l.pClearcoat + l.pTransmission
+	if total == 0 {
+		return l, false
+	}
+	l.pDiffuse /= total
+	l.pSpecular /= total
+	l.pClearcoat /= total
+	l.pTransmission /= total
+
+	return l, true
+}
+
+// eval returns the BSDF times the cosine of wi, and the density with which
+// Scatter samples wi.
+func (l principledLobes) eval(ray Ray, hr HitRecord, wi Vec3) (Vec3, float64) {
+	local := l.frame.toLocal(wi.Unit())
+	wo := l.wo
+
+	var f Vec3
+	pdf := 0.0
+
+	if local[2] > 0 {
+		wm := wo.Add(local).Unit()
+		cosD := local.Dot(wm)
+
+		if l.diffuse > 0 {
+			// Burley's diffuse, retro-reflecting more the rougher the surface
+			fd90 := 0.5 + 2*l.roughness*cosD*cosD
+			fd := (1 + (fd90-1)*schlickWeight(local[2])) * (1 + (fd90-1)*schlickWeight(wo[2]))
+			f = f.Add(Vec3(l.base).MulFloat(l.diffuse * fd / math.Pi * local[2]))
+			f = f.Add(l.sheenColor.MulFloat(l.sheen * schlickWeight(cosD) * local[2]))
+			pdf += l.pDiffuse * local[2] / math.Pi
+		}
+
+		if l.specular > 0 {
+			d := l.spec.d(wm) * l.spec.g(wo, local) / (4 * wo[2])
+			f = f.Add(Vec3(schlick(l.f0, wo.Dot(wm))).MulFloat(l.specular * d))
+			pdf += l.pSpecular * l.spec.visibleD(wo, wm) / (4 * wo.Dot(wm))
+		}
+
+		if l.clearcoat > 0 {
+			d := l.coat.d(wm) * l.coat.g(wo, local) / (4 * wo[2])
+			fresnel := schlickScalar(0.04, wo.Dot(wm))
+			f = f.Add(Vec3{1, 1, 1}.MulFloat(l.clearcoat * fresnel * d))
+			pdf += l.pClearcoat * l.coat.visibleD(wo, wm) / (4 * wo.Dot(wm))
+		}
+	}
+
+	// smooth transmission is a delta lobe, only ever sampled by Scatter
+	if l.transmission > 0 && !smooth(l.roughness) {
+		ft, pt := l.glass.evalRough(ray, hr, wi)
+		f = f.Add(l.transmissionTint(local).MulFloat(l.transmission * ft))
+		pdf += l.pTransmission * pt
+	}
+
+	return f, pdf
+}
+
+// transmissionTint is the tint of the transmission lobe towards local.
+func (l principledLobes) transmissionTint(local Vec3) Vec3 {
+	if l.entering && local[2] < 0 {
+		return Vec3(l.base)
+	}
+	return Vec3{1, 1, 1}
+}
+
+func (p Principled) Scatter(ray Ray, hr HitRecord, sampler Sampler) ScatterRecord {
+	l, ok := p.lobes(ray, hr)
+	if !ok {
+		return ScatterRecord{}
+	}
+
+	u := sampler.Get1D()
+	u1, u2 := sampler.Get2D()
+
+	var wi Vec3
+	switch {
+	case u < l.pDiffuse:
+		local := Vec3{0, 0, 1}.Add(UniformSampleSphere(u1, u2))
+		if local.NearZero() {
+			local = Vec3{0, 0, 1}
+		}
+		wi = l.frame.fromLocal(local)
+	case u < l.pDiffuse+l.pSpecular:
+		wi = l.frame.fromLocal(reflectVector(l.wo.Neg(), l.spec.sampleWm(l.wo, u1, u2)))
+	case u < l.pDiffuse+l.pSpecular+l.pClearcoat:
+		wi = l.frame.fromLocal(reflectVector(l.wo.Neg(), l.coat.sampleWm(l.wo, u1, u2)))
+	case smooth(l.roughness):
+		sr := l.glass.Scatter(ray, hr, sampler)
+		tint := l.transmissionTint(l.frame.toLocal(sr.Ray.Direction.Unit()))
+		sr.Attenuation = Color(tint.MulFloat(l.transmission / l.pTransmission))
+		return sr
+	default:
+		sr := l.glass.scatterRough(ray, hr, sampler)
+		if !sr.Scatter {
+			return ScatterRecord{}
+		}
+		wi = sr.Ray.Direction
+	}
+
+	f, pdf := l.eval(ray, hr, wi)
+	if pdf == 0 || f.Zero() {
+		return ScatterRecord{}
+	}
+
+	return ScatterRecord{
+		Scatter:     true,
+		Ray:         Ray{Origin: hr.P, Direction: wi},
+		Attenuation: Color(f.MulFloat(1 / pdf)),
+		PDF:         pdf,
+	}
+}
+
+func (p Principled) Eval(ray Ray, hr HitRecord, wi Vec3) Color {
+	l, ok := p.lobes(ray, hr)
+	if !ok {
+		return Color{}
+	}
+	f, _ := l.eval(ray, hr, wi)
+	return Color(f)
+}
+
+func (p Principled) PDF(ray Ray, hr HitRecord, wi Vec3) float64 {
+	l, ok := p.lobes(ray, hr)
+	if !ok {
+		return 0
+	}
+	_, pdf := l.eval(ray, hr, wi)
+	return pdf
+}
+
+func (p Principled) Emissive() bool {
+	return p.EmissionTexture != nil || !Vec3(p.Emission).Zero()
+}
+
+func (p Principled) Emitted(ray Ray, hr HitRecord) Color {
+	if !hr.FrontFace {
+		return Color{}
+	}
+	return textureColor(p.EmissionTexture, p.Emission, hr)
+}
+
+// schlickWeight is Schlick's approximation of how Fresnel reflectance
+// increases towards grazing angles, of cosine cos with the normal.
+func schlickWeight(cos float64) float64 {
+	m := Clamp(1-cos, 0, 1)
+	return m * m * m * m * m
+}
+
+// schlick is Schlick's approximation of the Fresnel reflectance, f0 being
+// the reflectance at normal incidence.
+func schlick(f0 Vec3, cos float64) Color {
+	return Color(lerpVec3(f0, Vec3{1, 1, 1}, schlickWeight(cos)))
+}
+
+func schlickScalar(f0, cos float64) float64 {
+	return f0 + (1-f0)*schlickWeight(cos)
+}
+
+func lerpVec3(a, b Vec3, t float64) Vec3 {
+	return a.MulFloat(1 - t).Add(b.MulFloat(t))
+}
@@ -0,0 +1,123 @@
+package tracer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrincipledScatter(t *testing.T) {
+	white := Color{1, 1, 1}
+	oblique := Vec3{-3, -1.2, -0.7}
+	grazing := Vec3{-1, -0.1, 0}
+
+	tests := []struct {
+		scatterCase
+		// bounds of the albedo's luminance
+		min, max float64
+	}{
+		// Burley's diffuse retro-reflects a few percent more light than it
+		// receives when rough
+		{testSphereCase("white diffuse", Principled{BaseColor: white, Roughness: 1}, oblique, false), 0.95, 1.05},
+		{testSphereCase("white diffuse, grazing", Principled{BaseColor: white, Roughness: 0.5}, grazing, false), 0.8, 1.05},
+		{testSphereCase("white metal", Principled{BaseColor: white, Metallic: 1, Roughness: 0.2}, oblique, false), 0.95, 1},
+		{testSphereCase("white glass", Principled{BaseColor: white, Transmission: 1, Roughness: 0.4, Specular: 0.5}, oblique, false), 0.95, 1},
+		{testSphereCase("white glass, inside", Principled{BaseColor: white, Transmission: 1, Roughness: 0.4, Specular: 0.5}, Vec3{0.7, 0.3, -0.2}, true), 0.9, 1},
+		{testSphereCase("plastic", Principled{BaseColor: Color{0.8, 0.2, 0.2}, Roughness: 0.3, Specular: 0.5}, oblique, false), 0.3, 0.45},
+		{testSphereCase("anisotropic metal", Principled{BaseColor: Color{1, 0.8, 0.3}, Metallic: 1, Roughness: 0.2, Anisotropy: 0.5}, oblique, false), 0.7, 0.9},
+		{testSphereCase("cloth", Principled{BaseColor: Color{0.2, 0.3, 0.8}, Roughness: 0.8, Sheen: 1, SheenTint: 0.5}, grazing, false), 0.2, 0.6},
+		{testSphereCase("clearcoat", Principled{BaseColor: Color{0.1, 0.1, 0.1}, Roughness: 0.6, Specular: 0.5, Clearcoat: 1, ClearcoatRoughness: 0.1}, oblique, false), 0.1, 0.2},
+		{testSphereCase("everything", Principled{BaseColor: Color{0.5, 0.7, 0.3}, Metallic: 0.3, Transmission: 0.4, Roughness: 0.4, Specular: 0.5, Clearcoat: 0.5, ClearcoatRoughness: 0.3, Sheen: 0.3}, oblique, false), 0.5, 0.7},
+		{testSphereCase("textured", Principled{BaseColor: white, RoughnessTexture: MarbleTexture{Color: white, Scale: 3}, MetallicTexture: NoiseTexture{Color: white}}, oblique, false), 0.6, 0.9},
+	}
+
+	for _, test := range tests {
+		albedo := checkScatter(t, test.scatterCase, 20000)
+		if l := albedo.Luminance(); l < test.min || l > test.max {
+			t.Errorf("%s: albedo %v, want a luminance in [%v, %v]", test.name, albedo, test.min, test.max)
+		}
+	}
+}
+
+func TestPrincipledTransmissionTint(t *testing.T) {
+	base := Color{0.5, 1, 1}
+	direction := Vec3{-0.3, -0.2, -1}
+
+	for _, roughness := range []float64{0, 0.3} {
+		p := Principled{BaseColor: base, Transmission: 1, Roughness: roughness, Specular: 0.5}
+
+		for _, inside := range []bool{false, true} {
+			c := testSphereCase("glass", p, direction, inside)
+			s := NewSampler(SamplerIndependent, 3, 1000)
+
+			var refracted int
+			for i := 0; i < 1000; i++ {
+				s.StartSample(0, 0, i)
+				sr := p.Scatter(c.ray, c.hr, s)
+				if !sr.Scatter || sr.Ray.Direction.Dot(c.hr.GeometricNormal) > 0 {
+					continue
+				}
+				refracted++
+
+				if sr.Specular != (roughness == 0) {
+					t.Fatalf("roughness %v: specular %v", roughness, sr.Specular)
+				}
+				// tinted once, by BaseColor, on the way in
+				a := sr.Attenuation
+				if tinted := approxEqual(a[0], 0.5*a[1], 1e-9); tinted == inside || a[1] != a[2] {
+					t.Fatalf("roughness %v, inside %v: refracted with %v", roughness, inside, a)
+				}
+				if roughness == 0 && !approxVec3(Vec3(a), Vec3(base), 1e-9) && !approxVec3(Vec3(a), Vec3{1, 1, 1}, 1e-9) {
+					t.Fatalf("inside %v: smooth refraction attenuated by %v", inside, a)
+				}
+			}
+			if refracted < 500 {
+				t.Errorf("roughness %v, inside %v: %d refractions in 1000 samples", roughness, inside, refracted)
+			}
+		}
+	}
+
+	// smooth transmission is a delta lobe
+	c := testSphereCase("smooth glass", Principled{BaseColor: base, Transmission: 1}, direction, false)
+	if pdf := c.m.PDF(c.ray, c.hr, direction); pdf != 0 {
+		t.Errorf("smooth transmission has a PDF of %v", pdf)
+	}
+}
+
+func TestPrincipledEmission(t *testing.T) {
+	p := Principled{BaseColor: Color{0.5, 0.5, 0.5}, Emission: Color{2, 1, 0}}
+	ray := Ray{Origin: Point3{0, 0, 3}, Direction: Vec3{0, 0, -1}}
+	front := NewSphere(Point3{0, 0, 0}, 1, p).Hit(ray)
+	back := NewSphere(Point3{0, 0, 0}, 1, p).Hit(Ray{Origin: Point3{0, 0, 0}, Direction: Vec3{0, 0, 1}})
+
+	if !p.Emissive() || p.Emitted(ray, front) != p.Emission {
+		t.Errorf("front face emits %v, want %v", p.Emitted(ray, front), p.Emission)
+	}
+	if c := p.Emitted(ray, back); c != (Color{}) {
+		t.Errorf("back face emits %v, want nothing", c)
+	}
+	if (Principled{}).Emissive() {
+		t.Error("material without emission is emissive")
+	}
+}
+
+func TestPrincipledJSONErrors(t *testing.T) {
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+
+	tests := []struct {
+		material, err string
+	}{
+		{`"metallic": 2`, "must be between 0 and 1"},
+		{`"transmission": -0.5`, "must be between 0 and 1"},
+		{`"roughness": 1.5`, "roughness must be between 0 and 1"},
+		{`"clearcoat_roughness": -1`, "clearcoat roughness must be between 0 and 1"},
+		{`"ior": -1`, "ior can't be negative"},
+	}
+
+	for _, test := range tests {
+		json := `{"version": 1, ` + camera + `, "materials": {"p": {"type": "principled", "base_color": [1, 1, 1], ` + test.material + `}}, "shapes": []}`
+		_, err := DecodeJSONScene(strings.NewReader(json))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.material, err, test.err)
+		}
+	}
+}
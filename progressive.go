@@ -0,0 +1,170 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Accumulator keeps the running estimate of every pixel, so samples can be
+// added in passes and averaged at any time.
+type Accumulator struct {
+	width, height int
+
+	mu     sync.Mutex
+	pixels []pixelEstimate
+}
+
+func NewAccumulator(width, height int) *Accumulator {
+	return &Accumulator{
+		width:  width,
+		height: height,
+		pixels: make([]pixelEstimate, width*height),
+	}
+}
+
+// addTile adds the estimates of every pixel of tile, given row after row.
+func (acc *Accumulator) addTile(tile Tile, estimates []pixelEstimate) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	i := 0
+	for row := tile.Row0; row < tile.Row1; row++ {
+		for col := tile.Col0; col < tile.Col1; col++ {
+			acc.pixels[row*acc.width+col].merge(estimates[i])
+			i++
+		}
+	}
+}
+
+func (acc *Accumulator) estimate(row, col int) pixelEstimate {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	return acc.pixels[row*acc.width+col]
+}
+
+// Samples returns how many samples were added to the pixel at row, col.
+func (acc *Accumulator) Samples(row, col int) int {
+	return acc.estimate(row, col).n
+}
+
+// Resolve writes the current estimate of every pixel into frame. Pixels
+// without samples are left untouched.
+func (acc *Accumulator) Resolve(frame *Frame) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+
+	for row := 0; row < acc.height; row++ {
+		for col := 0; col < acc.width; col++ {
+			e := acc.pixels[row*acc.width+col]
+			if e.n == 0 {
+				continue
+			}
+			frame.content[row][col] = e.mean()
+		}
+	}
+}
+
+// resolveAux writes the average auxiliary outputs of every pixel into the
+// auxiliary frames of settings.
+func (acc *Accumulator) resolveAux(settings *RenderSettings) {
+	if !settings.wantsAux() {
+		return
+	}
+
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	full := Tile{Row1: acc.height, Col1: acc.width}
+	aux := newAuxTile(full)
+	for i := range acc.pixels {
+		aux.add(acc.pixels[i].aux)
+	}
+	aux.set(settings, full)
+}
+
+// ResolveHeatmap writes into frame how many samples every pixel got, relative
+// to max, as a HeatColor.
+func (acc *Accumulator) ResolveHeatmap(frame *Frame, max int) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+
+	for row := 0; row < acc.height; row++ {
+		for col := 0; col < acc.width; col++ {
+			frame.content[row][col] = heatmapColor(acc.pixels[row*acc.width+col].n, max)
+		}
+	}
+}
+
+func (renderer *Renderer) renderProgressive(ctx context.Context, settings RenderSettings, tiles []Tile) (RenderStats, error) {
+	passes := (settings.SamplesPerPixel + settings.SamplesPerPass - 1) / settings.SamplesPerPass
+
+	run := newRenderRun(ctx, settings, renderer.nWorkers, passes*len(tiles))
+	acc := NewAccumulator(settings.Frame.Width(), settings.Frame.Height())
+
+	for pass := 0; pass < passes; pass++ {
+		samplesPerPixel := settings.SamplesPerPass
+		if left := settings.SamplesPerPixel - pass*settings.SamplesPerPass; left < samplesPerPixel {
+			samplesPerPixel = left
+		}
+
+		tilesDone := renderer.dispatch(run, tiles, func(tile Tile, state *RayState) bool {
+			return run.accumulateTile(acc, tile, samplesPerPixel, state)
+		})
+
+		// tiles of an interrupted pass have one more pass worth of samples
+		// than the others, which is fine as pixels are averaged on their own
+		acc.Resolve(settings.Frame)
+		if settings.SampleHeatmap != nil {
+			acc.ResolveHeatmap(settings.SampleHeatmap, settings.SamplesPerPixel)
+		}
+		acc.resolveAux(&settings)
+
+		if tilesDone < len(tiles) {
+			return run.finish(), fmt.Errorf("render stopped in pass %d/%d with %d/%d tiles done: %w", pass+1, passes, tilesDone, len(tiles), ctx.Err())
+		}
+
+		if settings.OnPass != nil {
+			settings.OnPass(pass+1, settings.Frame.Clone())
+		}
+	}
+
+	return run.finish(), nil
+}
+
+// accumulateTile renders samplesPerPixel samples of tile's pixels into acc.
+// With adaptive sampling, converged pixels are skipped.
+func (run *renderRun) accumulateTile(acc *Accumulator, tile Tile, samplesPerPixel int, state *RayState) bool {
+	settings := &run.settings
+
+	estimates := make([]pixelEstimate, 0, tile.Width()*tile.Height())
+	samples := make([]Color, samplesPerPixel)
+
+	for row := tile.Row0; row < tile.Row1; row++ {
+		for col := tile.Col0; col < tile.Col1; col++ {
+			if run.ctx.Err() != nil {
+				return false
+			}
+
+			var e pixelEstimate
+			if previous := acc.estimate(row, col); settings.AdaptiveThreshold <= 0 || !previous.converged(settings) {
+				samplePixel(settings, row, col, previous.n, samples, &e.aux, state)
+				for _, sample := range samples {
+					e.add(sample)
+				}
+			}
+			estimates = append(estimates, e)
+		}
+	}
+
+	acc.addTile(tile, estimates)
+
+	return true
+}
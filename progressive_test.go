@@ -0,0 +1,80 @@
+package tracer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAccumulatorMean(t *testing.T) {
+	acc := NewAccumulator(3, 2)
+	tile := Tile{Row0: 0, Col0: 1, Row1: 2, Col1: 3}
+
+	// K passes of 4 samples, with a pixel's samples summing to pass + pixel
+	const passes, samplesPerPixel = 5, 4
+	for pass := 0; pass < passes; pass++ {
+		estimates := make([]pixelEstimate, 4)
+		for i := range estimates {
+			v := float64(pass+i) / samplesPerPixel
+			for s := 0; s < samplesPerPixel; s++ {
+				estimates[i].add(Color{v, 2 * v, 0})
+			}
+		}
+		acc.addTile(tile, estimates)
+	}
+
+	frame := NewFrame(3, 2, false)
+	frame.Set(0, 0, Color{0.5, 0.5, 0.5})
+	acc.Resolve(frame)
+
+	i := 0
+	for row := tile.Row0; row < tile.Row1; row++ {
+		for col := tile.Col0; col < tile.Col1; col++ {
+			if n := acc.Samples(row, col); n != passes*samplesPerPixel {
+				t.Errorf("(%d, %d): %d samples, want %d", row, col, n, passes*samplesPerPixel)
+			}
+			// sum of pass + i over the passes, over their samples
+			mean := float64(passes*(passes-1)/2+passes*i) / (passes * samplesPerPixel)
+			if got := frame.Get(row, col); !approxVec3(Vec3(got), Vec3{mean, 2 * mean, 0}, testEpsilon) {
+				t.Errorf("(%d, %d): mean %v, want %v", row, col, got, mean)
+			}
+			i++
+		}
+	}
+
+	if got := frame.Get(0, 0); got != (Color{0.5, 0.5, 0.5}) || acc.Samples(0, 0) != 0 {
+		t.Errorf("pixel without samples changed to %v", got)
+	}
+}
+
+func TestRenderProgressive(t *testing.T) {
+	renderer := testRenderer(t, 2)
+
+	var samples uint64
+	settings := testFlatSettings(9, 5, func(*RayState) {
+		atomic.AddUint64(&samples, 1)
+	})
+	settings.SamplesPerPixel = 10
+	settings.SamplesPerPass = 3
+
+	var passes []int
+	settings.OnPass = func(pass int, snapshot *Frame) {
+		passes = append(passes, pass)
+		if snapshot == settings.Frame || snapshot.Get(4, 8) != (Color{1, 1, 1}) {
+			t.Errorf("pass %d: snapshot isn't a copy of the resolved frame", pass)
+		}
+	}
+
+	stats, err := renderer.RenderContext(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 + 3 + 3 + 1 samples
+	if len(passes) != 4 || passes[0] != 1 || passes[3] != 4 {
+		t.Errorf("passes %v, want 1 to 4", passes)
+	}
+	if want := uint64(9 * 5 * 10); samples != want || stats.PrimaryRays != want {
+		t.Errorf("%d samples and %d primary rays, want %d", samples, stats.PrimaryRays, want)
+	}
+}
@@ -0,0 +1,56 @@
+package tracer
+
+// Rand is a small, fast and seedable pseudo-random number generator
+// (SplitMix64). The IndependentSampler seeds one per camera sample, from
+// RenderSettings.Seed, the sample's pixel and its index, so an image depends
+// on the scene and the seed only, not on how samples were spread over workers.
+type Rand struct {
+	state uint64
+}
+
+func NewRand(seed uint64) *Rand {
+	return &Rand{state: seed}
+}
+
+func (r *Rand) Seed(seed uint64) {
+	r.state = seed
+}
+
+func (r *Rand) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	return mix64(r.state)
+}
+
+// Float64 returns a number in [0, 1).
+func (r *Rand) Float64() float64 {
+	return float64(r.Uint64()>>11) * (1.0 / (1 << 53))
+}
+
+// Intn returns a number in [0, n).
+func (r *Rand) Intn(n int) int {
+	return int(r.Float64() * float64(n))
+}
+
+// Range returns a number in [min, max).
+func (r *Rand) Range(min, max float64) float64 {
+	return r.Float64()*(max-min) + min
+}
+
+// mix64 is the SplitMix64 finalizer, a good 64 bit hash.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// pixelSeed is the seed of a pixel of a render seeded with seed.
+func pixelSeed(seed uint64, row, col int) uint64 {
+	h := mix64(seed ^ 0x6a09e667f3bcc909)
+	h = mix64(h ^ uint64(row))
+	return mix64(h ^ uint64(col))
+}
+
+// sampleSeed is the seed of a camera sample of a render seeded with seed.
+func sampleSeed(seed uint64, row, col, sample int) uint64 {
+	return mix64(pixelSeed(seed, row, col) ^ uint64(sample))
+}
@@ -0,0 +1,92 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+)
+
+// testSpheres is a small scene with every material, ready to render.
+func testSpheres(t *testing.T) RenderSettings {
+	t.Helper()
+	bvh, err := NewBVHNode(HitterList{
+		NewPlane(Point3{0, -0.5, 0}, Vec3{0, 1, 0}, Lambertian{Albedo: Color{0.5, 0.5, 0.5}}),
+		NewSphere(Point3{-1, 0, -1}, 0.5, Dielectric{RefractiveIndex: 1.5}),
+		NewSphere(Point3{0, 0, -1}, 0.5, Lambertian{Albedo: Color{0.1, 0.2, 0.5}}),
+		NewSphere(Point3{1, 0, -1}, 0.5, Metal{Albedo: Color{0.8, 0.6, 0.2}, Fuzz: 0.3}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return RenderSettings{
+		Frame:           NewFrame(24, 16, false),
+		Camera:          &Camera{AspectRatio: 1.5, VFoV: 60, LookFrom: Point3{0, 0.5, 1}, LookAt: Point3{0, 0, -1}, VUp: Vec3{0, 1, 0}},
+		Hitter:          bvh,
+		SamplesPerPixel: 8,
+		MaxDepth:        8,
+		Integrator:      &PathIntegrator{},
+		AggColorFunc:    AvgSamples,
+		Seed:            42,
+	}
+}
+
+func TestRenderReproducible(t *testing.T) {
+	configs := map[string]func(*RenderSettings){
+		"rows": func(*RenderSettings) {},
+		"hilbert tiles": func(settings *RenderSettings) {
+			settings.TileOrder = TileOrderHilbert
+			settings.TileSize = 5
+		},
+		"stratified": func(settings *RenderSettings) {
+			settings.Sampler = SamplerStratified
+		},
+		"sobol": func(settings *RenderSettings) {
+			settings.Sampler = SamplerSobol
+		},
+		"progressive adaptive": func(settings *RenderSettings) {
+			settings.SamplesPerPixel = 32
+			settings.SamplesPerPass = 6
+			settings.AdaptiveThreshold = 0.05
+			settings.MinSamplesPerPixel = 4
+		},
+	}
+
+	for name, config := range configs {
+		var frames []*Frame
+		for _, nWorkers := range []int{1, 4} {
+			settings := testSpheres(t)
+			config(&settings)
+			if _, err := testRenderer(t, nWorkers).RenderContext(context.Background(), settings); err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, settings.Frame)
+		}
+
+		for row := 0; row < frames[0].Height(); row++ {
+			for col := 0; col < frames[0].Width(); col++ {
+				if a, b := frames[0].Get(row, col), frames[1].Get(row, col); a != b {
+					t.Fatalf("%s: (%d, %d) is %v with 1 worker and %v with 4", name, row, col, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestRandSeed(t *testing.T) {
+	a, b := NewRand(7), NewRand(7)
+	for i := 0; i < 100; i++ {
+		if a.Uint64() != b.Uint64() {
+			t.Fatal("equally seeded Rands diverge")
+		}
+	}
+	if sampleSeed(1, 2, 3, 4) == sampleSeed(1, 2, 3, 5) || sampleSeed(1, 2, 3, 4) == sampleSeed(2, 2, 3, 4) {
+		t.Error("sample seeds collide")
+	}
+
+	r := NewRand(1)
+	for i := 0; i < 1000; i++ {
+		if f := r.Float64(); f < 0 || f >= 1 {
+			t.Fatalf("Float64 %v out of [0, 1)", f)
+		}
+	}
+}
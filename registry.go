@@ -0,0 +1,196 @@
+package tracer
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Scene types are registered by name for the scene codecs, and with gob
+// under their Go type name so older gob scenes still decode.
+var (
+	hitters   = newRegistry("hitter")
+	materials = newRegistry("material")
+	lights    = newRegistry("light")
+	textures  = newRegistry("texture")
+)
+
+func init() {
+	RegisterHitter("sphere", func() Hitter { return &Sphere{} })
+	RegisterHitter("plane", func() Hitter { return &Plane{} })
+	RegisterHitter("triangle", func() Hitter { return &Triangle{} })
+	RegisterHitter("mesh", func() Hitter { return &TriangleMesh{} })
+
+	RegisterMaterial("lambertian", func() Material { return Lambertian{} })
+	RegisterMaterial("metal", func() Material { return Metal{} })
+	RegisterMaterial("dielectric", func() Material { return Dielectric{} })
+	RegisterMaterial("conductor", func() Material { return Conductor{} })
+	RegisterMaterial("principled", func() Material { return Principled{} })
+	RegisterMaterial("diffuse_light", func() Material { return DiffuseLight{} })
+	RegisterMaterial("normal_mapped", func() Material { return NormalMapped{} })
+	RegisterMaterial("mix", func() Material { return Mix{} })
+	RegisterMaterial("coated", func() Material { return Coated{} })
+
+	RegisterLight("point", func() Light { return PointLight{} })
+	RegisterLight("spot", func() Light { return SpotLight{} })
+	RegisterLight("directional", func() Light { return DirectionalLight{} })
+	RegisterLight("sphere", func() Light { return &SphereLight{} })
+	RegisterLight("rect", func() Light { return &RectLight{} })
+
+	RegisterTexture("constant", func() Texture { return ConstantTexture{} })
+	RegisterTexture("checker", func() Texture { return CheckerTexture{} })
+	RegisterTexture("scaled", func() Texture { return ScaledTexture{} })
+	RegisterTexture("channel", func() Texture { return ChannelTexture{} })
+	RegisterTexture("image", func() Texture { return &ImageTexture{} })
+	RegisterTexture("noise", func() Texture { return NoiseTexture{} })
+	RegisterTexture("turbulence", func() Texture { return TurbulenceTexture{} })
+	RegisterTexture("marble", func() Texture { return MarbleTexture{} })
+}
+
+// RegisterHitter registers a Hitter type under name. new returns a zero
+// value of the type, as a pointer if its methods need one. It panics if the
+// name or the type is already registered.
+func RegisterHitter(name string, new func() Hitter) {
+	hitters.register(name, func() interface{} { return new() })
+}
+
+// RegisterMaterial registers a Material type under name, see RegisterHitter.
+func RegisterMaterial(name string, new func() Material) {
+	materials.register(name, func() interface{} { return new() })
+}
+
+// RegisterLight registers a Light type under name, see RegisterHitter.
+func RegisterLight(name string, new func() Light) {
+	lights.register(name, func() interface{} { return new() })
+}
+
+// RegisterTexture registers a Texture type under name, see RegisterHitter.
+func RegisterTexture(name string, new func() Texture) {
+	textures.register(name, func() interface{} { return new() })
+}
+
+// NewHitter returns a zero value of the Hitter registered under name.
+func NewHitter(name string) (Hitter, error) {
+	v, err := hitters.new(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.(Hitter), nil
+}
+
+// NewMaterial returns a zero value of the Material registered under name.
+func NewMaterial(name string) (Material, error) {
+	v, err := materials.new(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.(Material), nil
+}
+
+// NewLight returns a zero value of the Light registered under name.
+func NewLight(name string) (Light, error) {
+	v, err := lights.new(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.(Light), nil
+}
+
+// NewTexture returns a zero value of the Texture registered under name.
+func NewTexture(name string) (Texture, error) {
+	v, err := textures.new(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.(Texture), nil
+}
+
+// HitterTypeName returns the name h's type is registered under.
+func HitterTypeName(h Hitter) (string, bool) {
+	return hitters.name(h)
+}
+
+// MaterialTypeName returns the name m's type is registered under.
+func MaterialTypeName(m Material) (string, bool) {
+	return materials.name(m)
+}
+
+// LightTypeName returns the name l's type is registered under.
+func LightTypeName(l Light) (string, bool) {
+	return lights.name(l)
+}
+
+// TextureTypeName returns the name t's type is registered under.
+func TextureTypeName(t Texture) (string, bool) {
+	return textures.name(t)
+}
+
+type registry struct {
+	kind string
+
+	mu     sync.RWMutex
+	byName map[string]func() interface{}
+	// keyed by the non-pointer type, so values and pointers share a name
+	byType map[reflect.Type]string
+}
+
+func newRegistry(kind string) *registry {
+	return &registry{
+		kind:   kind,
+		byName: map[string]func() interface{}{},
+		byType: map[reflect.Type]string{},
+	}
+}
+
+func baseType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (r *registry) register(name string, new func() interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v := new()
+	t := baseType(v)
+
+	if _, ok := r.byName[name]; ok {
+		panic(fmt.Sprintf("tracer: %s type %q registered twice", r.kind, name))
+	}
+	if other, ok := r.byType[t]; ok {
+		panic(fmt.Sprintf("tracer: %s type %s already registered as %q", r.kind, t, other))
+	}
+
+	r.byName[name] = new
+	r.byType[t] = name
+
+	// the name gob.Register would use for a value of the type
+	gob.RegisterName(t.PkgPath()+"."+t.Name(), v)
+}
+
+func (r *registry) new(name string) (interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	new, ok := r.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s type %q", r.kind, name)
+	}
+	return new(), nil
+}
+
+func (r *registry) name(v interface{}) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.byType[baseType(v)]
+	return name, ok
+}
@@ -1,7 +1,10 @@
 package tracer
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 )
 
 type Renderer struct {
@@ -25,7 +28,7 @@ func (renderer *Renderer) Start() {
 	defer renderer.mu.Unlock()
 
 	for i := 0; i < renderer.nWorkers; i++ {
-		go Worker(renderer.jobs, renderer.done)
+		go Worker(i, renderer.jobs, renderer.done)
 	}
 }
 
@@ -33,48 +36,251 @@ func (renderer *Renderer) Stop() {
 	close(renderer.done)
 }
 
+// Render renders settings.Frame until it's done or something is sent on (or
+// closes) stop. See RenderContext.
 func (renderer *Renderer) Render(settings RenderSettings, stop <-chan bool) {
-	wg := sync.WaitGroup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	renderer.RenderContext(ctx, settings)
+}
 
+// RenderContext renders settings.Frame, one job per tile (see TileOrder). When
+// ctx is done, no more tiles are dispatched and tiles being rendered are
+// abandoned. Tiles are written to the frame only once complete, so a canceled
+// render leaves each tile either fully rendered or untouched, and returns an
+// error wrapping ctx.Err() saying how many tiles were rendered.
+//
+// When settings.SamplesPerPass is set, the frame is rendered progressively,
+// see RenderSettings.
+func (renderer *Renderer) RenderContext(ctx context.Context, settings RenderSettings) (RenderStats, error) {
 	width := settings.Frame.Width()
 	height := settings.Frame.Height()
 
-	for row := 0; row < height; row++ {
-		row := row
+	tiles := Tiles(width, height, settings.TileSize, settings.TileOrder)
+
+	if err := settings.prepareIntegrator(); err != nil {
+		return RenderStats{}, err
+	}
+
+	if settings.SamplesPerPass > 0 {
+		return renderer.renderProgressive(ctx, settings, tiles)
+	}
+
+	run := newRenderRun(ctx, settings, renderer.nWorkers, len(tiles))
+	tilesDone := renderer.dispatch(run, tiles, run.renderTile)
+	stats := run.finish()
+
+	if tilesDone < len(tiles) {
+		return stats, fmt.Errorf("render stopped with %d/%d tiles done: %w", tilesDone, len(tiles), ctx.Err())
+	}
+
+	return stats, nil
+}
+
+// prepareIntegrator prepares settings.Integrator, PathIntegrator by default,
+// with the scene of the render.
+func (settings *RenderSettings) prepareIntegrator() error {
+	if settings.Integrator == nil {
+		settings.Integrator = &PathIntegrator{}
+	}
+
+	scene := RenderScene{
+		Hitter:     settings.Hitter,
+		Lights:     append(CollectLights(settings.Hitter), settings.Lights...),
+		Background: settings.Background,
+		MaxDepth:   settings.MaxDepth,
+	}
+	if scene.Background == nil {
+		scene.Background = DefaultBackground
+	}
+
+	if err := settings.Integrator.Prepare(scene); err != nil {
+		return fmt.Errorf("preparing integrator: %w", err)
+	}
+	return nil
+}
+
+// renderRun is the state shared by the jobs of a render.
+type renderRun struct {
+	ctx      context.Context
+	settings RenderSettings
+	start    time.Time
+
+	// guards stats (but WorkerTime, which each worker owns an entry of) and
+	// jobsDone
+	mu                  sync.Mutex
+	stats               RenderStats
+	jobsDone, jobsTotal int
+}
+
+func newRenderRun(ctx context.Context, settings RenderSettings, nWorkers, jobsTotal int) *renderRun {
+	return &renderRun{
+		ctx:       ctx,
+		settings:  settings,
+		start:     time.Now(),
+		stats:     RenderStats{WorkerTime: make([]time.Duration, nWorkers)},
+		jobsTotal: jobsTotal,
+	}
+}
+
+func (run *renderRun) finish() RenderStats {
+	run.stats.Elapsed = time.Since(run.start)
+	return run.stats
+}
+
+// dispatch runs renderTile for every tile, one job each, until run.ctx is
+// done, and waits for the jobs to finish. renderTile returns whether it
+// completed the tile, dispatch how many tiles were completed.
+func (renderer *Renderer) dispatch(run *renderRun, tiles []Tile, renderTile func(Tile, *RayState) bool) int {
+	wg := sync.WaitGroup{}
+	tilesDone := 0
+
+dispatch:
+	for _, tile := range tiles {
+		tile := tile
 		wg.Add(1)
 
-		renderer.jobs <- func() {
-			for col := 0; col < width; col++ {
-				col := col
-				samples := make([]Color, settings.SamplesPerPixel)
-				for s := 0; s < settings.SamplesPerPixel; s++ {
-					u, v := JitteredCameraCoordinatesFromPixel(row, col, width, height)
-					r := settings.Camera.GetRay(u, v)
-					samples[s] = settings.RayColorFunc(r, settings.Hitter, settings.MaxDepth, 0)
-				}
+		job := func(worker int) {
+			defer wg.Done()
 
-				settings.Frame.Set(row, col, settings.AggColorFunc(samples))
+			jobStart := time.Now()
+			state := RayState{
+				Sampler: NewSampler(run.settings.Sampler, run.settings.Seed, run.settings.SamplesPerPixel),
 			}
+			completed := false
+
+			// account for abandoned tiles too, their rays were traced all the same
+			defer func() {
+				run.stats.WorkerTime[worker] += time.Since(jobStart)
+
+				run.mu.Lock()
+				defer run.mu.Unlock()
+
+				run.stats.PrimaryRays += state.PrimaryRays
+				run.stats.Rays += state.Rays
+				run.stats.NodeVisits += state.NodeVisits
+
+				if !completed {
+					return
+				}
+
+				tilesDone++
+				run.jobsDone++
+				if run.settings.Progress != nil {
+					run.settings.Progress(newProgress(run.jobsDone, run.jobsTotal, time.Since(run.start)))
+				}
+			}()
+
+			completed = renderTile(tile, &state)
+		}
+
+		select {
+		case renderer.jobs <- job:
+		case <-run.ctx.Done():
 			wg.Done()
+			break dispatch
 		}
 	}
 
-	// TODO: won't stop on `stop`
 	wg.Wait()
+
+	return tilesDone
 }
 
-func Worker(in chan Job, done chan struct{}) {
+// renderTile renders all the samples of tile's pixels into the frame.
+func (run *renderRun) renderTile(tile Tile, state *RayState) bool {
+	settings := &run.settings
+	adaptive := settings.AdaptiveThreshold > 0
+
+	colors := make([]Color, 0, tile.Width()*tile.Height())
+	samples := make([]Color, settings.SamplesPerPixel)
+	var heat []Color
+	if adaptive && settings.SampleHeatmap != nil {
+		heat = make([]Color, 0, tile.Width()*tile.Height())
+	}
+	var aux *auxTile
+	if settings.wantsAux() {
+		aux = newAuxTile(tile)
+	}
+
+	for row := tile.Row0; row < tile.Row1; row++ {
+		for col := tile.Col0; col < tile.Col1; col++ {
+			if run.ctx.Err() != nil {
+				return false
+			}
+
+			if !adaptive {
+				var a auxEstimate
+				samplePixel(settings, row, col, 0, samples, &a, state)
+				colors = append(colors, settings.AggColorFunc(samples))
+				if aux != nil {
+					aux.add(a)
+				}
+				continue
+			}
+
+			e := sampleAdaptive(settings, row, col, samples, state)
+			colors = append(colors, e.mean())
+			if aux != nil {
+				aux.add(e.aux)
+			}
+			if heat != nil {
+				heat = append(heat, heatmapColor(e.n, settings.SamplesPerPixel))
+			}
+		}
+	}
+
+	settings.Frame.SetTile(tile, colors)
+	if heat != nil {
+		settings.SampleHeatmap.SetTile(tile, heat)
+	}
+	if aux != nil {
+		aux.set(settings, tile)
+	}
+
+	return true
+}
+
+// samplePixel traces len(samples) camera rays through the pixel at row, col,
+// adding their auxiliary outputs to aux. first is the index of the first of
+// these samples among all the samples of the pixel.
+func samplePixel(settings *RenderSettings, row, col, first int, samples []Color, aux *auxEstimate, state *RayState) {
+	width := settings.Frame.Width()
+	height := settings.Frame.Height()
+
+	for s := range samples {
+		state.Sampler.StartSample(row, col, first+s)
+		u, v := JitteredCameraCoordinatesFromPixel(row, col, width, height, state.Sampler)
+		r := settings.Camera.GetRay(u, v)
+		sample := settings.Integrator.Li(r, state)
+		samples[s] = sample.Radiance
+		aux.add(sample)
+	}
+	state.PrimaryRays += uint64(len(samples))
+}
+
+func Worker(id int, in chan Job, done chan struct{}) {
 	for {
 		select {
 		case job := <-in:
-			job()
+			job(id)
 		case <-done:
 			return
 		}
 	}
 }
 
-type Job func()
+// Job is run by the worker with the given id.
+type Job func(worker int)
 
 type RenderSettings struct {
 	Frame           *Frame
@@ -82,6 +288,92 @@ type RenderSettings struct {
 	Hitter          Hitter
 	SamplesPerPixel int
 	MaxDepth        int
-	RayColorFunc    RayColorFunc
-	AggColorFunc    AggColorFunc
+	// Integrator renders the samples, PathIntegrator by default. It's
+	// prepared by the render, see Integrator.
+	Integrator   Integrator
+	AggColorFunc AggColorFunc
+	// Background is the light of rays escaping the scene, DefaultBackground
+	// by default.
+	Background Background
+	// Lights are sampled directly by integrators doing so (e.g.
+	// MISIntegrator), along with the area lights of Hitter's emissive shapes
+	// (see CollectLights). Lights that are Hitters too, e.g. SphereLight, must
+	// also be part of Hitter for rays to see them.
+	Lights []Light
+	// Seed of the random numbers of the render. A scene rendered with the same
+	// seed and settings always gives the same image.
+	Seed uint64
+	// Sampler is the kind of sampler providing the random numbers of the
+	// render, SamplerIndependent by default.
+	Sampler SamplerKind
+	// Progressive rendering: when set, SamplesPerPixel are rendered in passes
+	// of SamplesPerPass samples, accumulated per pixel. Frame is updated with
+	// the estimate after every pass, and OnPass (if set) called with a copy of
+	// it. Samples are averaged, AggColorFunc isn't used.
+	SamplesPerPass int
+	OnPass         func(pass int, snapshot *Frame)
+	// Adaptive sampling: when AdaptiveThreshold is set, a pixel stops being
+	// sampled once the standard error of its mean luminance, relative to the
+	// mean, drops below it. Pixels get at least MinSamplesPerPixel (or
+	// DefaultMinSamplesPerPixel) and at most SamplesPerPixel samples, which
+	// are averaged (AggColorFunc isn't used). Adaptive sampling works on its
+	// own or along progressive rendering.
+	AdaptiveThreshold  float64
+	MinSamplesPerPixel int
+	// SampleHeatmap, when set with adaptive sampling, gets how many samples
+	// every pixel took, as a HeatColor. It must be the size of Frame.
+	SampleHeatmap *Frame
+	// Auxiliary frames, when set, get the average auxiliary outputs of the
+	// integrator for every pixel (see IntegratorSample): its albedo, unit
+	// normal, and depth (in every channel). Values aren't remapped, normals
+	// range from -1 to 1. They must be the size of Frame.
+	AlbedoFrame *Frame
+	NormalFrame *Frame
+	DepthFrame  *Frame
+	// How the frame is split into jobs. TileSize defaults to DefaultTileSize
+	// and is ignored by TileOrderRows, the default order.
+	TileOrder TileOrder
+	TileSize  int
+	// Progress, when set, is called every time a job completes. Calls come
+	// from the render workers, but never concurrently.
+	Progress ProgressFunc
+}
+
+type ProgressFunc func(Progress)
+
+// Progress of a render, in jobs (i.e. tiles, of every pass when rendering
+// progressively).
+type Progress struct {
+	Done, Total int
+	Elapsed     time.Duration
+	// ETA is the estimated time left, extrapolated from the elapsed time
+	ETA time.Duration
+}
+
+func newProgress(done, total int, elapsed time.Duration) Progress {
+	p := Progress{Done: done, Total: total, Elapsed: elapsed}
+	if done > 0 {
+		p.ETA = time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	}
+	return p
+}
+
+// RenderStats are counters of the work done by a render.
+type RenderStats struct {
+	// Camera rays
+	PrimaryRays uint64
+	// All rays traced, camera rays included
+	Rays       uint64
+	NodeVisits uint64
+	// Wall time of the render
+	Elapsed time.Duration
+	// Time each worker spent rendering, indexed by worker id
+	WorkerTime []time.Duration
+}
+
+func (stats RenderStats) RaysPerSecond() float64 {
+	if stats.Elapsed <= 0 {
+		return 0
+	}
+	return float64(stats.Rays) / stats.Elapsed.Seconds()
 }
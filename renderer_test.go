@@ -0,0 +1,196 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRenderer starts a renderer with nWorkers, stopped when the test ends.
+func testRenderer(t *testing.T, nWorkers int) *Renderer {
+	t.Helper()
+	renderer := NewRenderer(nWorkers)
+	renderer.Start()
+	t.Cleanup(renderer.Stop)
+	return renderer
+}
+
+// testIntegrator renders every sample with its func, without tracing
+// anything.
+type testIntegrator func(state *RayState) Color
+
+func (testIntegrator) Prepare(RenderScene) error {
+	return nil
+}
+
+func (f testIntegrator) Li(_ Ray, state *RayState) IntegratorSample {
+	return IntegratorSample{Radiance: f(state)}
+}
+
+// testFlatSettings renders a white frame without tracing anything. rayColor,
+// if not nil, is called for every sample first.
+func testFlatSettings(width, height int, rayColor func(*RayState)) RenderSettings {
+	camera := Camera{AspectRatio: 1, VFoV: 90, LookFrom: Point3{0, 0, 1}, VUp: Vec3{0, 1, 0}}
+	camera.GetRay(0, 0)
+	return RenderSettings{
+		Frame:           NewFrame(width, height, false),
+		Camera:          &camera,
+		SamplesPerPixel: 2,
+		Integrator: testIntegrator(func(state *RayState) Color {
+			if rayColor != nil {
+				rayColor(state)
+			}
+			return Color{1, 1, 1}
+		}),
+		AggColorFunc: AvgSamples,
+	}
+}
+
+// renderedRows counts the rows of frame that were written.
+func renderedRows(frame *Frame) int {
+	var rows int
+	for row := 0; row < frame.Height(); row++ {
+		if frame.Get(row, 0) != (Color{}) {
+			rows++
+		}
+	}
+	return rows
+}
+
+func TestRenderStop(t *testing.T) {
+	renderer := testRenderer(t, 2)
+	// let the workers settle before counting goroutines
+	time.Sleep(10 * time.Millisecond)
+	goroutines := runtime.NumGoroutine()
+
+	const width, height = 16, 64
+	stop := make(chan bool)
+	var (
+		mu      sync.Mutex
+		samples int
+	)
+	settings := testFlatSettings(width, height, func(*RayState) {
+		mu.Lock()
+		samples++
+		stopped := samples > width*2
+		if samples == width*2 {
+			// a row's worth of samples is in
+			close(stop)
+		}
+		mu.Unlock()
+		if stopped {
+			// give Render a chance to notice
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	returned := make(chan struct{})
+	go func() {
+		renderer.Render(settings, stop)
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Render didn't return after stop was closed")
+	}
+
+	if rows := renderedRows(settings.Frame); rows >= height/2 {
+		t.Errorf("%d/%d rows rendered after stopping on the first one", rows, height)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines after Render returned, want %d", n, goroutines)
+	}
+}
+
+func TestRenderComplete(t *testing.T) {
+	renderer := testRenderer(t, 2)
+
+	settings := testFlatSettings(5, 7, nil)
+	renderer.Render(settings, make(chan bool))
+
+	if rows := renderedRows(settings.Frame); rows != 7 {
+		t.Errorf("%d/7 rows rendered", rows)
+	}
+}
+
+func TestRenderContextCanceled(t *testing.T) {
+	renderer := testRenderer(t, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	settings := testFlatSettings(5, 7, nil)
+	if _, err := renderer.RenderContext(ctx, settings); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want it to wrap context.Canceled", err)
+	}
+	if rows := renderedRows(settings.Frame); rows != 0 {
+		t.Errorf("%d rows rendered with a canceled context", rows)
+	}
+}
+
+func TestRenderStats(t *testing.T) {
+	const nWorkers, width, height = 3, 6, 9
+	renderer := testRenderer(t, nWorkers)
+
+	var samples uint64
+	settings := testFlatSettings(width, height, func(state *RayState) {
+		atomic.AddUint64(&samples, 1)
+		// a camera ray and two bounces
+		state.Rays += 3
+		state.NodeVisits += 5
+	})
+
+	var progress []Progress
+	settings.Progress = func(p Progress) {
+		progress = append(progress, p)
+	}
+
+	stats, err := renderer.RenderContext(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := uint64(width * height * settings.SamplesPerPixel)
+	if samples != want || stats.PrimaryRays != samples {
+		t.Errorf("%d primary rays for %d samples, want %d", stats.PrimaryRays, samples, want)
+	}
+	if stats.Rays != 3*samples || stats.NodeVisits != 5*samples {
+		t.Errorf("%d rays and %d node visits, want %d and %d", stats.Rays, stats.NodeVisits, 3*samples, 5*samples)
+	}
+	if len(stats.WorkerTime) != nWorkers {
+		t.Errorf("times for %d workers, want %d", len(stats.WorkerTime), nWorkers)
+	}
+	var worked time.Duration
+	for _, d := range stats.WorkerTime {
+		worked += d
+	}
+	if worked <= 0 || stats.Elapsed <= 0 {
+		t.Errorf("workers busy for %v in %v", worked, stats.Elapsed)
+	}
+
+	if len(progress) != height {
+		t.Fatalf("%d progress calls, want one per row", len(progress))
+	}
+	for i, p := range progress {
+		if p.Done != i+1 || p.Total != height {
+			t.Errorf("call %d: progress %d/%d, want %d/%d", i, p.Done, p.Total, i+1, height)
+		}
+		if i > 0 && p.Elapsed < progress[i-1].Elapsed {
+			t.Errorf("call %d: elapsed %v went back from %v", i, p.Elapsed, progress[i-1].Elapsed)
+		}
+	}
+	if last := progress[len(progress)-1]; last.ETA != 0 {
+		t.Errorf("ETA %v when done", last.ETA)
+	}
+}
@@ -0,0 +1,336 @@
+package tracer
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Sampler provides the random numbers of the camera samples of a render,
+// one dimension after the other: the camera, materials and lights take the
+// next 1D or 2D sample whenever they need one. Samplers other than the
+// independent one spread the samples of a pixel over each dimension more
+// evenly than independent random numbers, which converges faster.
+//
+// A Sampler is used by a single worker at a time.
+type Sampler interface {
+	// StartSample starts the index-th sample of the pixel at row, col, going
+	// back to the first dimension.
+	StartSample(row, col, index int)
+	// Get1D returns the next dimension, in [0, 1).
+	Get1D() float64
+	// Get2D returns the next two dimensions, in [0, 1).
+	Get2D() (float64, float64)
+}
+
+type SamplerKind int
+
+const (
+	SamplerIndependent SamplerKind = iota
+	SamplerStratified
+	SamplerHalton
+	SamplerSobol
+)
+
+func (kind SamplerKind) String() string {
+	switch kind {
+	case SamplerIndependent:
+		return "independent"
+	case SamplerStratified:
+		return "stratified"
+	case SamplerHalton:
+		return "halton"
+	case SamplerSobol:
+		return "sobol"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseSamplerKind(s string) (SamplerKind, error) {
+	for kind := SamplerIndependent; kind <= SamplerSobol; kind++ {
+		if kind.String() == s {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sampler %q", s)
+}
+
+// NewSampler returns a sampler of the given kind, for a render with the given
+// seed and samples per pixel.
+func NewSampler(kind SamplerKind, seed uint64, samplesPerPixel int) Sampler {
+	switch kind {
+	case SamplerStratified:
+		return NewStratifiedSampler(seed, samplesPerPixel)
+	case SamplerHalton:
+		return NewHaltonSampler(seed)
+	case SamplerSobol:
+		return NewSobolSampler(seed)
+	default:
+		return NewIndependentSampler(seed)
+	}
+}
+
+// samplerState is what every sampler keeps about the current sample.
+type samplerState struct {
+	seed uint64
+	// hash of seed and the pixel
+	pixel     uint64
+	index     int
+	dimension int
+}
+
+func (s *samplerState) start(row, col, index int) {
+	s.pixel = pixelSeed(s.seed, row, col)
+	s.index = index
+	s.dimension = 0
+}
+
+// next returns a hash of the pixel and the next dimension, and moves to it.
+func (s *samplerState) next() uint64 {
+	h := mix64(s.pixel ^ mix64(uint64(s.dimension)+1))
+	s.dimension++
+	return h
+}
+
+// IndependentSampler returns uniform random numbers.
+type IndependentSampler struct {
+	seed uint64
+	rand Rand
+}
+
+func NewIndependentSampler(seed uint64) *IndependentSampler {
+	return &IndependentSampler{seed: seed}
+}
+
+func (s *IndependentSampler) StartSample(row, col, index int) {
+	s.rand.Seed(sampleSeed(s.seed, row, col, index))
+}
+
+func (s *IndependentSampler) Get1D() float64 {
+	return s.rand.Float64()
+}
+
+func (s *IndependentSampler) Get2D() (float64, float64) {
+	return s.rand.Float64(), s.rand.Float64()
+}
+
+// StratifiedSampler splits every dimension in as many strata as there are
+// samples per pixel (a grid of about sqrt(n) x sqrt(n) for 2D dimensions),
+// and places each sample in a random position of a different stratum. The
+// strata are shuffled independently per dimension.
+type StratifiedSampler struct {
+	samplerState
+	samplesPerPixel int
+	// 2D grid
+	nx, ny int
+}
+
+func NewStratifiedSampler(seed uint64, samplesPerPixel int) *StratifiedSampler {
+	if samplesPerPixel < 1 {
+		samplesPerPixel = 1
+	}
+	nx := int(math.Ceil(math.Sqrt(float64(samplesPerPixel))))
+	ny := (samplesPerPixel + nx - 1) / nx
+
+	return &StratifiedSampler{
+		samplerState:    samplerState{seed: seed},
+		samplesPerPixel: samplesPerPixel,
+		nx:              nx,
+		ny:              ny,
+	}
+}
+
+func (s *StratifiedSampler) StartSample(row, col, index int) {
+	s.start(row, col, index%s.samplesPerPixel)
+}
+
+func (s *StratifiedSampler) Get1D() float64 {
+	h := s.next()
+	stratum := permute(uint32(s.index), uint32(s.samplesPerPixel), uint32(h))
+	jitter := hashFloat(h, uint64(s.index))
+	return (float64(stratum) + jitter) / float64(s.samplesPerPixel)
+}
+
+func (s *StratifiedSampler) Get2D() (float64, float64) {
+	h := s.next()
+	stratum := int(permute(uint32(s.index), uint32(s.nx*s.ny), uint32(h)))
+	x := (float64(stratum%s.nx) + hashFloat(h, 2*uint64(s.index))) / float64(s.nx)
+	y := (float64(stratum/s.nx) + hashFloat(h, 2*uint64(s.index)+1)) / float64(s.ny)
+	return x, y
+}
+
+// HaltonSampler uses the Halton sequence, randomized per pixel with a random
+// (Cranley-Patterson) rotation of every dimension. Past the first
+// len(haltonPrimes) dimensions, it returns independent random numbers.
+type HaltonSampler struct {
+	samplerState
+}
+
+var haltonPrimes = [...]uint64{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+}
+
+func NewHaltonSampler(seed uint64) *HaltonSampler {
+	return &HaltonSampler{samplerState{seed: seed}}
+}
+
+func (s *HaltonSampler) StartSample(row, col, index int) {
+	s.start(row, col, index)
+}
+
+func (s *HaltonSampler) Get1D() float64 {
+	dimension := s.dimension
+	h := s.next()
+	if dimension >= len(haltonPrimes) {
+		return hashFloat(h, uint64(s.index))
+	}
+
+	x := radicalInverse(haltonPrimes[dimension], uint64(s.index)) + hashFloat(h, 0)
+	if x >= 1 {
+		x--
+	}
+	return math.Min(x, oneMinusEpsilon)
+}
+
+func (s *HaltonSampler) Get2D() (float64, float64) {
+	return s.Get1D(), s.Get1D()
+}
+
+// SobolSampler uses the first two dimensions of the Sobol sequence for every
+// 2D sample (and the first one for 1D samples), with hash based Owen
+// scrambling. The order of the points is shuffled per dimension, so
+// dimensions don't correlate with each other (see Burley, "Practical Hash-based
+// Owen Scrambling", 2020).
+type SobolSampler struct {
+	samplerState
+}
+
+func NewSobolSampler(seed uint64) *SobolSampler {
+	return &SobolSampler{samplerState{seed: seed}}
+}
+
+func (s *SobolSampler) StartSample(row, col, index int) {
+	s.start(row, col, index)
+}
+
+func (s *SobolSampler) Get1D() float64 {
+	h := s.next()
+	index := nestedUniformScramble(uint32(s.index), uint32(h))
+	return sobolFloat(nestedUniformScramble(bits.Reverse32(index), uint32(h>>32)))
+}
+
+func (s *SobolSampler) Get2D() (float64, float64) {
+	h := s.next()
+	index := nestedUniformScramble(uint32(s.index), uint32(h))
+	x := nestedUniformScramble(bits.Reverse32(index), uint32(h>>32))
+	y := nestedUniformScramble(sobolDimension1(index), uint32(mix64(h)))
+	return sobolFloat(x), sobolFloat(y)
+}
+
+// sobolDimension1 is the second dimension of the Sobol sequence (the first
+// one being the bit reversed index).
+func sobolDimension1(index uint32) uint32 {
+	result := uint32(0)
+	for v := uint32(1 << 31); index != 0; index >>= 1 {
+		if index&1 != 0 {
+			result ^= v
+		}
+		v ^= v >> 1
+	}
+	return result
+}
+
+// nestedUniformScramble is an Owen scramble of x's bits, from the Laine-Karras
+// hash.
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return bits.Reverse32(x)
+}
+
+const oneMinusEpsilon = 1 - 1.0/(1<<53)
+
+func sobolFloat(x uint32) float64 {
+	return math.Min(float64(x)/(1<<32), oneMinusEpsilon)
+}
+
+func radicalInverse(base, index uint64) float64 {
+	invBase := 1.0 / float64(base)
+	invBaseN := 1.0
+	reversed := uint64(0)
+	for index > 0 {
+		next := index / base
+		reversed = reversed*base + (index - next*base)
+		invBaseN *= invBase
+		index = next
+	}
+	return math.Min(float64(reversed)*invBaseN, oneMinusEpsilon)
+}
+
+// permute returns the position of i in a random permutation of [0, l)
+// defined by p, without building it (see Kensler, "Correlated Multi-Jittered
+// Sampling", 2013).
+func permute(i, l, p uint32) uint32 {
+	if l <= 1 {
+		return 0
+	}
+
+	w := l - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+
+	for {
+		i ^= p
+		i *= 0xe170893d
+		i ^= p >> 16
+		i ^= (i & w) >> 4
+		i ^= p >> 8
+		i *= 0x0929eb3f
+		i ^= p >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | p>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < l {
+			break
+		}
+	}
+
+	return (i + p) % l
+}
+
+// hashFloat hashes h and i into [0, 1).
+func hashFloat(h, i uint64) float64 {
+	return float64(mix64(h^mix64(i))>>11) * (1.0 / (1 << 53))
+}
+
+// UniformSampleSphere maps a 2D sample to a direction uniformly distributed
+// over the unit sphere.
+func UniformSampleSphere(u1, u2 float64) Vec3 {
+	z := 1 - 2*u1
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	phi := 2 * math.Pi * u2
+	return Vec3{r * math.Cos(phi), r * math.Sin(phi), z}
+}
+
+// UniformSampleBall maps a 3D sample to a point uniformly distributed inside
+// the unit sphere.
+func UniformSampleBall(u1, u2, u3 float64) Vec3 {
+	return UniformSampleSphere(u1, u2).MulFloat(math.Cbrt(u3))
+}
@@ -0,0 +1,149 @@
+package tracer
+
+import "testing"
+
+var samplerKinds = []SamplerKind{SamplerIndependent, SamplerStratified, SamplerHalton, SamplerSobol}
+
+func TestParseSamplerKind(t *testing.T) {
+	for _, kind := range samplerKinds {
+		parsed, err := ParseSamplerKind(kind.String())
+		if err != nil || parsed != kind {
+			t.Errorf("%v: parsed %v, %v", kind, parsed, err)
+		}
+	}
+	if _, err := ParseSamplerKind("random"); err == nil {
+		t.Error("parsed an unknown sampler")
+	}
+}
+
+func TestSamplersDeterministic(t *testing.T) {
+	const dimensions = 40
+
+	samples := func(s Sampler, row, col, index int) []float64 {
+		s.StartSample(row, col, index)
+		v := make([]float64, 0, dimensions)
+		for len(v) < dimensions {
+			x, y := s.Get2D()
+			v = append(v, s.Get1D(), x, y)
+		}
+		return v
+	}
+
+	for _, kind := range samplerKinds {
+		a := samples(NewSampler(kind, 1, 16), 3, 5, 7)
+		b := samples(NewSampler(kind, 1, 16), 3, 5, 7)
+		otherPixel := samples(NewSampler(kind, 1, 16), 5, 3, 7)
+		otherSeed := samples(NewSampler(kind, 2, 16), 3, 5, 7)
+
+		for i := range a {
+			if a[i] < 0 || a[i] >= 1 {
+				t.Errorf("%v: dimension %d is %v, out of [0, 1)", kind, i, a[i])
+			}
+			if a[i] != b[i] {
+				t.Errorf("%v: dimension %d differs between identical samplers", kind, i)
+			}
+		}
+		if equalFloats(a, otherPixel) || equalFloats(a, otherSeed) {
+			t.Errorf("%v: samples don't depend on the pixel and seed", kind)
+		}
+	}
+}
+
+func equalFloats(a, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// strata returns, for the dimensions of n samples of a pixel, how many fall in
+// each of nx by ny strata of [0, 1)², the 1D dimensions along x only.
+func strata(s Sampler, n, nx, ny, dimension int, twoD bool) []int {
+	counts := make([]int, nx*ny)
+	for i := 0; i < n; i++ {
+		s.StartSample(2, 9, i)
+		for d := 0; d < dimension; d++ {
+			s.Get2D()
+		}
+
+		var x, y float64
+		if twoD {
+			x, y = s.Get2D()
+		} else {
+			x = s.Get1D()
+		}
+		counts[int(y*float64(ny))*nx+int(x*float64(nx))]++
+	}
+	return counts
+}
+
+func TestSamplersStratified(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler Sampler
+		n       int
+		// strata holding one sample each
+		nx, ny int
+		twoD   bool
+		// number of dimensions checked, after as many 2D ones
+		dimensions int
+	}{
+		{"stratified 1D", NewStratifiedSampler(1, 16), 16, 16, 1, false, 4},
+		{"stratified 2D", NewStratifiedSampler(1, 16), 16, 4, 4, true, 4},
+		{"stratified 2D, not square", NewStratifiedSampler(1, 12), 12, 4, 3, true, 4},
+		{"halton base 2", NewHaltonSampler(1), 16, 16, 1, false, 1},
+		{"sobol 1D", NewSobolSampler(1), 16, 16, 1, false, 4},
+		{"sobol 2D", NewSobolSampler(1), 16, 4, 4, true, 4},
+		{"sobol 2D, wide", NewSobolSampler(1), 16, 16, 1, true, 4},
+		{"sobol 2D, tall", NewSobolSampler(1), 16, 1, 16, true, 4},
+		{"sobol 2D, elementary", NewSobolSampler(1), 16, 8, 2, true, 4},
+	}
+
+	for _, test := range tests {
+		for dimension := 0; dimension < test.dimensions; dimension++ {
+			for i, count := range strata(test.sampler, test.n, test.nx, test.ny, dimension, test.twoD) {
+				if count != 1 {
+					t.Errorf("%s, dimension %d: stratum %d has %d samples, want 1", test.name, dimension, i, count)
+				}
+			}
+		}
+	}
+}
+
+func TestHaltonSamplerBase3(t *testing.T) {
+	s := NewHaltonSampler(5)
+	counts := make([]int, 9)
+	for i := 0; i < 9; i++ {
+		s.StartSample(0, 0, i)
+		s.Get1D()
+		counts[int(s.Get1D()*9)]++
+	}
+	for i, count := range counts {
+		if count != 1 {
+			t.Errorf("stratum %d has %d samples, want 1", i, count)
+		}
+	}
+}
+
+func TestUniformSampleSphere(t *testing.T) {
+	s := NewSobolSampler(3)
+	var mean Vec3
+	const n = 4096
+	for i := 0; i < n; i++ {
+		s.StartSample(0, 0, i)
+		v := UniformSampleSphere(s.Get2D())
+		if !approxEqual(v.Len(), 1, 1e-12) {
+			t.Fatalf("sample %v isn't a unit vector", v)
+		}
+		u1, u2 := s.Get2D()
+		if p := UniformSampleBall(u1, u2, s.Get1D()); p.Len() > 1 {
+			t.Fatalf("sample %v isn't in the unit ball", p)
+		}
+		mean = mean.Add(v.MulFloat(1.0 / n))
+	}
+	if mean.Len() > 0.01 {
+		t.Errorf("mean direction %v, want about 0", mean)
+	}
+}
@@ -0,0 +1,147 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Scene is a camera, the hitters it looks at, the lights lighting them and,
+// optionally, how to render them. Its field names are those of the gob scenes
+// written by cmd/tracer.
+type Scene struct {
+	Camera     Camera
+	HitterList HitterList
+	Settings   SceneSettings
+	// Lights sampled directly by integrators, besides the emissive hitters
+	// of HitterList. Lights that are Hitters too are part of the scene's
+	// geometry, see Hitters.
+	Lights []Light
+}
+
+// Hitters is HitterList along with the lights that are Hitters too, e.g.
+// SphereLight.
+func (scene *Scene) Hitters() HitterList {
+	l := append(HitterList{}, scene.HitterList...)
+	for i := range scene.Lights {
+		if h, ok := scene.Lights[i].(Hitter); ok {
+			l = append(l, h)
+		}
+	}
+	return l
+}
+
+// SceneSettings are render settings stored along a scene. Zero values leave
+// the choice to the renderer.
+type SceneSettings struct {
+	Width           int `json:"width,omitempty"`
+	SamplesPerPixel int `json:"samples_per_pixel,omitempty"`
+	MaxDepth        int `json:"max_depth,omitempty"`
+}
+
+// LoadScene reads a .gob or .json scene.
+func LoadScene(path string) (*Scene, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scene *Scene
+	switch ext := filepath.Ext(path); ext {
+	case ".gob":
+		scene, err = DecodeGobScene(bytes.NewReader(b))
+	case ".json":
+		scene, err = DecodeJSONScene(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("%s: unknown scene format %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return scene, nil
+}
+
+// SaveScene writes scene as .gob or .json, according to path's extension.
+func SaveScene(path string, scene *Scene) error {
+	var buf bytes.Buffer
+
+	var err error
+	switch ext := filepath.Ext(path); ext {
+	case ".gob":
+		err = EncodeGobScene(&buf, scene)
+	case ".json":
+		err = EncodeJSONScene(&buf, scene)
+	default:
+		return fmt.Errorf("%s: unknown scene format %q", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// DecodeGobScene decodes a gob scene. The concrete Hitter, Material and Light
+// types it holds must be registered, see RegisterHitter, RegisterMaterial and
+// RegisterLight.
+func DecodeGobScene(r io.Reader) (*Scene, error) {
+	var scene Scene
+	if err := gob.NewDecoder(r).Decode(&scene); err != nil {
+		return nil, err
+	}
+
+	for i := range scene.HitterList {
+		h, err := finishDecode(scene.HitterList[i])
+		if err != nil {
+			return nil, fmt.Errorf("hitter %d: %w", i, err)
+		}
+		scene.HitterList[i] = h.(Hitter)
+	}
+
+	for i := range scene.Lights {
+		l, err := finishDecode(scene.Lights[i])
+		if err != nil {
+			return nil, fmt.Errorf("light %d: %w", i, err)
+		}
+		scene.Lights[i] = l.(Light)
+	}
+
+	return &scene, nil
+}
+
+func EncodeGobScene(w io.Writer, scene *Scene) error {
+	return gob.NewEncoder(w).Encode(scene)
+}
+
+// decodeFinisher is implemented by hitters, materials and lights deriving state from
+// their exported fields (e.g. a Sphere's bounding box), which isn't part of
+// their encoded form.
+type decodeFinisher interface {
+	finishDecode() error
+}
+
+// finishDecode calls v's finishDecode, if any, on an addressable copy when v
+// isn't a pointer, and returns the finished value.
+func finishDecode(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if f, ok := v.(decodeFinisher); ok {
+			return v, f.finishDecode()
+		}
+		return v, nil
+	}
+
+	pv := reflect.New(rv.Type())
+	pv.Elem().Set(rv)
+	if f, ok := pv.Interface().(decodeFinisher); ok {
+		if err := f.finishDecode(); err != nil {
+			return nil, err
+		}
+	}
+	return pv.Elem().Interface(), nil
+}
@@ -0,0 +1,508 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSceneVersion is the version of the JSON scene format written by
+// EncodeJSONScene. Decoding rejects any other version.
+//
+// A JSON scene looks like:
+//
+//	{
+//	  "version": 1,
+//	  "camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [-2, 2, 1], "look_at": [0, 0, -1], "vup": [0, 1, 0]},
+//	  "render": {"width": 300, "samples_per_pixel": 512, "max_depth": 20},
+//	  "materials": {
+//	    "ground": {"type": "lambertian", "albedo": [0.8, 0.8, 0]}
+//	  },
+//	  "shapes": [
+//	    {"type": "sphere", "center": [0, 0, -1], "radius": 0.5, "material": "ground"}
+//	  ],
+//	  "lights": [
+//	    {"type": "point", "position": [0, 5, 0], "color": [1, 1, 1], "intensity": 50}
+//	  ]
+//	}
+//
+// Shapes reference materials by name. Their "type", like that of materials
+// and lights, is the name their Go type is registered under (see
+// RegisterHitter, RegisterMaterial and RegisterLight), the other keys are the
+// json fields of that type. Lights are optional. Texture fields of materials
+// take either a color, for a constant texture, or a texture object such as
+// {"type": "checker", "even": [1, 1, 1], "odd": [0, 0, 0], "scale": 0.5}.
+const JSONSceneVersion = 1
+
+// SceneError is an invalid entry of a scene description, located by its JSON
+// path (e.g. "shapes[3].material").
+type SceneError struct {
+	Path string
+	Err  error
+}
+
+func (e *SceneError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *SceneError) Unwrap() error {
+	return e.Err
+}
+
+func sceneErrorf(path, format string, args ...interface{}) error {
+	return &SceneError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+type jsonScene struct {
+	Version   int                        `json:"version"`
+	Camera    Camera                     `json:"camera"`
+	Render    SceneSettings              `json:"render"`
+	Materials map[string]json.RawMessage `json:"materials"`
+	Shapes    []json.RawMessage          `json:"shapes"`
+	Lights    []json.RawMessage          `json:"lights"`
+}
+
+var (
+	materialType = reflect.TypeOf((*Material)(nil)).Elem()
+	hitterType   = reflect.TypeOf((*Hitter)(nil)).Elem()
+	textureType  = reflect.TypeOf((*Texture)(nil)).Elem()
+)
+
+func DecodeJSONScene(r io.Reader) (*Scene, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var js jsonScene
+	if err := dec.Decode(&js); err != nil {
+		return nil, err
+	}
+
+	if js.Version != JSONSceneVersion {
+		return nil, sceneErrorf("version", "unsupported version %d, want %d", js.Version, JSONSceneVersion)
+	}
+
+	d := &jsonSceneDecoder{
+		raw:       js.Materials,
+		materials: map[string]Material{},
+		decoding:  map[string]bool{},
+	}
+
+	scene := &Scene{
+		Camera:   js.Camera,
+		Settings: js.Render,
+	}
+
+	for i, raw := range js.Shapes {
+		path := fmt.Sprintf("shapes[%d]", i)
+		v, err := d.object(raw, hitters, path)
+		if err != nil {
+			return nil, err
+		}
+		h, ok := v.(Hitter)
+		if !ok {
+			return nil, sceneErrorf(path, "%T is not a shape", v)
+		}
+		scene.HitterList = append(scene.HitterList, h)
+	}
+
+	for i, raw := range js.Lights {
+		path := fmt.Sprintf("lights[%d]", i)
+		v, err := d.object(raw, lights, path)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := v.(Light)
+		if !ok {
+			return nil, sceneErrorf(path, "%T is not a light", v)
+		}
+		scene.Lights = append(scene.Lights, l)
+	}
+
+	// decode unused materials too, so mistakes in them aren't silently ignored
+	names := make([]string, 0, len(js.Materials))
+	for name := range js.Materials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := d.material(name, "materials."+name); err != nil {
+			return nil, err
+		}
+	}
+
+	return scene, nil
+}
+
+type jsonSceneDecoder struct {
+	raw       map[string]json.RawMessage
+	materials map[string]Material
+	// materials being decoded, to catch reference cycles
+	decoding map[string]bool
+}
+
+// material returns the named material, decoding it on first use.
+func (d *jsonSceneDecoder) material(name, path string) (Material, error) {
+	if m, ok := d.materials[name]; ok {
+		return m, nil
+	}
+
+	raw, ok := d.raw[name]
+	if !ok {
+		return nil, sceneErrorf(path, "unknown material %q", name)
+	}
+	if d.decoding[name] {
+		return nil, sceneErrorf(path, "material %q references itself", name)
+	}
+	d.decoding[name] = true
+	defer delete(d.decoding, name)
+
+	v, err := d.object(raw, materials, "materials."+name)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(Material)
+	if !ok {
+		return nil, sceneErrorf("materials."+name, "%T is not a material", v)
+	}
+	d.materials[name] = m
+
+	return m, nil
+}
+
+// object decodes a {"type": ..., fields...} object into a new value of the
+// named type.
+func (d *jsonSceneDecoder) object(raw json.RawMessage, types *registry, path string) (interface{}, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, sceneErrorf(path, "expected an object")
+	}
+
+	var typeName string
+	if err := json.Unmarshal(fields["type"], &typeName); err != nil || typeName == "" {
+		return nil, sceneErrorf(path+".type", "missing or invalid type")
+	}
+	delete(fields, "type")
+
+	zero, err := types.new(typeName)
+	if err != nil {
+		return nil, &SceneError{Path: path + ".type", Err: err}
+	}
+
+	v := reflect.ValueOf(zero)
+	pv := v
+	if v.Kind() != reflect.Ptr {
+		pv = reflect.New(v.Type())
+	}
+	if err := d.fields(fields, pv.Elem(), path); err != nil {
+		return nil, err
+	}
+
+	if f, ok := pv.Interface().(decodeFinisher); ok {
+		if err := f.finishDecode(); err != nil {
+			return nil, &SceneError{Path: path, Err: err}
+		}
+	}
+
+	if v.Kind() != reflect.Ptr {
+		return pv.Elem().Interface(), nil
+	}
+	return pv.Interface(), nil
+}
+
+// fields decodes a json object into the struct v.
+func (d *jsonSceneDecoder) fields(fields map[string]json.RawMessage, v reflect.Value, path string) error {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fieldPath := path + "." + key
+
+		field, ok := jsonField(v, key)
+		if !ok {
+			return sceneErrorf(fieldPath, "unknown field")
+		}
+
+		raw := fields[key]
+		switch field.Type() {
+		case materialType:
+			var name string
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return sceneErrorf(fieldPath, "expected a material name")
+			}
+			m, err := d.material(name, fieldPath)
+			if err != nil {
+				return err
+			}
+			field.Set(reflect.ValueOf(&m).Elem())
+		case textureType:
+			t, err := d.texture(raw, fieldPath)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				field.Set(reflect.ValueOf(&t).Elem())
+			}
+		default:
+			if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &typeErr) {
+					return sceneErrorf(fieldPath, "expected %s, got %s", typeErr.Type, typeErr.Value)
+				}
+				return &SceneError{Path: fieldPath, Err: err}
+			}
+		}
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		name, ok := jsonFieldName(v.Type().Field(i))
+		if ok && v.Field(i).Type() == materialType && v.Field(i).IsNil() {
+			return sceneErrorf(path+"."+name, "missing material")
+		}
+	}
+
+	return nil
+}
+
+// texture decodes a texture object, or a color as a ConstantTexture. null is
+// no texture.
+func (d *jsonSceneDecoder) texture(raw json.RawMessage, path string) (Texture, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return nil, nil
+	case bytes.HasPrefix(raw, []byte("[")):
+		var c Color
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, sceneErrorf(path, "expected a color or a texture")
+		}
+		return ConstantTexture{Color: c}, nil
+	}
+
+	v, err := d.object(raw, textures, path)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(Texture)
+	if !ok {
+		return nil, sceneErrorf(path, "%T is not a texture", v)
+	}
+	return t, nil
+}
+
+// jsonFieldName is the json key of a struct field, if it has one.
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" {
+		return f.Name, true
+	}
+	return tag, true
+}
+
+func jsonField(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if name, ok := jsonFieldName(v.Type().Field(i)); ok && name == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// EncodeJSONScene writes scene in the JSON scene format, one material, shape
+// and light per line. Equal materials are written once and shared by name.
+func EncodeJSONScene(w io.Writer, scene *Scene) error {
+	e := &jsonSceneEncoder{materials: map[string]json.RawMessage{}}
+
+	var shapes []json.RawMessage
+	for i, h := range scene.HitterList {
+		raw, err := e.object(h, hitters, fmt.Sprintf("shapes[%d]", i))
+		if err != nil {
+			return err
+		}
+		shapes = append(shapes, raw)
+	}
+
+	var sceneLights []json.RawMessage
+	for i, l := range scene.Lights {
+		raw, err := e.object(l, lights, fmt.Sprintf("lights[%d]", i))
+		if err != nil {
+			return err
+		}
+		sceneLights = append(sceneLights, raw)
+	}
+
+	camera, err := json.Marshal(scene.Camera)
+	if err != nil {
+		return err
+	}
+	render, err := json.Marshal(scene.Settings)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  \"version\": %d,\n  \"camera\": %s,\n  \"render\": %s,\n  \"materials\": {", JSONSceneVersion, camera, render)
+	for i, m := range e.seen {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "\n    %q: %s", m.name, e.materials[m.name])
+	}
+	buf.WriteString("\n  },\n  \"shapes\": [")
+	for i, raw := range shapes {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "\n    %s", raw)
+	}
+	buf.WriteString("\n  ]")
+	if len(sceneLights) > 0 {
+		buf.WriteString(",\n  \"lights\": [")
+		for i, raw := range sceneLights {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(&buf, "\n    %s", raw)
+		}
+		buf.WriteString("\n  ]")
+	}
+	buf.WriteString("\n}\n")
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+type jsonSceneEncoder struct {
+	materials map[string]json.RawMessage
+	// encoded materials, to share equal ones
+	seen []encodedMaterial
+}
+
+type encodedMaterial struct {
+	material Material
+	name     string
+}
+
+func (e *jsonSceneEncoder) material(m Material, path string) (string, error) {
+	for _, seen := range e.seen {
+		if equalMaterials(seen.material, m) {
+			return seen.name, nil
+		}
+	}
+
+	typeName, ok := materials.name(m)
+	if !ok {
+		return "", sceneErrorf(path, "unregistered material type %T", m)
+	}
+
+	// materials m references are named first, name it after them
+	raw, err := e.object(m, materials, path)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%d", typeName, len(e.seen))
+	e.materials[name] = raw
+	e.seen = append(e.seen, encodedMaterial{material: m, name: name})
+
+	return name, nil
+}
+
+// equalMaterials compares a and b with ==, taking them as different when they
+// can't be compared.
+func equalMaterials(a, b Material) (equal bool) {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	// comparable types may still hold incomparable values, e.g. in a Texture
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return a == b
+}
+
+// texture encodes a texture object, or a color for a ConstantTexture.
+func (e *jsonSceneEncoder) texture(t Texture, path string) (json.RawMessage, error) {
+	if c, ok := t.(ConstantTexture); ok {
+		return json.Marshal(c.Color)
+	}
+	return e.object(t, textures, path)
+}
+
+func (e *jsonSceneEncoder) object(v interface{}, types *registry, path string) (json.RawMessage, error) {
+	typeName, ok := types.name(v)
+	if !ok {
+		return nil, sceneErrorf(path, "unregistered %s type %T", types.kind, v)
+	}
+
+	// encoding/json keeps the order of struct fields but not of maps, so
+	// build the object by hand to have "type" first
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"type":%q`, typeName)
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		name, ok := jsonFieldName(sf)
+		if !ok {
+			continue
+		}
+
+		field := rv.Field(i)
+		var value interface{}
+		switch field.Type() {
+		case materialType:
+			if field.IsNil() {
+				return nil, sceneErrorf(path+"."+name, "missing material")
+			}
+			materialName, err := e.material(field.Interface().(Material), path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			value = materialName
+		case textureType:
+			if field.IsNil() {
+				continue
+			}
+			raw, err := e.texture(field.Interface().(Texture), path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			value = raw
+		case hitterType:
+			return nil, sceneErrorf(path+"."+name, "nested hitters are not supported")
+		default:
+			if field.IsZero() && strings.HasSuffix(sf.Tag.Get("json"), ",omitempty") {
+				continue
+			}
+			value = field.Interface()
+		}
+
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, &SceneError{Path: path + "." + name, Err: err}
+		}
+		fmt.Fprintf(&buf, ",%q:%s", name, b)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package tracer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testJSONScene is a scene with every shape and several materials, one of
+// them shared.
+func testJSONScene(t *testing.T) *Scene {
+	t.Helper()
+	ground := Lambertian{Albedo: Color{0.5, 0.5, 0.5}}
+	mesh, err := NewTriangleMesh(
+		[]Point3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+		[]Vec3{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+		nil,
+		[]int{0, 1, 2, 0, 2, 3},
+		Metal{Albedo: Color{0.8, 0.6, 0.2}, Fuzz: 0.1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Scene{
+		Camera:   Camera{AspectRatio: 1.5, VFoV: 40, LookFrom: Point3{0, 1, 3}, LookAt: Point3{0, 0, 0}, VUp: Vec3{0, 1, 0}},
+		Settings: SceneSettings{Width: 64, SamplesPerPixel: 8, MaxDepth: 4},
+		HitterList: HitterList{
+			NewPlane(Point3{0, -1, 0}, Vec3{0, 1, 0}, ground),
+			NewSphere(Point3{0, 0, -1}, 0.5, Dielectric{RefractiveIndex: 1.5}),
+			NewSphere(Point3{1, 0, -1}, 0.5, ground),
+			NewTriangle(Point3{-1, 0, 0}, Point3{0, 0, 0}, Point3{0, 1, 0}, Metal{Albedo: Color{0.9, 0.9, 0.9}}),
+			mesh,
+		},
+	}
+}
+
+func TestJSONSceneRoundTrip(t *testing.T) {
+	var first bytes.Buffer
+	if err := EncodeJSONScene(&first, testJSONScene(t)); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(first.String(), `"type":"lambertian"`); n != 1 {
+		t.Errorf("shared material written %d times, want once", n)
+	}
+
+	scene, err := DecodeJSONScene(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scene.HitterList) != 5 || scene.Settings.Width != 64 {
+		t.Fatalf("decoded %d shapes and settings %+v", len(scene.HitterList), scene.Settings)
+	}
+
+	// derived state is restored, e.g. bounding boxes and mesh BVHs
+	hr := scene.HitterList.Hit(Ray{Origin: Point3{0.5, 0.25, 2}, Direction: Vec3{0, 0, -1}})
+	if !hr.Hit || hr.T != 2 {
+		t.Errorf("decoded mesh hit %v at %v, want at 2", hr.Hit, hr.T)
+	}
+
+	var second bytes.Buffer
+	if err := EncodeJSONScene(&second, scene); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("re-encoded scene differs:\n%s\nwant:\n%s", second.String(), first.String())
+	}
+}
+
+func TestJSONSceneErrors(t *testing.T) {
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+	scene := func(materials, shapes string) string {
+		return `{"version": 1, ` + camera + `, "materials": {` + materials + `}, "shapes": [` + shapes + `]}`
+	}
+	const white = `"white": {"type": "lambertian", "albedo": [1, 1, 1]}`
+
+	tests := []struct {
+		name, json, path, err string
+	}{
+		{"version", `{"version": 2}`, "version", "unsupported version 2"},
+		{"missing type", scene(white, `{"center": [0, 0, 0]}`), "shapes[0].type", "missing or invalid type"},
+		{"unknown type", scene(white, `{"type": "cube"}`), "shapes[0].type", "cube"},
+		{"unknown field", scene(white, `{"type": "sphere", "centre": [0, 0, 0], "radius": 1, "material": "white"}`), "shapes[0].centre", "unknown field"},
+		{"wrong field type", scene(white, `{"type": "sphere", "center": [0, 0, 0], "radius": "big", "material": "white"}`), "shapes[0].radius", "expected float64"},
+		{"invalid shape", scene(white, `{"type": "sphere", "center": [0, 0, 0], "radius": -1, "material": "white"}`), "shapes[0]", "radius must be positive"},
+		{"missing material", scene(white, `{"type": "sphere", "center": [0, 0, 0], "radius": 1}`), "shapes[0].material", "missing material"},
+		{"unknown material", scene(white, `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "black"}`), "shapes[0].material", `unknown material "black"`},
+		{"invalid material", scene(`"glass": {"type": "dielectric", "refractive_index": 0}`, ""), "materials.glass", "refractive index must be positive"},
+		{"not a material", scene(`"s": {"type": "sphere", "center": [0, 0, 0], "radius": 1}`, ""), "materials.s.type", "sphere"},
+		{"material cycle", scene(`"a": {"type": "normal_mapped", "material": "a", "bump_map": [1, 1, 1]}`, ""), "materials.a.material", "references itself"},
+		{"bad texture", scene(`"t": {"type": "lambertian", "albedo_texture": [1, "x"]}`, ""), "materials.t.albedo_texture", "expected a color or a texture"},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeJSONScene(strings.NewReader(test.json))
+		var sceneErr *SceneError
+		if !errors.As(err, &sceneErr) {
+			t.Errorf("%s: error %v, want a SceneError", test.name, err)
+			continue
+		}
+		if sceneErr.Path != test.path || !strings.Contains(sceneErr.Err.Error(), test.err) {
+			t.Errorf("%s: error %q at %q, want %q at %q", test.name, sceneErr.Err, sceneErr.Path, test.err, test.path)
+		}
+	}
+}
+
+func TestGobSceneToJSON(t *testing.T) {
+	var gob bytes.Buffer
+	if err := EncodeGobScene(&gob, testJSONScene(t)); err != nil {
+		t.Fatal(err)
+	}
+	scene, err := DecodeGobScene(&gob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fromGob, fromScene bytes.Buffer
+	if err := EncodeJSONScene(&fromGob, scene); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeJSONScene(&fromScene, testJSONScene(t)); err != nil {
+		t.Fatal(err)
+	}
+	if fromGob.String() != fromScene.String() {
+		t.Errorf("gob scene encodes as:\n%s\nwant:\n%s", fromGob.String(), fromScene.String())
+	}
+}
+
+func TestRegistryNames(t *testing.T) {
+	for _, name := range []string{"sphere", "plane", "triangle", "mesh"} {
+		h, err := NewHitter(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := HitterTypeName(h); !ok || got != name {
+			t.Errorf("%T registered as %q, want %q", h, got, name)
+		}
+	}
+
+	m, err := NewMaterial("metal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := MaterialTypeName(m); !ok || got != "metal" {
+		t.Errorf("%T registered as %q, want metal", m, got)
+	}
+	// values and pointers share a name
+	if got, ok := MaterialTypeName(&Metal{}); !ok || got != "metal" {
+		t.Errorf("*Metal registered as %q, want metal", got)
+	}
+	if _, err := NewMaterial("sphere"); err == nil {
+		t.Error("no error for a hitter name")
+	}
+}
@@ -0,0 +1,54 @@
+package tracer
+
+import "math"
+
+// Range of visible wavelengths sampled by dispersive materials, in nanometers
+const (
+	minWavelength = 380.0
+	maxWavelength = 780.0
+)
+
+// sampleWavelength samples a visible wavelength uniformly, returning it with
+// its weight: its color, scaled so that the weights of all wavelengths
+// average to white.
+func sampleWavelength(u float64) (float64, Color) {
+	wavelength := minWavelength + u*(maxWavelength-minWavelength)
+	return wavelength, Color(Vec3(wavelengthColor(wavelength)).MulVec3(wavelengthScale))
+}
+
+// wavelengthScale makes the colors of wavelengths average to white.
+var wavelengthScale = func() Vec3 {
+	const steps = 4000
+	var sum Vec3
+	for i := 0; i < steps; i++ {
+		wavelength := minWavelength + (float64(i)+0.5)/steps*(maxWavelength-minWavelength)
+		sum = sum.Add(Vec3(wavelengthColor(wavelength)))
+	}
+	return Vec3{steps / sum[0], steps / sum[1], steps / sum[2]}
+}()
+
+// wavelengthColor is the linear sRGB color of light of a single wavelength,
+// clamped to the sRGB gamut. The CIE 1931 color matching functions are
+// approximated with Wyman, Sloan and Shirley's multi-lobe fit.
+func wavelengthColor(wavelength float64) Color {
+	x := 1.056*lobe(wavelength, 599.8, 37.9, 31.0) + 0.362*lobe(wavelength, 442.0, 16.0, 26.7) -
+		0.065*lobe(wavelength, 501.1, 20.4, 26.2)
+	y := 0.821*lobe(wavelength, 568.8, 46.9, 40.5) + 0.286*lobe(wavelength, 530.9, 16.3, 31.1)
+	z := 1.217*lobe(wavelength, 437.0, 11.8, 36.0) + 0.681*lobe(wavelength, 459.0, 26.0, 13.8)
+
+	return Color{
+		math.Max(0, 3.2404542*x-1.5371385*y-0.4985314*z),
+		math.Max(0, -0.9692660*x+1.8760108*y+0.0415560*z),
+		math.Max(0, 0.0556434*x-0.2040259*y+1.0572252*z),
+	}
+}
+
+// lobe is a gaussian of different widths on either side of its mean.
+func lobe(x, mean, below, above float64) float64 {
+	sigma := above
+	if x < mean {
+		sigma = below
+	}
+	t := (x - mean) / sigma
+	return math.Exp(-t * t / 2)
+}
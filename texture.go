@@ -0,0 +1,322 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+)
+
+// Texture is a color varying over surfaces, looked up by the texture
+// coordinates u, v of a hit and its point p.
+type Texture interface {
+	Value(u, v float64, p Point3) Color
+}
+
+// textureColor is t at hr, or color when t is nil.
+func textureColor(t Texture, color Color, hr HitRecord) Color {
+	if t == nil {
+		return color
+	}
+	return t.Value(hr.U, hr.V, hr.P)
+}
+
+// textureScalar is the luminance of t at hr, or value when t is nil.
+func textureScalar(t Texture, value float64, hr HitRecord) float64 {
+	if t == nil {
+		return value
+	}
+	return t.Value(hr.U, hr.V, hr.P).Luminance()
+}
+
+// ConstantTexture is the same color everywhere.
+type ConstantTexture struct {
+	Color Color `json:"color"`
+}
+
+func (t ConstantTexture) Value(float64, float64, Point3) Color {
+	return t.Color
+}
+
+// CheckerTexture alternates between two textures in a 3D checkerboard of
+// cubes of side Scale (1 when zero), so it doesn't depend on texture
+// coordinates.
+type CheckerTexture struct {
+	Even  Texture `json:"even"`
+	Odd   Texture `json:"odd"`
+	Scale float64 `json:"scale,omitempty"`
+}
+
+func (t *CheckerTexture) finishDecode() error {
+	if t.Even == nil || t.Odd == nil {
+		return errors.New("checker needs even and odd textures")
+	}
+	if t.Scale < 0 {
+		return errors.New("scale can't be negative")
+	}
+	return nil
+}
+
+func (t CheckerTexture) Value(u, v float64, p Point3) Color {
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	sum := 0
+	for i := range p {
+		sum += int(math.Floor(p[i] / scale))
+	}
+
+	if sum%2 == 0 {
+		return t.Even.Value(u, v, p)
+	}
+	return t.Odd.Value(u, v, p)
+}
+
+// ScaledTexture is Texture multiplied by Scale.
+type ScaledTexture struct {
+	Texture Texture `json:"texture"`
+	Scale   Color   `json:"scale"`
+}
+
+func (t *ScaledTexture) finishDecode() error {
+	if t.Texture == nil {
+		return errors.New("scaled texture needs a texture")
+	}
+	return nil
+}
+
+func (t ScaledTexture) Value(u, v float64, p Point3) Color {
+	return Color(Vec3(t.Texture.Value(u, v, p)).MulVec3(Vec3(t.Scale)))
+}
+
+// ChannelTexture is the gray of one channel of Texture, 0 to 2 for red to
+// blue, e.g. to read scalars packed in the channels of an image.
+type ChannelTexture struct {
+	Texture Texture `json:"texture"`
+	Channel int     `json:"channel"`
+}
+
+func (t *ChannelTexture) finishDecode() error {
+	if t.Texture == nil {
+		return errors.New("channel texture needs a texture")
+	}
+	if t.Channel < 0 || t.Channel > 2 {
+		return errors.New("channel must be between 0 and 2")
+	}
+	return nil
+}
+
+func (t ChannelTexture) Value(u, v float64, p Point3) Color {
+	c := t.Texture.Value(u, v, p)[t.Channel]
+	return Color{c, c, c}
+}
+
+// ImageTexture maps an image over texture coordinates, (0, 0) being its
+// bottom left corner and (1, 1) its top right one, with bilinear filtering.
+// Images are loaded from Path (see loadColorImage), which encoded scenes
+// keep instead of the image itself.
+type ImageTexture struct {
+	Path string   `json:"path"`
+	Wrap WrapMode `json:"wrap,omitempty"`
+	// Linear images hold data rather than colors, e.g. normals or roughness,
+	// so they aren't sRGB decoded
+	Linear bool `json:"linear,omitempty"`
+
+	image colorImage
+}
+
+func LoadImageTexture(path string, wrap WrapMode, linear bool) (*ImageTexture, error) {
+	t := &ImageTexture{Path: path, Wrap: wrap, Linear: linear}
+	if err := t.finishDecode(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *ImageTexture) finishDecode() error {
+	if t.Wrap < WrapRepeat || t.Wrap > WrapMirror {
+		return errors.New("unknown wrap mode")
+	}
+	img, err := loadColorImage(t.Path, t.Linear)
+	if err != nil {
+		return err
+	}
+	t.image = img
+	return nil
+}
+
+func (t *ImageTexture) Value(u, v float64, _ Point3) Color {
+	if t.image.pixels == nil {
+		return Color{}
+	}
+	return t.image.bilinear(u, 1-v, t.Wrap, t.Wrap)
+}
+
+type imageTextureGob struct {
+	Path   string
+	Wrap   WrapMode
+	Linear bool
+}
+
+// GobEncode encodes the path of the image, rather than the image itself.
+func (t *ImageTexture) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(imageTextureGob{Path: t.Path, Wrap: t.Wrap, Linear: t.Linear})
+	return buf.Bytes(), err
+}
+
+// GobDecode loads the image at the decoded path.
+func (t *ImageTexture) GobDecode(b []byte) error {
+	var g imageTextureGob
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g); err != nil {
+		return err
+	}
+	t.Path, t.Wrap, t.Linear = g.Path, g.Wrap, g.Linear
+	return t.finishDecode()
+}
+
+// NoiseTexture is Color modulated by Perlin noise, of features about 1/Scale
+// (1 when zero) wide.
+type NoiseTexture struct {
+	Color Color   `json:"color"`
+	Scale float64 `json:"scale,omitempty"`
+}
+
+func (t NoiseTexture) Value(_, _ float64, p Point3) Color {
+	p = Point3(Vec3(p).MulFloat(noiseScale(t.Scale)))
+	return Color(Vec3(t.Color).MulFloat(0.5 * (1 + perlin.noise(p))))
+}
+
+// TurbulenceTexture is Color modulated by turbulence, i.e. Octaves (7 when
+// zero) of Perlin noise of increasing frequency and decreasing amplitude.
+type TurbulenceTexture struct {
+	Color   Color   `json:"color"`
+	Scale   float64 `json:"scale,omitempty"`
+	Octaves int     `json:"octaves,omitempty"`
+}
+
+func (t TurbulenceTexture) Value(_, _ float64, p Point3) Color {
+	p = Point3(Vec3(p).MulFloat(noiseScale(t.Scale)))
+	return Color(Vec3(t.Color).MulFloat(perlin.turbulence(p, t.Octaves)))
+}
+
+// MarbleTexture is Color modulated by sine stripes along z, Scale (1 when
+// zero) of them every 2π, distorted by turbulence.
+type MarbleTexture struct {
+	Color   Color   `json:"color"`
+	Scale   float64 `json:"scale,omitempty"`
+	Octaves int     `json:"octaves,omitempty"`
+}
+
+func (t MarbleTexture) Value(_, _ float64, p Point3) Color {
+	scale := noiseScale(t.Scale)
+	stripes := 0.5 * (1 + math.Sin(scale*p[2]+10*perlin.turbulence(p, t.Octaves)))
+	return Color(Vec3(t.Color).MulFloat(stripes))
+}
+
+func noiseScale(scale float64) float64 {
+	if scale == 0 {
+		return 1
+	}
+	return scale
+}
+
+// perlin is the noise of every noise texture. Its seed is fixed, so noise
+// textures look the same from one render to the next.
+var perlin = newPerlinNoise(0)
+
+const perlinPoints = 256
+
+// perlinNoise is gradient noise, interpolating random unit vectors at the
+// corners of the unit cubes of a lattice.
+type perlinNoise struct {
+	gradients [perlinPoints]Vec3
+	// permutations of the lattice coordinates, one per axis
+	perm [3][perlinPoints]int
+}
+
+func newPerlinNoise(seed uint64) *perlinNoise {
+	r := NewRand(seed)
+
+	p := &perlinNoise{}
+	for i := range p.gradients {
+		p.gradients[i] = UniformSampleSphere(r.Float64(), r.Float64())
+	}
+	for axis := range p.perm {
+		for i := range p.perm[axis] {
+			p.perm[axis][i] = i
+		}
+		// Fisher–Yates
+		for i := perlinPoints - 1; i > 0; i-- {
+			j := r.Intn(i + 1)
+			p.perm[axis][i], p.perm[axis][j] = p.perm[axis][j], p.perm[axis][i]
+		}
+	}
+
+	return p
+}
+
+// noise is in [-1, 1].
+func (p *perlinNoise) noise(point Point3) float64 {
+	var (
+		cell [3]int
+		frac Vec3
+	)
+	for i := range point {
+		f := math.Floor(point[i])
+		cell[i] = int(f)
+		frac[i] = point[i] - f
+	}
+
+	// Hermite smoothing of the interpolation weights
+	var weight Vec3
+	for i := range frac {
+		weight[i] = frac[i] * frac[i] * (3 - 2*frac[i])
+	}
+
+	sum := 0.0
+	for di := 0; di < 2; di++ {
+		for dj := 0; dj < 2; dj++ {
+			for dk := 0; dk < 2; dk++ {
+				g := p.gradients[p.perm[0][(cell[0]+di)&(perlinPoints-1)]^
+					p.perm[1][(cell[1]+dj)&(perlinPoints-1)]^
+					p.perm[2][(cell[2]+dk)&(perlinPoints-1)]]
+				offset := Vec3{frac[0] - float64(di), frac[1] - float64(dj), frac[2] - float64(dk)}
+
+				w := lerpWeight(weight[0], di) * lerpWeight(weight[1], dj) * lerpWeight(weight[2], dk)
+				sum += w * g.Dot(offset)
+			}
+		}
+	}
+
+	return Clamp(sum, -1, 1)
+}
+
+// lerpWeight is the weight of the lower (corner 0) or upper (corner 1) end of
+// a linear interpolation by t.
+func lerpWeight(t float64, corner int) float64 {
+	if corner == 0 {
+		return 1 - t
+	}
+	return t
+}
+
+// turbulence sums octaves (7 when zero) of noise of doubling frequency and
+// halving amplitude.
+func (p *perlinNoise) turbulence(point Point3, octaves int) float64 {
+	if octaves <= 0 {
+		octaves = 7
+	}
+
+	sum := 0.0
+	weight := 1.0
+	for i := 0; i < octaves; i++ {
+		sum += weight * p.noise(point)
+		weight *= 0.5
+		point = Point3(Vec3(point).MulFloat(2))
+	}
+
+	return math.Abs(sum)
+}
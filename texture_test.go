@@ -0,0 +1,191 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWrapMode(t *testing.T) {
+	tests := []struct {
+		mode WrapMode
+		// indices of -2 to 5 in 4 pixels
+		want [8]int
+	}{
+		{WrapRepeat, [8]int{2, 3, 0, 1, 2, 3, 0, 1}},
+		{WrapClamp, [8]int{0, 0, 0, 1, 2, 3, 3, 3}},
+		{WrapMirror, [8]int{1, 0, 0, 1, 2, 3, 3, 2}},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseWrapMode(test.mode.String())
+		if err != nil || parsed != test.mode {
+			t.Errorf("%v: parsed %v, %v", test.mode, parsed, err)
+		}
+		for i, want := range test.want {
+			if got := test.mode.index(i-2, 4); got != want {
+				t.Errorf("%v: index %d is %d, want %d", test.mode, i-2, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckerTexture(t *testing.T) {
+	even, odd := Color{1, 1, 1}, Color{0, 0, 0}
+	checker := CheckerTexture{Even: ConstantTexture{Color: even}, Odd: ConstantTexture{Color: odd}, Scale: 2}
+
+	tests := []struct {
+		p    Point3
+		want Color
+	}{
+		{Point3{0.5, 0.5, 0.5}, even},
+		{Point3{2.5, 0.5, 0.5}, odd},
+		{Point3{2.5, 2.5, 0.5}, even},
+		{Point3{-0.5, 0.5, 0.5}, odd},
+		{Point3{-2.5, -0.5, 1}, odd},
+	}
+	for _, test := range tests {
+		if got := checker.Value(0, 0, test.p); got != test.want {
+			t.Errorf("at %v: %v, want %v", test.p, got, test.want)
+		}
+	}
+}
+
+func TestImageTexture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	// black and red on the top row, green and blue on the bottom one
+	writeTestPNG(t, path, 2, 2, func(x, y int) [3]uint8 {
+		return [][3]uint8{{0, 0, 0}, {255, 0, 0}, {0, 255, 0}, {0, 0, 255}}[y*2+x]
+	})
+
+	texture, err := LoadImageTexture(path, WrapClamp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		u, v float64
+		want Color
+	}{
+		{0.25, 0.75, Color{0, 0, 0}},
+		{0.75, 0.75, Color{1, 0, 0}},
+		{0.25, 0.25, Color{0, 1, 0}},
+		{0.75, 0.25, Color{0, 0, 1}},
+		{0.5, 0.75, Color{0.5, 0, 0}},
+		{0.5, 0.5, Color{0.25, 0.25, 0.25}},
+		// clamped past the edges
+		{-1, 2, Color{0, 0, 0}},
+	}
+	check := func(name string, texture *ImageTexture) {
+		for _, test := range tests {
+			if got := texture.Value(test.u, test.v, Point3{}); !approxVec3(Vec3(got), Vec3(test.want), testEpsilon) {
+				t.Errorf("%s: at (%v, %v): %v, want %v", name, test.u, test.v, got, test.want)
+			}
+		}
+	}
+	check("loaded", texture)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(texture); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &ImageTexture{}
+	if err := gob.NewDecoder(&buf).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	check("gob decoded", decoded)
+
+	if _, err := LoadImageTexture(filepath.Join(filepath.Dir(path), "missing.png"), WrapRepeat, false); err == nil {
+		t.Error("loaded a missing image")
+	}
+}
+
+func TestNoiseTextures(t *testing.T) {
+	white := Color{1, 1, 1}
+	textures := []Texture{
+		NoiseTexture{Color: white, Scale: 4},
+		TurbulenceTexture{Color: white},
+		MarbleTexture{Color: white, Scale: 2},
+	}
+
+	for _, texture := range textures {
+		var min, max float64 = 1, 0
+		for i := 0; i < 1000; i++ {
+			p := Point3{float64(i) * 0.037, float64(i%17) * 0.11, float64(i%5) * 0.7}
+			c := texture.Value(0, 0, p)
+			if c[0] != c[1] || c[1] != c[2] {
+				t.Fatalf("%T at %v: %v, want a shade of Color", texture, p, c)
+			}
+			if c[0] < min {
+				min = c[0]
+			}
+			if c[0] > max {
+				max = c[0]
+			}
+			if c != texture.Value(0, 0, p) {
+				t.Fatalf("%T at %v: differs between lookups", texture, p)
+			}
+		}
+		if min < 0 || max-min < 0.2 {
+			t.Errorf("%T: values in [%v, %v], want varying and positive", texture, min, max)
+		}
+	}
+}
+
+func TestJSONTextures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	writeTestPNG(t, path, 1, 1, func(x, y int) [3]uint8 { return [3]uint8{255, 255, 255} })
+
+	const camera = `"camera": {"aspect_ratio": 1, "vfov": 90, "look_from": [0, 0, 1], "look_at": [0, 0, 0], "vup": [0, 1, 0]}`
+	scene := func(texture string) string {
+		return `{"version": 1, ` + camera + `, "materials": {"m": {"type": "lambertian", "albedo_texture": ` + texture + `}}, "shapes": [` +
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "m"}]}`
+	}
+
+	tests := []struct {
+		name, texture string
+		want          Texture
+	}{
+		{"color", `[0.5, 0.25, 1]`, ConstantTexture{Color: Color{0.5, 0.25, 1}}},
+		{"checker", `{"type": "checker", "even": [1, 1, 1], "odd": {"type": "noise", "color": [1, 0, 0]}, "scale": 0.5}`,
+			CheckerTexture{Even: ConstantTexture{Color: Color{1, 1, 1}}, Odd: NoiseTexture{Color: Color{1, 0, 0}}, Scale: 0.5}},
+		{"marble", `{"type": "marble", "color": [1, 1, 1], "octaves": 3}`, MarbleTexture{Color: Color{1, 1, 1}, Octaves: 3}},
+		{"image", `{"type": "image", "path": ` + strconv.Quote(path) + `, "wrap": "mirror"}`, nil},
+	}
+
+	for _, test := range tests {
+		decoded, err := DecodeJSONScene(strings.NewReader(scene(test.texture)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		texture := decoded.HitterList[0].(*Sphere).Material.(Lambertian).AlbedoTexture
+
+		if image, ok := texture.(*ImageTexture); ok {
+			if image.Path != path || image.Wrap != WrapMirror || image.Value(0.5, 0.5, Point3{}) != (Color{1, 1, 1}) {
+				t.Errorf("%s: decoded %#v", test.name, image)
+			}
+			continue
+		}
+		if texture != test.want {
+			t.Errorf("%s: decoded %#v, want %#v", test.name, texture, test.want)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeJSONScene(&buf, decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"type": "checker", "even": [1, 1, 1]}`,
+		`{"type": "image", "path": ` + strconv.Quote(path) + `, "wrap": "tile"}`,
+	} {
+		if _, err := DecodeJSONScene(strings.NewReader(scene(bad))); err == nil {
+			t.Errorf("decoded texture %s", bad)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package tracer
+
+import "fmt"
+
+// Tile is the rectangle of pixels rendered by a job: rows [Row0, Row1) and
+// columns [Col0, Col1) of the frame.
+type Tile struct {
+	Row0, Col0 int
+	Row1, Col1 int
+}
+
+func (t Tile) Width() int {
+	return t.Col1 - t.Col0
+}
+
+func (t Tile) Height() int {
+	return t.Row1 - t.Row0
+}
+
+// TileOrder is how a frame is split into jobs and the order they are
+// dispatched in.
+type TileOrder int
+
+const (
+	// One job per row, in row order
+	TileOrderRows TileOrder = iota
+	// Square tiles, row after row
+	TileOrderScanline
+	// Square tiles, spiraling out of the center of the frame
+	TileOrderSpiral
+	// Square tiles, along a Hilbert curve
+	TileOrderHilbert
+)
+
+const DefaultTileSize = 16
+
+func (order TileOrder) String() string {
+	switch order {
+	case TileOrderRows:
+		return "rows"
+	case TileOrderScanline:
+		return "scanline"
+	case TileOrderSpiral:
+		return "spiral"
+	case TileOrderHilbert:
+		return "hilbert"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseTileOrder(s string) (TileOrder, error) {
+	for order := TileOrderRows; order <= TileOrderHilbert; order++ {
+		if order.String() == s {
+			return order, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tile order %q", s)
+}
+
+// Tiles splits a width x height frame into size x size tiles (smaller along
+// the right and top edges), in the given order. For TileOrderRows, size is
+// ignored and every row is a tile.
+func Tiles(width, height, size int, order TileOrder) []Tile {
+	if order == TileOrderRows {
+		tiles := make([]Tile, height)
+		for row := range tiles {
+			tiles[row] = Tile{Row0: row, Col0: 0, Row1: row + 1, Col1: width}
+		}
+		return tiles
+	}
+
+	if size <= 0 {
+		size = DefaultTileSize
+	}
+
+	cols := (width + size - 1) / size
+	rows := (height + size - 1) / size
+
+	tile := func(x, y int) Tile {
+		return Tile{
+			Row0: y * size,
+			Col0: x * size,
+			Row1: minInt((y+1)*size, height),
+			Col1: minInt((x+1)*size, width),
+		}
+	}
+
+	tiles := make([]Tile, 0, cols*rows)
+
+	switch order {
+	case TileOrderSpiral:
+		x, y := (cols-1)/2, (rows-1)/2
+		dx, dy := 1, 0
+		// walk a square spiral (1 right, 1 up, 2 left, 2 down, 3 right...)
+		// until it has covered the whole grid, skipping positions outside it
+		for steps := 1; len(tiles) < cols*rows; steps++ {
+			for turn := 0; turn < 2; turn++ {
+				for i := 0; i < steps; i++ {
+					if x >= 0 && x < cols && y >= 0 && y < rows {
+						tiles = append(tiles, tile(x, y))
+					}
+					x, y = x+dx, y+dy
+				}
+				dx, dy = -dy, dx
+			}
+		}
+	case TileOrderHilbert:
+		n := 1
+		for n < cols || n < rows {
+			n *= 2
+		}
+		for d := 0; d < n*n; d++ {
+			x, y := hilbertPoint(n, d)
+			if x < cols && y < rows {
+				tiles = append(tiles, tile(x, y))
+			}
+		}
+	default:
+		for y := 0; y < rows; y++ {
+			for x := 0; x < cols; x++ {
+				tiles = append(tiles, tile(x, y))
+			}
+		}
+	}
+
+	return tiles
+}
+
+// hilbertPoint maps d to the coordinates of the d-th point along the Hilbert
+// curve filling a n x n grid, n a power of 2.
+func hilbertPoint(n, d int) (x, y int) {
+	for s := 1; s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x, s-1-y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return x, y
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
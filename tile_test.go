@@ -0,0 +1,53 @@
+package tracer
+
+import "testing"
+
+func TestTilesCoverFrame(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {17, 5}, {33, 47}, {100, 3}, {64, 64}}
+
+	for order := TileOrderRows; order <= TileOrderHilbert; order++ {
+		for _, size := range sizes {
+			for _, tileSize := range []int{0, 7, 16} {
+				width, height := size[0], size[1]
+				covered := make([]int, width*height)
+
+				for _, tile := range Tiles(width, height, tileSize, order) {
+					if tile.Width() <= 0 || tile.Height() <= 0 || tile.Row0 < 0 || tile.Col0 < 0 || tile.Row1 > height || tile.Col1 > width {
+						t.Errorf("%v %dx%d/%d: tile %+v empty or out of the frame", order, width, height, tileSize, tile)
+						continue
+					}
+					for row := tile.Row0; row < tile.Row1; row++ {
+						for col := tile.Col0; col < tile.Col1; col++ {
+							covered[row*width+col]++
+						}
+					}
+				}
+
+				for i, n := range covered {
+					if n != 1 {
+						t.Errorf("%v %dx%d/%d: pixel (%d, %d) covered %d times", order, width, height, tileSize, i/width, i%width, n)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTilesSpiralStartsAtCenter(t *testing.T) {
+	tiles := Tiles(50, 30, 10, TileOrderSpiral)
+	if first := tiles[0]; first != (Tile{Row0: 10, Col0: 20, Row1: 20, Col1: 30}) {
+		t.Errorf("first tile %+v, want the center one", first)
+	}
+}
+
+func TestParseTileOrder(t *testing.T) {
+	for order := TileOrderRows; order <= TileOrderHilbert; order++ {
+		if got, err := ParseTileOrder(order.String()); err != nil || got != order {
+			t.Errorf("%v: parsed %v, %v", order, got, err)
+		}
+	}
+	if _, err := ParseTileOrder("zigzag"); err == nil {
+		t.Error("no error for an unknown order")
+	}
+}
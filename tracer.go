@@ -1,57 +1,32 @@
 package tracer
 
-type RayColorFunc func(ray Ray, hitter Hitter, depth int, bounces int) Color
-type AggColorFunc func([]Color) Color
-
-var Transparent = Color{-1, -1, -1}
+import "context"
 
-var Render func(RenderSettings, <-chan bool)
-
-func RayColor(ray Ray, scene Hitter, depth, bounces int) Color {
-	if bounces >= depth {
-		return Transparent
-	}
-
-	hr := scene.Hit(ray)
-	if !hr.Hit {
-		unitDirection := ray.Direction.Unit()
-		t := 0.5 * (unitDirection[1] + 1.0)
-		return Color(Vec3{1, 1, 1}.MulFloat(1.0 - t).Add(Vec3{0.5, 0.7, 1.0}.MulFloat(t)))
-	}
-
-	sr := hr.Material.Scatter(ray, hr)
-	if !sr.Scatter {
-		return Transparent
-	}
+type AggColorFunc func([]Color) Color
 
-	return Color(Vec3(sr.Attenuation).MulVec3(Vec3(RayColor(sr.Ray, scene, depth, bounces+1))))
+// RayState is the state of a render worker threaded through Integrator
+// calls. Integrators count every ray they trace in it, the renderer counts
+// the camera rays. Sampler is started for every camera sample, all the
+// randomness of a sample must come from it.
+type RayState struct {
+	Sampler     Sampler
+	PrimaryRays uint64
+	Rays        uint64
+	NodeVisits  uint64
 }
 
-func RayBVHID(ray Ray, scene Hitter, _, _ int) Color {
-	hr := scene.Hit(ray)
-	if !hr.Hit {
-		return Transparent
-	}
-
-	return Uint64ToColor(hr.BVHNode.ID)
+// trace records a ray and the BVH nodes its hit visited.
+func (state *RayState) trace(hr HitRecord) {
+	state.Rays++
+	state.NodeVisits += uint64(hr.NodeVisits)
 }
 
-func RayDistance(ray Ray, n Hitter, _, _ int) Color {
-	hr := n.Hit(ray)
-	if !hr.Hit {
-		return Color{}
-	}
-
-	distVec := Vec3(ray.Origin).Sub(Vec3(hr.P))
-	dist := distVec.Len()
-
-	if dist > 100 {
-		return Color{}
-	}
+var Transparent = Color{-1, -1, -1}
 
-	col := (-25.5*dist + 255.0) / 255.0
-	return Color{col, col, col}
-}
+var (
+	Render        func(RenderSettings, <-chan bool)
+	RenderContext func(context.Context, RenderSettings) (RenderStats, error)
+)
 
 func ColorToUint64(color Color) uint64 {
 	if color.Transparent() {
@@ -98,6 +73,10 @@ func EdgeSamples(samples []Color) Color {
 type Ray struct {
 	Origin    Point3
 	Direction Vec3
+	// Wavelength, in nanometers, of the light the ray carries, or zero for
+	// all of them (RGB). Dispersive materials pick one for the rest of the
+	// path, which integrators pass on to the rays they scatter.
+	Wavelength float64
 }
 
 func (r Ray) At(t float64) Point3 {
@@ -0,0 +1,277 @@
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+type Triangle struct {
+	Vertices [3]Point3 `json:"vertices"`
+	// Per-vertex shading normals, all set or all zero for the face normal
+	Normals  [3]Vec3       `json:"normals"`
+	UVs      [3][2]float64 `json:"uvs"`
+	Material Material      `json:"material"`
+	Box      AABB          `json:"-"`
+}
+
+func NewTriangle(v0, v1, v2 Point3, material Material) *Triangle {
+	return &Triangle{
+		Vertices: [3]Point3{v0, v1, v2},
+		UVs:      [3][2]float64{{0, 0}, {1, 0}, {0, 1}},
+		Material: material,
+		Box:      triangleBox(v0, v1, v2),
+	}
+}
+
+func (tr *Triangle) finishDecode() error {
+	if tr.UVs == [3][2]float64{} {
+		tr.UVs = [3][2]float64{{0, 0}, {1, 0}, {0, 1}}
+	}
+	tr.Box = triangleBox(tr.Vertices[0], tr.Vertices[1], tr.Vertices[2])
+	return tr.validate()
+}
+
+func (tr Triangle) Hit(r Ray) HitRecord {
+	t, b1, b2, ok := intersectTriangle(r, tr.Vertices[0], tr.Vertices[1], tr.Vertices[2])
+	if !ok {
+		return HitRecord{}
+	}
+
+	var normals *[3]Vec3
+	if !tr.Normals[0].Zero() && !tr.Normals[1].Zero() && !tr.Normals[2].Zero() {
+		normals = &tr.Normals
+	}
+
+	return triangleHitRecord(r, t, b1, b2, tr.Vertices, normals, &tr.UVs, tr.Material)
+}
+
+// validate rejects triangles with only some of their vertex normals set.
+func (tr *Triangle) validate() error {
+	set := 0
+	for _, n := range tr.Normals {
+		if !n.Zero() {
+			set++
+		}
+	}
+	if set != 0 && set != 3 {
+		return fmt.Errorf("triangle has %d of 3 vertex normals", set)
+	}
+	return nil
+}
+
+func (tr Triangle) BoundingBox() AABB {
+	return tr.Box
+}
+
+// TriangleMesh is an indexed triangle mesh. Vertex attributes are stored once
+// and shared by every triangle, which only costs an index into the mesh.
+type TriangleMesh struct {
+	Positions []Point3 `json:"positions"`
+	// Optional, indexed like Positions
+	Normals []Vec3 `json:"normals,omitempty"`
+	// Optional, indexed like Positions
+	UVs [][2]float64 `json:"uvs,omitempty"`
+	// Three indices into Positions per triangle
+	Indices  []int    `json:"indices"`
+	Material Material `json:"material"`
+
+	bvh *BVHNode
+}
+
+func NewTriangleMesh(positions []Point3, normals []Vec3, uvs [][2]float64, indices []int, material Material) (*TriangleMesh, error) {
+	m := &TriangleMesh{
+		Positions: positions,
+		Normals:   normals,
+		UVs:       uvs,
+		Indices:   indices,
+		Material:  material,
+	}
+	if err := m.Build(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Build validates the mesh buffers and (re)builds its internal BVH. It must be
+// called whenever the buffers change.
+func (m *TriangleMesh) Build() error {
+	switch {
+	case len(m.Indices) == 0:
+		return errors.New("mesh has no triangles")
+	case len(m.Indices)%3 != 0:
+		return fmt.Errorf("mesh has %d indices, not a multiple of 3", len(m.Indices))
+	case len(m.Normals) != 0 && len(m.Normals) != len(m.Positions):
+		return fmt.Errorf("mesh has %d normals for %d positions", len(m.Normals), len(m.Positions))
+	case len(m.UVs) != 0 && len(m.UVs) != len(m.Positions):
+		return fmt.Errorf("mesh has %d uvs for %d positions", len(m.UVs), len(m.Positions))
+	}
+
+	for i, idx := range m.Indices {
+		if idx < 0 || idx >= len(m.Positions) {
+			return fmt.Errorf("index %d (%d) out of range", i, idx)
+		}
+	}
+
+	for i, n := range m.Normals {
+		if n.Zero() {
+			return fmt.Errorf("normal %d is zero", i)
+		}
+	}
+
+	triangles := make(HitterList, len(m.Indices)/3)
+	for i := range triangles {
+		triangles[i] = meshTriangle{mesh: m, index: 3 * i}
+	}
+
+	bvh, err := NewBVHNode(triangles)
+	if err != nil {
+		return err
+	}
+	m.bvh = bvh
+
+	return nil
+}
+
+func (m *TriangleMesh) finishDecode() error {
+	return m.Build()
+}
+
+func (m *TriangleMesh) Hit(r Ray) HitRecord {
+	return m.bvh.Hit(r)
+}
+
+func (m *TriangleMesh) BoundingBox() AABB {
+	return m.bvh.BoundingBox()
+}
+
+// meshTriangle is a TriangleMesh triangle. index is the position of its first
+// vertex index in mesh.Indices.
+type meshTriangle struct {
+	mesh  *TriangleMesh
+	index int
+}
+
+func (mt meshTriangle) vertices() [3]Point3 {
+	idx := mt.mesh.Indices[mt.index : mt.index+3]
+	return [3]Point3{mt.mesh.Positions[idx[0]], mt.mesh.Positions[idx[1]], mt.mesh.Positions[idx[2]]}
+}
+
+func (mt meshTriangle) Hit(r Ray) HitRecord {
+	vertices := mt.vertices()
+	t, b1, b2, ok := intersectTriangle(r, vertices[0], vertices[1], vertices[2])
+	if !ok {
+		return HitRecord{}
+	}
+
+	idx := mt.mesh.Indices[mt.index : mt.index+3]
+
+	var normals *[3]Vec3
+	if len(mt.mesh.Normals) > 0 {
+		normals = &[3]Vec3{mt.mesh.Normals[idx[0]], mt.mesh.Normals[idx[1]], mt.mesh.Normals[idx[2]]}
+	}
+
+	uvs := &[3][2]float64{{0, 0}, {1, 0}, {0, 1}}
+	if len(mt.mesh.UVs) > 0 {
+		uvs = &[3][2]float64{mt.mesh.UVs[idx[0]], mt.mesh.UVs[idx[1]], mt.mesh.UVs[idx[2]]}
+	}
+
+	return triangleHitRecord(r, t, b1, b2, vertices, normals, uvs, mt.mesh.Material)
+}
+
+func (mt meshTriangle) BoundingBox() AABB {
+	vertices := mt.vertices()
+	return triangleBox(vertices[0], vertices[1], vertices[2])
+}
+
+func triangleBox(v0, v1, v2 Point3) AABB {
+	box := AABB{v0, v0}.Surrounding(AABB{v1, v1}).Surrounding(AABB{v2, v2})
+	return box.Pad(0.0001)
+}
+
+// intersectTriangle implements the Möller–Trumbore algorithm. b1 and b2 are the
+// barycentric coordinates of the hit point relative to v1 and v2.
+func intersectTriangle(r Ray, v0, v1, v2 Point3) (t, b1, b2 float64, ok bool) {
+	edge1 := Vec3(v1).Sub(Vec3(v0))
+	edge2 := Vec3(v2).Sub(Vec3(v0))
+
+	pvec := r.Direction.Cross(edge2)
+	det := edge1.Dot(pvec)
+	if math.Abs(det) < 1e-12 {
+		return 0, 0, 0, false
+	}
+	invDet := 1.0 / det
+
+	tvec := Vec3(r.Origin).Sub(Vec3(v0))
+	b1 = tvec.Dot(pvec) * invDet
+	if b1 < 0 || b1 > 1 {
+		return 0, 0, 0, false
+	}
+
+	qvec := tvec.Cross(edge1)
+	b2 = r.Direction.Dot(qvec) * invDet
+	if b2 < 0 || b1+b2 > 1 {
+		return 0, 0, 0, false
+	}
+
+	t = edge2.Dot(qvec) * invDet
+	if t < 0.0001 {
+		return 0, 0, 0, false
+	}
+
+	return t, b1, b2, true
+}
+
+func triangleHitRecord(r Ray, t, b1, b2 float64, vertices [3]Point3, normals *[3]Vec3, uvs *[3][2]float64, material Material) HitRecord {
+	b0 := 1 - b1 - b2
+
+	hr := HitRecord{
+		Hit:      true,
+		T:        t,
+		P:        r.At(t),
+		U:        b0*uvs[0][0] + b1*uvs[1][0] + b2*uvs[2][0],
+		V:        b0*uvs[0][1] + b1*uvs[1][1] + b2*uvs[2][1],
+		Material: material,
+	}
+
+	faceNormal := Vec3(vertices[1]).Sub(Vec3(vertices[0])).Cross(Vec3(vertices[2]).Sub(Vec3(vertices[0]))).Unit()
+	hr.FrontFace = r.Direction.Dot(faceNormal) < 0
+
+	hr.GeometricNormal = faceNormal
+	hr.Normal = faceNormal
+	if normals != nil {
+		hr.Normal = normals[0].MulFloat(b0).Add(normals[1].MulFloat(b1)).Add(normals[2].MulFloat(b2)).Unit()
+		// keep the interpolated normal on the same side as the face
+		if hr.Normal.Dot(faceNormal) < 0 {
+			hr.Normal = hr.Normal.Neg()
+		}
+	}
+	if !hr.FrontFace {
+		hr.GeometricNormal = hr.GeometricNormal.Neg()
+		hr.Normal = hr.Normal.Neg()
+	}
+
+	dpdu, dpdv := triangleDerivatives(vertices, uvs)
+	hr.setTangents(dpdu, dpdv)
+
+	return hr
+}
+
+// triangleDerivatives returns the derivatives of points of the triangle along
+// its U and V coordinates, or its edges when the UVs are degenerate.
+func triangleDerivatives(vertices [3]Point3, uvs *[3][2]float64) (dpdu, dpdv Vec3) {
+	dp02 := Vec3(vertices[0]).Sub(Vec3(vertices[2]))
+	dp12 := Vec3(vertices[1]).Sub(Vec3(vertices[2]))
+	du02, dv02 := uvs[0][0]-uvs[2][0], uvs[0][1]-uvs[2][1]
+	du12, dv12 := uvs[1][0]-uvs[2][0], uvs[1][1]-uvs[2][1]
+
+	det := du02*dv12 - dv02*du12
+	if math.Abs(det) < 1e-12 {
+		return Vec3(vertices[1]).Sub(Vec3(vertices[0])), Vec3(vertices[2]).Sub(Vec3(vertices[0]))
+	}
+
+	invDet := 1 / det
+	dpdu = dp02.MulFloat(dv12 * invDet).Sub(dp12.MulFloat(dv02 * invDet))
+	dpdv = dp12.MulFloat(du02 * invDet).Sub(dp02.MulFloat(du12 * invDet))
+	return dpdu, dpdv
+}
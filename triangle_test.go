@@ -0,0 +1,166 @@
+package tracer
+
+import "testing"
+
+func TestTriangleHit(t *testing.T) {
+	tr := NewTriangle(Point3{0, 0, 0}, Point3{1, 0, 0}, Point3{0, 1, 0}, Lambertian{})
+
+	tests := []struct {
+		name      string
+		ray       Ray
+		hit       bool
+		t         float64
+		u, v      float64
+		frontFace bool
+	}{
+		{"front", Ray{Origin: Point3{0.25, 0.5, 1}, Direction: Vec3{0, 0, -1}}, true, 1, 0.25, 0.5, true},
+		{"back", Ray{Origin: Point3{0.25, 0.25, -2}, Direction: Vec3{0, 0, 1}}, true, 2, 0.25, 0.25, false},
+		{"outside", Ray{Origin: Point3{0.75, 0.75, 1}, Direction: Vec3{0, 0, -1}}, false, 0, 0, 0, false},
+		{"parallel", Ray{Origin: Point3{0.25, 0.25, 1}, Direction: Vec3{1, 0, 0}}, false, 0, 0, 0, false},
+		{"behind", Ray{Origin: Point3{0.25, 0.25, 1}, Direction: Vec3{0, 0, 1}}, false, 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		hr := tr.Hit(test.ray)
+		if hr.Hit != test.hit {
+			t.Errorf("%s: hit %v, want %v", test.name, hr.Hit, test.hit)
+			continue
+		}
+		if !hr.Hit {
+			continue
+		}
+		if !approxEqual(hr.T, test.t, testEpsilon) || !approxEqual(hr.U, test.u, testEpsilon) || !approxEqual(hr.V, test.v, testEpsilon) {
+			t.Errorf("%s: t %v uv (%v, %v), want %v (%v, %v)", test.name, hr.T, hr.U, hr.V, test.t, test.u, test.v)
+		}
+		if hr.FrontFace != test.frontFace {
+			t.Errorf("%s: front face %v, want %v", test.name, hr.FrontFace, test.frontFace)
+		}
+		if hr.Normal.Dot(test.ray.Direction) >= 0 {
+			t.Errorf("%s: normal %v doesn't face the ray", test.name, hr.Normal)
+		}
+	}
+}
+
+func TestTriangleInterpolatedNormal(t *testing.T) {
+	tr := NewTriangle(Point3{0, 0, 0}, Point3{1, 0, 0}, Point3{0, 1, 0}, Lambertian{})
+	// vertex normals flipped against the face are kept on its side
+	tr.Normals = [3]Vec3{{0, 0, -1}, {-1, 0, -1}, {0, 0, -1}}
+
+	hr := tr.Hit(Ray{Origin: Point3{0.5, 0.25, 1}, Direction: Vec3{0, 0, -1}})
+	want := Vec3{0.5, 0, 1}.Unit()
+	if !approxVec3(hr.Normal, want, 1e-6) {
+		t.Errorf("normal %v, want %v", hr.Normal, want)
+	}
+}
+
+func TestTriangleShadingFrame(t *testing.T) {
+	tr := NewTriangle(Point3{0, 0, 0}, Point3{2, 0, 0}, Point3{0, 2, 0}, Lambertian{})
+	// U along -Y and V along +X
+	tr.UVs = [3][2]float64{{1, 0}, {1, 1}, {0, 0}}
+
+	for _, ray := range []Ray{
+		{Origin: Point3{0.5, 0.5, 1}, Direction: Vec3{0, 0, -1}},
+		{Origin: Point3{0.5, 0.5, -1}, Direction: Vec3{0, 0, 1}},
+	} {
+		hr := tr.Hit(ray)
+		if !approxVec3(hr.GeometricNormal, ray.Direction.Neg(), testEpsilon) {
+			t.Errorf("from %v: geometric normal %v, want the face's towards the ray", ray.Origin, hr.GeometricNormal)
+		}
+		if !approxEqual(hr.U, 0.75, testEpsilon) || !approxEqual(hr.V, 0.25, testEpsilon) {
+			t.Errorf("from %v: uv (%v, %v), want (0.75, 0.25)", ray.Origin, hr.U, hr.V)
+		}
+		checkShadingFrame(t, "triangle", hr)
+		if !approxVec3(hr.Tangent, Vec3{0, -1, 0}, testEpsilon) || !approxVec3(hr.Bitangent, Vec3{1, 0, 0}, testEpsilon) {
+			t.Errorf("from %v: tangent %v and bitangent %v, want towards +U and +V", ray.Origin, hr.Tangent, hr.Bitangent)
+		}
+	}
+
+	// shading normals tilt the frame, but not the geometric normal
+	tr.Normals = [3]Vec3{{1, 0, 1}, {1, 0, 1}, {1, 0, 1}}
+	hr := tr.Hit(Ray{Origin: Point3{0.5, 0.5, 1}, Direction: Vec3{0, 0, -1}})
+	if !approxVec3(hr.GeometricNormal, Vec3{0, 0, 1}, testEpsilon) || !approxVec3(hr.Normal, Vec3{1, 0, 1}.Unit(), testEpsilon) {
+		t.Errorf("normals %v and %v, want the face's and the vertices'", hr.GeometricNormal, hr.Normal)
+	}
+	checkShadingFrame(t, "tilted", hr)
+
+	// degenerate UVs fall back to the edges
+	tr.UVs = [3][2]float64{}
+	checkShadingFrame(t, "degenerate", tr.Hit(Ray{Origin: Point3{0.5, 0.5, 1}, Direction: Vec3{0, 0, -1}}))
+}
+
+func TestTriangleMixedNormals(t *testing.T) {
+	tr := NewTriangle(Point3{0, 0, 0}, Point3{1, 0, 0}, Point3{0, 1, 0}, Lambertian{})
+	if err := tr.validate(); err != nil {
+		t.Errorf("no normals: %v", err)
+	}
+
+	tr.Normals[1] = Vec3{1, 0, 1}
+	if err := tr.validate(); err == nil {
+		t.Error("no error for a single vertex normal")
+	}
+	// the face normal is used until all three are set
+	hr := tr.Hit(Ray{Origin: Point3{0.5, 0.25, 1}, Direction: Vec3{0, 0, -1}})
+	if !approxVec3(hr.Normal, Vec3{0, 0, 1}, testEpsilon) {
+		t.Errorf("normal %v, want the face's", hr.Normal)
+	}
+
+	tr.Normals = [3]Vec3{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	if err := tr.validate(); err != nil {
+		t.Errorf("all normals: %v", err)
+	}
+}
+
+func TestTriangleMeshBuild(t *testing.T) {
+	positions := []Point3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+
+	tests := []struct {
+		name    string
+		normals []Vec3
+		uvs     [][2]float64
+		indices []int
+		ok      bool
+	}{
+		{"quad", nil, nil, []int{0, 1, 2, 0, 2, 3}, true},
+		{"attributes", []Vec3{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1}}, make([][2]float64, 4), []int{0, 1, 2}, true},
+		{"no triangles", nil, nil, nil, false},
+		{"partial triangle", nil, nil, []int{0, 1, 2, 3}, false},
+		{"index out of range", nil, nil, []int{0, 1, 4}, false},
+		{"negative index", nil, nil, []int{0, 1, -1}, false},
+		{"normals count", make([]Vec3, 3), nil, []int{0, 1, 2}, false},
+		{"zero normal", []Vec3{{0, 0, 1}, {0, 0, 1}, {}, {0, 0, 1}}, nil, []int{0, 1, 2}, false},
+		{"uvs count", nil, make([][2]float64, 5), []int{0, 1, 2}, false},
+	}
+
+	for _, test := range tests {
+		_, err := NewTriangleMesh(positions, test.normals, test.uvs, test.indices, Lambertian{})
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestTriangleMeshHit(t *testing.T) {
+	// a unit quad at z = 0 and one at z = -1 behind it
+	positions := []Point3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}, {0, 0, -1}, {1, 0, -1}, {1, 1, -1}, {0, 1, -1}}
+	uvs := [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	indices := []int{4, 5, 6, 4, 6, 7, 0, 1, 2, 0, 2, 3}
+	mesh, err := NewTriangleMesh(positions, nil, uvs, indices, Lambertian{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range [][2]float64{{0.2, 0.7}, {0.7, 0.2}, {0.5, 0.5}} {
+		hr := mesh.Hit(Ray{Origin: Point3{p[0], p[1], 1}, Direction: Vec3{0, 0, -1}})
+		if !hr.Hit || !approxEqual(hr.T, 1, testEpsilon) {
+			t.Errorf("%v: hit %v at %v, want the nearest quad at 1", p, hr.Hit, hr.T)
+			continue
+		}
+		if !approxEqual(hr.U, p[0], 1e-9) || !approxEqual(hr.V, p[1], 1e-9) {
+			t.Errorf("%v: uv (%v, %v)", p, hr.U, hr.V)
+		}
+	}
+
+	if hr := mesh.Hit(Ray{Origin: Point3{1.5, 0.5, 1}, Direction: Vec3{0, 0, -1}}); hr.Hit {
+		t.Errorf("hit outside the mesh at %v", hr.P)
+	}
+}